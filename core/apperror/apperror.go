@@ -0,0 +1,111 @@
+package apperror
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Error is a domain error that knows how it is presented to API clients.
+// Fields holds per-field messages for validation failures.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errors with the same code, so that copies made by WithField
+// and Wrap still match the sentinel they came from.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithField returns a copy of the error with a message for field added.
+func (e *Error) WithField(field, message string) *Error {
+	c := *e
+	c.Fields = make(map[string]string, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		c.Fields[k] = v
+	}
+	c.Fields[field] = message
+	return &c
+}
+
+// Wrap returns a copy of the error that carries err as its cause.
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+var (
+	ErrBadRequest       = New(http.StatusBadRequest, "bad_request", "bad request")
+	ErrValidation       = New(http.StatusUnprocessableEntity, "validation_failed", "validation failed")
+	ErrUnauthorized     = New(http.StatusUnauthorized, "unauthorized", "authentication required")
+	ErrForbidden        = New(http.StatusForbidden, "forbidden", "permission denied")
+	ErrNotFound         = New(http.StatusNotFound, "not_found", "not found")
+	ErrMethodNotAllowed = New(http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+	ErrConflict         = New(http.StatusConflict, "conflict", "already exists")
+	ErrInternal         = New(http.StatusInternalServerError, "internal", "internal server error")
+)
+
+// From converts any error into an *Error. Database errors from any of the
+// supported drivers are mapped to not-found and conflict, everything else
+// unknown becomes an internal error that keeps the original as its cause.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound.Wrap(err)
+	}
+
+	if isUniqueViolation(err) {
+		return ErrConflict.Wrap(err)
+	}
+
+	return ErrInternal.Wrap(err)
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+
+	return false
+}
@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+
+	AccessTokenDuration = 15 * time.Minute
+)
+
+var (
+	ErrNoKeys       = apperror.New(http.StatusNotImplemented, "tokens_disabled", "token signing is not configured")
+	ErrNoPublicKey  = apperror.New(http.StatusNotImplemented, "no_public_key", "the active signing key must be EdDSA or RS256")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Keys is the key set loaded from the environment at startup. It is nil when
+// JWT_KEYS is not set, which disables token mode.
+var Keys *KeySet
+
+type Key struct {
+	ID      string
+	Alg     string
+	Secret  []byte
+	Private ed25519.PrivateKey
+	Public  ed25519.PublicKey
+	RSA     *rsa.PrivateKey
+}
+
+// KeySet signs with the active key and verifies with any key it holds, so
+// that a retired key keeps validating tokens until they expire.
+type KeySet struct {
+	Active string
+	Keys   map[string]Key
+}
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	Email     string `json:"email,omitempty"`
+	Type      string `json:"typ"`
+	ID        string `json:"jti,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// LoadKeys reads JWT_KEYS, a comma separated list of kid:alg:base64key
+// entries, and JWT_KEY_ID, the kid used for signing. HS256 keys are raw
+// secrets of at least 32 bytes, EdDSA keys are 32 byte Ed25519 seeds and
+// RS256 keys are PKCS #8 or PKCS #1 DER private keys of at least 2048 bits.
+func LoadKeys() (*KeySet, error) {
+	raw := os.Getenv("JWT_KEYS")
+	if raw == "" {
+		return nil, nil
+	}
+
+	set := &KeySet{Active: os.Getenv("JWT_KEY_ID"), Keys: map[string]Key{}}
+	for _, entry := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid JWT_KEYS entry %q", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key material for %q: %w", parts[0], err)
+		}
+
+		key := Key{ID: parts[0], Alg: parts[1]}
+		switch key.Alg {
+		case AlgHS256:
+			if len(material) < 32 {
+				return nil, fmt.Errorf("key %q: HS256 secret must be at least 32 bytes", key.ID)
+			}
+			key.Secret = material
+		case AlgEdDSA:
+			if len(material) != ed25519.SeedSize {
+				return nil, fmt.Errorf("key %q: EdDSA seed must be %d bytes", key.ID, ed25519.SeedSize)
+			}
+			key.Private = ed25519.NewKeyFromSeed(material)
+			key.Public = key.Private.Public().(ed25519.PublicKey)
+		case AlgRS256:
+			private, err := parseRSAKey(material)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key.ID, err)
+			}
+			key.RSA = private
+		default:
+			return nil, fmt.Errorf("key %q: unsupported algorithm %q", key.ID, key.Alg)
+		}
+		set.Keys[key.ID] = key
+	}
+
+	if set.Active == "" && len(set.Keys) == 1 {
+		for id := range set.Keys {
+			set.Active = id
+		}
+	}
+	if _, ok := set.Keys[set.Active]; !ok {
+		return nil, fmt.Errorf("JWT_KEY_ID %q is not in JWT_KEYS", set.Active)
+	}
+
+	return set, nil
+}
+
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	var private *rsa.PrivateKey
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		private, _ = key.(*rsa.PrivateKey)
+	} else if private, err = x509.ParsePKCS1PrivateKey(der); err != nil {
+		return nil, errors.New("RS256 key must be a PKCS #8 or PKCS #1 RSA private key")
+	}
+	if private == nil || private.N.BitLen() < 2048 {
+		return nil, errors.New("RS256 key must be an RSA key of at least 2048 bits")
+	}
+	return private, nil
+}
+
+func (k Key) sign(input []byte) []byte {
+	switch k.Alg {
+	case AlgEdDSA:
+		return ed25519.Sign(k.Private, input)
+	case AlgRS256:
+		digest := sha256.Sum256(input)
+		signature, _ := rsa.SignPKCS1v15(nil, k.RSA, crypto.SHA256, digest[:])
+		return signature
+	}
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+func (k Key) verify(input, signature []byte) bool {
+	switch k.Alg {
+	case AlgEdDSA:
+		return ed25519.Verify(k.Public, input, signature)
+	case AlgRS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(&k.RSA.PublicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+	return hmac.Equal(k.sign(input), signature)
+}
+
+// JWK is the public half of the key as a JSON Web Key, or nil for HS256
+// keys, which have no public half.
+func (k Key) JWK() map[string]any {
+	switch k.Alg {
+	case AlgEdDSA:
+		return map[string]any{"kty": "OKP", "crv": "Ed25519", "kid": k.ID, "alg": k.Alg, "use": "sig", "x": base64.RawURLEncoding.EncodeToString(k.Public)}
+	case AlgRS256:
+		e := big.NewInt(int64(k.RSA.E)).Bytes()
+		return map[string]any{"kty": "RSA", "kid": k.ID, "alg": k.Alg, "use": "sig", "n": base64.RawURLEncoding.EncodeToString(k.RSA.N.Bytes()), "e": base64.RawURLEncoding.EncodeToString(e)}
+	}
+	return nil
+}
+
+// JWKS is the JSON Web Key Set of every public key in the set. Retired keys
+// stay listed while they are in JWT_KEYS, so that relying parties can still
+// verify tokens signed before a rotation.
+func (s *KeySet) JWKS() map[string]any {
+	keys := []map[string]any{}
+	if s != nil {
+		ids := make([]string, 0, len(s.Keys))
+		for id := range s.Keys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if jwk := s.Keys[id].JWK(); jwk != nil {
+				keys = append(keys, jwk)
+			}
+		}
+	}
+	return map[string]any{"keys": keys}
+}
+
+// Algs lists the algorithms of the public keys in the set.
+func (s *KeySet) Algs() []string {
+	var algs []string
+	if s != nil {
+		for _, key := range s.Keys {
+			if key.Alg != AlgHS256 && !slices.Contains(algs, key.Alg) {
+				algs = append(algs, key.Alg)
+			}
+		}
+	}
+	return algs
+}
+
+func (s *KeySet) Sign(claims Claims) (string, error) {
+	return s.SignClaims(claims)
+}
+
+// SignPublic signs claims, which can be any JSON object, with the active
+// key, which must have a public half so that third parties can verify the
+// token. It is used for OpenID Connect ID tokens.
+func (s *KeySet) SignPublic(claims any) (string, error) {
+	if s == nil {
+		return "", ErrNoKeys
+	}
+	if s.Keys[s.Active].Alg == AlgHS256 {
+		return "", ErrNoPublicKey
+	}
+	return s.SignClaims(claims)
+}
+
+// SignClaims signs any JSON object with the active key.
+func (s *KeySet) SignClaims(claims any) (string, error) {
+	if s == nil {
+		return "", ErrNoKeys
+	}
+
+	key := s.Keys[s.Active]
+	h, err := json.Marshal(header{Alg: key.Alg, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	signature := base64.RawURLEncoding.EncodeToString(key.sign([]byte(input)))
+	return input + "." + signature, nil
+}
+
+// Verify checks the signature with the key named by the token's kid and
+// returns its claims if it has not expired.
+func (s *KeySet) Verify(token string) (Claims, error) {
+	var claims Claims
+	if s == nil {
+		return claims, ErrNoKeys
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	key, ok := s.Keys[h.Kid]
+	if !ok || key.Alg != h.Alg {
+		return claims, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return claims, ErrInvalidToken
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+// AccessToken signs a short-lived access token for the user id.
+func (s *KeySet) AccessToken(userID int64, email string) (string, Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", Claims{}, err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		Subject:   fmt.Sprint(userID),
+		Email:     email,
+		Type:      "access",
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(AccessTokenDuration).Unix(),
+	}
+
+	token, err := s.Sign(claims)
+	return token, claims, err
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T) *KeySet {
+	t.Helper()
+	t.Setenv("JWT_KEYS", "hs:HS256:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("s"), 32))+
+		",ed:EdDSA:"+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("e"), ed25519.SeedSize)))
+	t.Setenv("JWT_KEY_ID", "ed")
+
+	keys, err := LoadKeys()
+	if err != nil {
+		t.Fatalf("LoadKeys: %v", err)
+	}
+	return keys
+}
+
+// forge builds a token with the header and claims given, signed with the
+// HS256 secret.
+func forge(header, claims string, secret []byte) string {
+	input := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	keys := testKeys(t)
+
+	token, claims, err := keys.AccessToken(7, "user@example.com")
+	if err != nil {
+		t.Fatalf("AccessToken: %v", err)
+	}
+	if got, err := keys.Verify(token); err != nil || got != claims {
+		t.Errorf("Verify = %+v, %v, want %+v", got, err, claims)
+	}
+
+	// A token of a retired key verifies while the key is in the set.
+	keys.Active = "hs"
+	retired, err := keys.Sign(Claims{Subject: "7", Type: "access", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	keys.Active = "ed"
+	if _, err := keys.Verify(retired); err != nil {
+		t.Errorf("Verify of a token of a retired key: %v", err)
+	}
+
+	expired, err := keys.Sign(Claims{Subject: "7", Type: "access", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := keys.Verify(expired); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify of an expired token = %v, want ErrExpiredToken", err)
+	}
+
+	if _, err := keys.Verify(token[:len(token)-2] + "AA"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify of a changed signature = %v, want ErrInvalidToken", err)
+	}
+	parts := strings.Split(token, ".")
+	changed := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","typ":"access","exp":9999999999}`))
+	if _, err := keys.Verify(parts[0] + "." + changed + "." + parts[2]); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify of changed claims = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestVerifyWrongKey(t *testing.T) {
+	keys := testKeys(t)
+	claims := `{"sub":"7","typ":"access","exp":9999999999}`
+
+	tests := map[string]string{
+		"unknown kid":    forge(`{"alg":"HS256","typ":"JWT","kid":"other"}`, claims, keys.Keys["hs"].Secret),
+		"missing kid":    forge(`{"alg":"HS256","typ":"JWT"}`, claims, keys.Keys["hs"].Secret),
+		"wrong secret":   forge(`{"alg":"HS256","typ":"JWT","kid":"hs"}`, claims, bytes.Repeat([]byte("x"), 32)),
+		"other key's id": forge(`{"alg":"HS256","typ":"JWT","kid":"ed"}`, claims, keys.Keys["hs"].Secret),
+		// Signing with the public key as an HMAC secret must not pass for
+		// the EdDSA key it belongs to.
+		"alg confusion": forge(`{"alg":"HS256","typ":"JWT","kid":"ed"}`, claims, keys.Keys["ed"].Public),
+		"alg none": base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT","kid":"ed"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".",
+		"malformed": "not-a-token",
+	}
+	for name, token := range tests {
+		if _, err := keys.Verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify of %s = %v, want ErrInvalidToken", name, err)
+		}
+	}
+
+	var none *KeySet
+	if _, err := none.Verify(forge(`{"alg":"HS256","typ":"JWT","kid":"hs"}`, claims, keys.Keys["hs"].Secret)); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("Verify without keys = %v, want ErrNoKeys", err)
+	}
+}
+
+func TestSignPublic(t *testing.T) {
+	keys := testKeys(t)
+
+	if _, err := keys.SignPublic(map[string]any{"sub": "7"}); err != nil {
+		t.Errorf("SignPublic with an EdDSA key: %v", err)
+	}
+	keys.Active = "hs"
+	if _, err := keys.SignPublic(map[string]any{"sub": "7"}); !errors.Is(err, ErrNoPublicKey) {
+		t.Errorf("SignPublic with an HS256 key = %v, want ErrNoPublicKey", err)
+	}
+
+	jwks := keys.JWKS()["keys"].([]map[string]any)
+	if len(jwks) != 1 || jwks[0]["kid"] != "ed" {
+		t.Errorf("JWKS = %v, want only the EdDSA key", jwks)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("s"), 32))
+	tests := []struct {
+		keys, active string
+		ok           bool
+	}{
+		{"", "", true},
+		{"a:HS256:" + secret, "", true},
+		{"a:HS256:" + secret, "b", false},
+		{"a:HS256:" + secret + ",b:HS256:" + secret, "", false},
+		{"a:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")), "", false},
+		{"a:EdDSA:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("e"), 16)), "", false},
+		{"a:RS256:" + secret, "", false},
+		{"a:none:" + secret, "", false},
+		{"a:HS256", "", false},
+		{"a:HS256:%%%", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("JWT_KEYS", tt.keys)
+		t.Setenv("JWT_KEY_ID", tt.active)
+		if _, err := LoadKeys(); (err == nil) != tt.ok {
+			t.Errorf("LoadKeys(%q, %q) = %v", tt.keys, tt.active, err)
+		}
+	}
+}
@@ -1,5 +1,51 @@
 package database
 
-import "github.com/jmoiron/sqlx"
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
 
 var DB *sqlx.DB
+
+// Drivers maps the supported DB_DRIVER values to their goose dialect. The
+// drivers themselves are registered by blank imports in main.
+var Drivers = map[string]string{
+	"sqlite3":  "sqlite3",
+	"postgres": "postgres",
+	"mysql":    "mysql",
+}
+
+// Open connects with driver, applying the DSN options the models rely on:
+// foreign keys for SQLite and time parsing for MySQL.
+func Open(driver, dsn string) (*sqlx.DB, error) {
+	if _, ok := Drivers[driver]; !ok {
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	switch driver {
+	case "sqlite3":
+		dsn = withOption(dsn, "_foreign_keys", "on")
+	case "mysql":
+		dsn = withOption(dsn, "parseTime", "true")
+	}
+
+	return sqlx.Open(driver, dsn)
+}
+
+// MigrationsDir is the directory holding the migrations for driver.
+func MigrationsDir(root, driver string) string {
+	return filepath.Join(root, Drivers[driver])
+}
+
+func withOption(dsn, key, value string) string {
+	if strings.Contains(dsn, key+"=") {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return fmt.Sprintf("%s&%s=%s", dsn, key, value)
+	}
+	return fmt.Sprintf("%s?%s=%s", dsn, key, value)
+}
@@ -0,0 +1,283 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into a self-describing string. Argon2id
+// and scrypt use the PHC string format ($id$params$salt$hash), bcrypt its
+// native $2a$ form, so hashes of every algorithm can live in one column.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, which must be a hash
+	// of this hasher's algorithm.
+	Verify(encoded, password string) (bool, error)
+	// Recognizes reports whether encoded was made with this algorithm.
+	Recognizes(encoded string) bool
+	// Current reports whether encoded was made with this algorithm and the
+	// hasher's current parameters.
+	Current(encoded string) bool
+}
+
+// Default hashes new passwords. It is set from the environment at startup.
+var Default PasswordHasher = DefaultArgon2id
+
+var (
+	DefaultBcrypt   = Bcrypt{Cost: bcrypt.DefaultCost}
+	DefaultArgon2id = Argon2id{Memory: 19 * 1024, Time: 2, Threads: 1}
+	DefaultScrypt   = Scrypt{LogN: 17, R: 8, P: 1}
+)
+
+// FromEnv builds the hasher named by PASSWORD_HASHER: argon2id (the
+// default), bcrypt or scrypt. BCRYPT_COST, ARGON2_MEMORY (KiB), ARGON2_TIME,
+// ARGON2_THREADS and SCRYPT_LOG_N override the parameters.
+func FromEnv() (PasswordHasher, error) {
+	var err error
+	// Values are checked against max, as they are narrowed to the types
+	// of the parameters and out of range ones would wrap around.
+	param := func(name string, value, max int) int {
+		raw := os.Getenv(name)
+		if raw == "" || err != nil {
+			return value
+		}
+		n, parseErr := strconv.Atoi(raw)
+		if parseErr != nil || n <= 0 || n > max {
+			err = fmt.Errorf("invalid %s %q: must be between 1 and %d", name, raw, max)
+		}
+		return n
+	}
+
+	var h PasswordHasher
+	switch name := os.Getenv("PASSWORD_HASHER"); name {
+	case "", "argon2id":
+		h = Argon2id{
+			Memory:  uint32(param("ARGON2_MEMORY", int(DefaultArgon2id.Memory), math.MaxUint32)),
+			Time:    uint32(param("ARGON2_TIME", int(DefaultArgon2id.Time), math.MaxUint32)),
+			Threads: uint8(param("ARGON2_THREADS", int(DefaultArgon2id.Threads), math.MaxUint8)),
+		}
+	case "bcrypt":
+		cost := param("BCRYPT_COST", DefaultBcrypt.Cost, bcrypt.MaxCost)
+		if err == nil && (cost < bcrypt.MinCost || cost > bcrypt.MaxCost) {
+			err = fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		h = Bcrypt{Cost: cost}
+	case "scrypt":
+		// N is 1<<LogN, which overflows an int on 32-bit platforms past 31.
+		h = Scrypt{LogN: uint8(param("SCRYPT_LOG_N", int(DefaultScrypt.LogN), 31)), R: DefaultScrypt.R, P: DefaultScrypt.P}
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Hash hashes password with Default.
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
+
+// Verify checks password against a hash made by any supported algorithm.
+// rehash is set when the password matched but the hash was not made by
+// Default with its current parameters, so it should be replaced.
+func Verify(encoded, password string) (ok, rehash bool, err error) {
+	h := Identify(encoded)
+	if h == nil {
+		return false, false, ErrUnknownHash
+	}
+
+	ok, err = h.Verify(encoded, password)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, !Default.Current(encoded), nil
+}
+
+// Identify returns a hasher able to verify encoded, or nil if the format is
+// unknown, for instance a plaintext password.
+func Identify(encoded string) PasswordHasher {
+	for _, h := range []PasswordHasher{Default, DefaultBcrypt, DefaultArgon2id, DefaultScrypt} {
+		if h.Recognizes(encoded) {
+			return h
+		}
+	}
+	return nil
+}
+
+func salt() ([]byte, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+var b64 = base64.RawStdEncoding
+
+// phc splits $id$params$salt$hash (or $id$v=N$params$salt$hash) into its
+// parameters, salt and hash.
+func phc(encoded, id string) (params string, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) == 6 {
+		parts = append(parts[:2], parts[3:]...)
+	}
+	if len(parts) != 5 || parts[0] != "" || parts[1] != id {
+		return "", nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = b64.DecodeString(parts[3]); err != nil {
+		return "", nil, nil, err
+	}
+	if key, err = b64.DecodeString(parts[4]); err != nil {
+		return "", nil, nil, err
+	}
+	return parts[2], salt, key, nil
+}
+
+type Bcrypt struct {
+	Cost int
+}
+
+func (h Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h Bcrypt) Recognizes(encoded string) bool {
+	_, err := bcrypt.Cost([]byte(encoded))
+	return err == nil
+}
+
+func (h Bcrypt) Current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == h.Cost
+}
+
+// Argon2id hashes with argon2id. Memory is in KiB.
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+const argon2KeyLen = 32
+
+func (h Argon2id) Hash(password string) (string, error) {
+	s, err := salt()
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), s, h.Time, h.Memory, h.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads, b64.EncodeToString(s), b64.EncodeToString(key)), nil
+}
+
+func (h Argon2id) decode(encoded string) (Argon2id, []byte, []byte, error) {
+	var p Argon2id
+	if !strings.HasPrefix(encoded, fmt.Sprintf("$argon2id$v=%d$", argon2.Version)) {
+		return p, nil, nil, ErrUnknownHash
+	}
+	params, s, key, err := phc(encoded, "argon2id")
+	if err != nil {
+		return p, nil, nil, err
+	}
+	if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	return p, s, key, nil
+}
+
+func (h Argon2id) Verify(encoded, password string) (bool, error) {
+	p, s, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), s, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h Argon2id) Recognizes(encoded string) bool {
+	_, _, _, err := h.decode(encoded)
+	return err == nil
+}
+
+func (h Argon2id) Current(encoded string) bool {
+	p, _, key, err := h.decode(encoded)
+	return err == nil && p == h && len(key) == argon2KeyLen
+}
+
+// Scrypt hashes with scrypt, N = 2^LogN.
+type Scrypt struct {
+	LogN uint8
+	R    int
+	P    int
+}
+
+const scryptKeyLen = 32
+
+func (h Scrypt) Hash(password string) (string, error) {
+	s, err := salt()
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), s, 1<<h.LogN, h.R, h.P, scryptKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.LogN, h.R, h.P, b64.EncodeToString(s), b64.EncodeToString(key)), nil
+}
+
+func (h Scrypt) decode(encoded string) (Scrypt, []byte, []byte, error) {
+	var p Scrypt
+	params, s, key, err := phc(encoded, "scrypt")
+	if err != nil {
+		return p, nil, nil, err
+	}
+	if _, err := fmt.Sscanf(params, "ln=%d,r=%d,p=%d", &p.LogN, &p.R, &p.P); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	return p, s, key, nil
+}
+
+func (h Scrypt) Verify(encoded, password string) (bool, error) {
+	p, s, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other, err := scrypt.Key([]byte(password), s, 1<<p.LogN, p.R, p.P, len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h Scrypt) Recognizes(encoded string) bool {
+	_, _, _, err := h.decode(encoded)
+	return err == nil
+}
+
+func (h Scrypt) Current(encoded string) bool {
+	p, _, key, err := h.decode(encoded)
+	return err == nil && p == h && len(key) == scryptKeyLen
+}
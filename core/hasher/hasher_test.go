@@ -0,0 +1,163 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters cheap enough for tests.
+var (
+	testBcrypt   = Bcrypt{Cost: bcrypt.MinCost}
+	testArgon2id = Argon2id{Memory: 64, Time: 1, Threads: 1}
+	testScrypt   = Scrypt{LogN: 4, R: 8, P: 1}
+)
+
+func setDefault(t *testing.T, h PasswordHasher) {
+	old := Default
+	Default = h
+	t.Cleanup(func() { Default = old })
+}
+
+func TestRoundTrip(t *testing.T) {
+	hashers := map[string]PasswordHasher{"bcrypt": testBcrypt, "argon2id": testArgon2id, "scrypt": testScrypt}
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := h.Hash("pässwörd 1")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if strings.Contains(encoded, "pässwörd") {
+				t.Fatalf("Hash = %q, contains the password", encoded)
+			}
+			if again, _ := h.Hash("pässwörd 1"); again == encoded {
+				t.Error("two hashes of a password are equal, want a new salt each")
+			}
+
+			if ok, err := h.Verify(encoded, "pässwörd 1"); !ok || err != nil {
+				t.Errorf("Verify of the password = %t, %v", ok, err)
+			}
+			if ok, err := h.Verify(encoded, "pässwörd 2"); ok || err != nil {
+				t.Errorf("Verify of another password = %t, %v", ok, err)
+			}
+			if !h.Recognizes(encoded) || !h.Current(encoded) {
+				t.Errorf("hash %q not recognized as current", encoded)
+			}
+			for other, o := range hashers {
+				if other != name && o.Recognizes(encoded) {
+					t.Errorf("%s recognizes hash %q", other, encoded)
+				}
+			}
+
+			// Any algorithm verifies through the package, whatever Default is.
+			setDefault(t, testBcrypt)
+			if ok, _, err := Verify(encoded, "pässwörd 1"); !ok || err != nil {
+				t.Errorf("package Verify = %t, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	setDefault(t, testArgon2id)
+	encoded, err := Hash("password1")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if ok, rehash, err := Verify(encoded, "password1"); !ok || rehash || err != nil {
+		t.Errorf("Verify with the current parameters = %t, %t, %v, want no rehash", ok, rehash, err)
+	}
+	if ok, rehash, err := Verify(encoded, "password2"); ok || rehash || err != nil {
+		t.Errorf("Verify of a wrong password = %t, %t, %v", ok, rehash, err)
+	}
+
+	for _, h := range []PasswordHasher{
+		Argon2id{Memory: 128, Time: 1, Threads: 1},
+		Argon2id{Memory: 64, Time: 2, Threads: 1},
+		testScrypt,
+		testBcrypt,
+	} {
+		setDefault(t, h)
+		if ok, rehash, err := Verify(encoded, "password1"); !ok || !rehash || err != nil {
+			t.Errorf("Verify with Default %+v = %t, %t, %v, want a rehash", h, ok, rehash, err)
+		}
+	}
+
+	setDefault(t, Scrypt{LogN: 5, R: 8, P: 1})
+	encoded, err = testScrypt.Hash("password1")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if _, rehash, _ := Verify(encoded, "password1"); !rehash {
+		t.Error("Verify of a scrypt hash with another cost asked for no rehash")
+	}
+
+	setDefault(t, Bcrypt{Cost: bcrypt.MinCost + 1})
+	encoded, err = testBcrypt.Hash("password1")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if _, rehash, _ := Verify(encoded, "password1"); !rehash {
+		t.Error("Verify of a bcrypt hash with another cost asked for no rehash")
+	}
+}
+
+func TestVerifyUnknown(t *testing.T) {
+	for _, encoded := range []string{
+		"password1",
+		"",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64$c2FsdA$a2V5",
+		"$scrypt$ln=4,r=8,p=1$c2FsdA",
+		"$md5$c2FsdA$a2V5",
+	} {
+		if Identify(encoded) != nil {
+			t.Errorf("Identify(%q) recognized the hash", encoded)
+		}
+		if ok, _, err := Verify(encoded, encoded); ok || !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("Verify(%q) = %t, %v, want ErrUnknownHash", encoded, ok, err)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want PasswordHasher
+	}{
+		{map[string]string{}, DefaultArgon2id},
+		{map[string]string{"PASSWORD_HASHER": "argon2id", "ARGON2_MEMORY": "65536", "ARGON2_TIME": "3", "ARGON2_THREADS": "4"}, Argon2id{Memory: 65536, Time: 3, Threads: 4}},
+		{map[string]string{"PASSWORD_HASHER": "bcrypt"}, DefaultBcrypt},
+		{map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "12"}, Bcrypt{Cost: 12}},
+		{map[string]string{"PASSWORD_HASHER": "scrypt", "SCRYPT_LOG_N": "15"}, Scrypt{LogN: 15, R: 8, P: 1}},
+
+		// Out of range values fail rather than wrap around.
+		{map[string]string{"ARGON2_THREADS": "256"}, nil},
+		{map[string]string{"ARGON2_MEMORY": "0"}, nil},
+		{map[string]string{"ARGON2_TIME": "-1"}, nil},
+		{map[string]string{"ARGON2_MEMORY": "4294967296"}, nil},
+		{map[string]string{"ARGON2_TIME": "two"}, nil},
+		{map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "3"}, nil},
+		{map[string]string{"PASSWORD_HASHER": "bcrypt", "BCRYPT_COST": "32"}, nil},
+		{map[string]string{"PASSWORD_HASHER": "scrypt", "SCRYPT_LOG_N": "32"}, nil},
+		{map[string]string{"PASSWORD_HASHER": "md5"}, nil},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"PASSWORD_HASHER", "BCRYPT_COST", "ARGON2_MEMORY", "ARGON2_TIME", "ARGON2_THREADS", "SCRYPT_LOG_N"} {
+			t.Setenv(name, tt.env[name])
+		}
+
+		h, err := FromEnv()
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("FromEnv with %v = %+v, want an error", tt.env, h)
+			}
+			continue
+		}
+		if err != nil || h != tt.want {
+			t.Errorf("FromEnv with %v = %+v, %v, want %+v", tt.env, h, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+-- +goose StatementEnd
+
+-- Accounts created before verification existed are treated as verified.
+-- +goose StatementBegin
+UPDATE users SET verified = TRUE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS verification_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    purpose VARCHAR(32) NOT NULL,
+    user_id BIGINT NOT NULL,
+    expires DATETIME(6) NOT NULL,
+    used DATETIME(6),
+    created DATETIME(6),
+    INDEX verification_tokens_user_id (user_id, purpose),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS verification_tokens;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN verified;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    credential_id VARBINARY(1023) NOT NULL UNIQUE,
+    user_id BIGINT NOT NULL,
+    public_key BLOB NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    transports VARCHAR(255) NOT NULL DEFAULT '',
+    aaguid VARCHAR(36) NOT NULL DEFAULT '',
+    attestation VARCHAR(32) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    last_used DATETIME(6),
+    created DATETIME(6),
+    INDEX passkeys_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS passkeys;
+-- +goose StatementEnd
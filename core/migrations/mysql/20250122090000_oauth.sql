@@ -0,0 +1,80 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oauth_clients (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    client_id VARCHAR(64) NOT NULL UNIQUE,
+    secret VARCHAR(64) NOT NULL DEFAULT '',
+    name VARCHAR(255) NOT NULL,
+    redirect_uris TEXT NOT NULL,
+    grant_types VARCHAR(255) NOT NULL DEFAULT '',
+    scopes VARCHAR(1024) NOT NULL DEFAULT '',
+    public BOOLEAN NOT NULL DEFAULT FALSE,
+    created DATETIME(6)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    client_id VARCHAR(64) NOT NULL,
+    user_id BIGINT NOT NULL,
+    redirect_uri VARCHAR(2048) NOT NULL,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    nonce VARCHAR(255) NOT NULL DEFAULT '',
+    code_challenge VARCHAR(128) NOT NULL,
+    auth_time DATETIME(6) NOT NULL,
+    expires DATETIME(6) NOT NULL,
+    used DATETIME(6),
+    created DATETIME(6),
+    FOREIGN KEY (client_id) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    kind VARCHAR(16) NOT NULL,
+    client_id VARCHAR(64) NOT NULL,
+    user_id BIGINT,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    expires DATETIME(6) NOT NULL,
+    revoked DATETIME(6),
+    created DATETIME(6),
+    INDEX oauth_tokens_user_id (user_id, client_id),
+    FOREIGN KEY (client_id) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oauth_consents (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    client_id VARCHAR(64) NOT NULL,
+    scope VARCHAR(1024) NOT NULL DEFAULT '',
+    created DATETIME(6),
+    UNIQUE (user_id, client_id),
+    FOREIGN KEY (client_id) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oauth_consents;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oauth_tokens;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oauth_codes;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS oauth_clients;
+-- +goose StatementEnd
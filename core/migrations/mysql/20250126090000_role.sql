@@ -0,0 +1,118 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description VARCHAR(1024) NOT NULL DEFAULT '',
+    builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    created DATETIME(6),
+    updated DATETIME(6)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    description VARCHAR(1024) NOT NULL DEFAULT '',
+    builtin BOOLEAN NOT NULL DEFAULT FALSE,
+    created DATETIME(6)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id BIGINT NOT NULL,
+    permission_id BIGINT NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    INDEX role_permissions_permission_id (permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id BIGINT NOT NULL,
+    role_id BIGINT NOT NULL,
+    created DATETIME(6),
+    PRIMARY KEY (user_id, role_id),
+    INDEX user_roles_role_id (role_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- The built-in permissions are the ones checked by the views. admin holds
+-- every permission without being granted them.
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description, builtin, created) VALUES
+    ('users.read', 'Read and list users', TRUE, CURRENT_TIMESTAMP),
+    ('users.update', 'Change the email, password, status and two-factor settings of other users', TRUE, CURRENT_TIMESTAMP),
+    ('users.delete', 'Delete users', TRUE, CURRENT_TIMESTAMP),
+    ('users.roles', 'Assign roles to users', TRUE, CURRENT_TIMESTAMP),
+    ('roles.manage', 'Create and edit roles and permissions', TRUE, CURRENT_TIMESTAMP),
+    ('oauth.clients', 'Register and remove OAuth clients', TRUE, CURRENT_TIMESTAMP),
+    ('api_keys.manage', 'Create, list and revoke the API keys of other users', TRUE, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO roles (name, description, builtin, created, updated) VALUES
+    ('admin', 'Full access', TRUE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP),
+    ('staff', 'Read access to users', TRUE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'staff' AND p.name = 'users.read';
+-- +goose StatementEnd
+
+-- The staff and admin flags become memberships of the built-in roles.
+
+-- +goose StatementBegin
+INSERT INTO user_roles (user_id, role_id, created)
+SELECT u.id, r.id, CURRENT_TIMESTAMP FROM users u, roles r WHERE r.name = 'admin' AND u.admin = TRUE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO user_roles (user_id, role_id, created)
+SELECT u.id, r.id, CURRENT_TIMESTAMP FROM users u, roles r WHERE r.name = 'staff' AND u.staff = TRUE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN staff, DROP COLUMN admin;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN staff BOOLEAN, ADD COLUMN admin BOOLEAN;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE users SET admin = EXISTS (
+    SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id AND r.name = 'admin'
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE users SET staff = EXISTS (
+    SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id AND r.name = 'staff'
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_roles;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS role_permissions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS permissions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS roles;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invitations (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(254) NOT NULL,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    role VARCHAR(100) NOT NULL DEFAULT '',
+    organization_id BIGINT,
+    organization_role VARCHAR(32) NOT NULL DEFAULT '',
+    invited_by BIGINT,
+    expires DATETIME(6) NOT NULL,
+    accepted DATETIME(6),
+    created DATETIME(6),
+    updated DATETIME(6),
+    INDEX invitations_organization_id (organization_id),
+    FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    FOREIGN KEY (invited_by) REFERENCES users(id) ON DELETE SET NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description, builtin, created) VALUES
+    ('users.invite', 'Invite users, with a role or into any organization', TRUE, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'users.invite';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS invitations;
+-- +goose StatementEnd
@@ -0,0 +1,54 @@
+-- +goose Up
+-- Actor and target are plain ids without foreign keys, so that events
+-- outlive the users they mention.
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    actor_id BIGINT,
+    action VARCHAR(100) NOT NULL,
+    target_id BIGINT,
+    diff MEDIUMTEXT NOT NULL,
+    diff_hash VARCHAR(64) NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent VARCHAR(1024) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    created DATETIME(6) NOT NULL,
+    prev_hash VARCHAR(64) NOT NULL UNIQUE,
+    hash VARCHAR(64) NOT NULL,
+    INDEX audit_events_actor_id (actor_id),
+    INDEX audit_events_target_id (target_id)
+);
+-- +goose StatementEnd
+
+-- The hash of the newest event. Writers lock this row to append to the
+-- chain one at a time.
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_head (
+    id BIGINT PRIMARY KEY,
+    hash VARCHAR(64) NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO audit_head (id, hash) VALUES (1, '');
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description, builtin, created) VALUES
+    ('audit.read', 'Query and export the audit log', TRUE, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'audit.read';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_head;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_events;
+-- +goose StatementEnd
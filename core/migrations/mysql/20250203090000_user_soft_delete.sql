@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN deleted_at DATETIME(6);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX users_deleted_at ON users (deleted_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX users_deleted_at ON users;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN deleted_at;
+-- +goose StatementEnd
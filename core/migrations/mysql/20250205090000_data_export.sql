@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS data_exports (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    requested_by BIGINT,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL,
+    archive LONGBLOB,
+    expires DATETIME(6) NOT NULL,
+    completed DATETIME(6),
+    created DATETIME(6),
+    updated DATETIME(6),
+    INDEX data_exports_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (requested_by) REFERENCES users(id) ON DELETE SET NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description, builtin, created) VALUES
+    ('users.export', 'Export the personal data of any user', TRUE, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'users.export';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS data_exports;
+-- +goose StatementEnd
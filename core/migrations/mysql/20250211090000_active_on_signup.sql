@@ -0,0 +1,11 @@
+-- +goose Up
+-- Verifying an email no longer activates the account, so new accounts start
+-- active. Accounts waiting for verification were inactive only for that.
+-- +goose StatementBegin
+UPDATE users SET active = TRUE WHERE verified = FALSE AND active = FALSE AND deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE users SET active = FALSE WHERE verified = FALSE;
+-- +goose StatementEnd
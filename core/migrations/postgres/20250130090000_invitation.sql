@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS invitations (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    token TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL DEFAULT '',
+    organization_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE,
+    organization_role TEXT NOT NULL DEFAULT '',
+    invited_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    expires TIMESTAMP NOT NULL,
+    accepted TIMESTAMP,
+    created TIMESTAMP,
+    updated TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS invitations_organization_id ON invitations (organization_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description, builtin, created) VALUES
+    ('users.invite', 'Invite users, with a role or into any organization', TRUE, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'users.invite';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS invitations;
+-- +goose StatementEnd
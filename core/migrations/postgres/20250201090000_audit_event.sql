@@ -0,0 +1,60 @@
+-- +goose Up
+-- Actor and target are plain ids without foreign keys, so that events
+-- outlive the users they mention.
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id BIGINT,
+    action TEXT NOT NULL,
+    target_id BIGINT,
+    diff TEXT NOT NULL,
+    diff_hash TEXT NOT NULL,
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    created TIMESTAMP NOT NULL,
+    prev_hash TEXT NOT NULL UNIQUE,
+    hash TEXT NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_events_actor_id ON audit_events (actor_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS audit_events_target_id ON audit_events (target_id);
+-- +goose StatementEnd
+
+-- The hash of the newest event. Writers lock this row to append to the
+-- chain one at a time.
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_head (
+    id BIGINT PRIMARY KEY,
+    hash TEXT NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO audit_head (id, hash) VALUES (1, '');
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO permissions (name, description, builtin, created) VALUES
+    ('audit.read', 'Query and export the audit log', TRUE, CURRENT_TIMESTAMP);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM permissions WHERE name = 'audit.read';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_head;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_events;
+-- +goose StatementEnd
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+// APIKeyPrefix starts every API key, so that keys can be told apart from
+// JWTs and found by secret scanners.
+const APIKeyPrefix = "pgo_"
+
+// Scopes an API key can be limited to. A key never grants more than its
+// user could do. Views changing credentials or roles (email, password,
+// admin, staff and roles) take no API keys whatever their scopes.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+var APIKeyScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
+// apiKeyTouchInterval limits how often last_used is written for a busy key.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrInvalidAPIKey    = apperror.New(http.StatusUnauthorized, "invalid_api_key", "invalid or expired api key")
+	ErrAPIKeyScope      = apperror.New(http.StatusForbidden, "insufficient_scope", "the api key does not grant this scope")
+	ErrAPIKeyNotAllowed = apperror.New(http.StatusForbidden, "api_key_not_allowed", "api keys cannot be used here")
+)
+
+// APIKey lets scripts call the API as a user. A key reads
+// pgo_<prefix>_<secret>; the prefix is stored as is to find the key and
+// only a hash of the secret is stored.
+type APIKey struct {
+	ID       int64      `db:"id" json:"id"`
+	UserID   int64      `db:"user_id" json:"user_id"`
+	Name     string     `db:"name" json:"name"`
+	Prefix   string     `db:"prefix" json:"prefix"`
+	Secret   string     `db:"secret" json:"-"`
+	Scopes   SpaceList  `db:"scopes" json:"scopes"`
+	Expires  *time.Time `db:"expires" json:"expires"`
+	LastUsed *time.Time `db:"last_used" json:"last_used"`
+	Created  time.Time  `db:"created" json:"created"`
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key APIKey) (APIKey, string, error)
+	Get(ctx context.Context, id int64) (APIKey, error)
+	ListUser(ctx context.Context, userID int64) ([]APIKey, error)
+	Authenticate(ctx context.Context, key string) (APIKey, error)
+	Delete(ctx context.Context, userID, id int64) error
+	DeleteUser(ctx context.Context, userID int64) error
+}
+
+type SQLAPIKeyRepository struct {
+	DB *sqlx.DB
+}
+
+func NewAPIKeyRepository(db *sqlx.DB) *SQLAPIKeyRepository {
+	return &SQLAPIKeyRepository{DB: db}
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, secret, scopes, expires, last_used, created"
+
+// Create stores the key and returns it with the full key, which cannot be
+// recovered later.
+func (repo *SQLAPIKeyRepository) Create(ctx context.Context, key APIKey) (APIKey, string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return APIKey{}, "", err
+	}
+	secret, hash, err := NewToken()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key.Prefix, key.Secret, key.Created = hex.EncodeToString(b), hash, time.Now().UTC()
+	if key.Expires != nil {
+		expires := key.Expires.UTC()
+		key.Expires = &expires
+	}
+
+	query := "INSERT INTO api_keys (user_id, name, prefix, secret, scopes, expires, created) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	key.ID, err = insert(ctx, repo.DB, query, key.UserID, key.Name, key.Prefix, key.Secret, key.Scopes, key.Expires, key.Created)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	return key, APIKeyPrefix + key.Prefix + "_" + secret, nil
+}
+
+func (repo *SQLAPIKeyRepository) Get(ctx context.Context, id int64) (APIKey, error) {
+	var key APIKey
+	query := fmt.Sprintf("SELECT %s FROM api_keys WHERE id = ?", apiKeyColumns)
+	err := repo.DB.GetContext(ctx, &key, repo.DB.Rebind(query), id)
+	return key, dbError(err)
+}
+
+func (repo *SQLAPIKeyRepository) ListUser(ctx context.Context, userID int64) ([]APIKey, error) {
+	keys := []APIKey{}
+	query := fmt.Sprintf("SELECT %s FROM api_keys WHERE user_id = ? ORDER BY id", apiKeyColumns)
+	err := repo.DB.SelectContext(ctx, &keys, repo.DB.Rebind(query), userID)
+	return keys, dbError(err)
+}
+
+// Authenticate returns the unexpired key matching the full key and records
+// that it was used. Any other key yields ErrInvalidAPIKey.
+func (repo *SQLAPIKeyRepository) Authenticate(ctx context.Context, raw string) (APIKey, error) {
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(raw, APIKeyPrefix), "_")
+	if !ok || !strings.HasPrefix(raw, APIKeyPrefix) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	var key APIKey
+	query := fmt.Sprintf("SELECT %s FROM api_keys WHERE prefix = ?", apiKeyColumns)
+	err := repo.DB.GetContext(ctx, &key, repo.DB.Rebind(query), prefix)
+	if err := dbError(err); errors.Is(err, ErrNotFound) {
+		return APIKey{}, ErrInvalidAPIKey
+	} else if err != nil {
+		return APIKey{}, err
+	}
+
+	now := time.Now().UTC()
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(key.Secret)) != 1 || key.Expires != nil && !now.Before(*key.Expires) {
+		return APIKey{}, ErrInvalidAPIKey
+	}
+
+	if key.LastUsed == nil || now.Sub(*key.LastUsed) >= apiKeyTouchInterval {
+		if _, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("UPDATE api_keys SET last_used = ? WHERE id = ?"), now, key.ID); err != nil {
+			return APIKey{}, err
+		}
+		key.LastUsed = &now
+	}
+
+	return key, nil
+}
+
+// Delete revokes a key of the user. Keys of other users are not found.
+func (repo *SQLAPIKeyRepository) Delete(ctx context.Context, userID, id int64) error {
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM api_keys WHERE id = ? AND user_id = ?"), id, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteUser revokes every key of the user.
+func (repo *SQLAPIKeyRepository) DeleteUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind("DELETE FROM api_keys WHERE user_id = ?"), userID)
+	return err
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		users, keys := NewUserRepository(db), NewAPIKeyRepository(db)
+
+		var ids []int64
+		for _, name := range []string{"revoked", "kept"} {
+			user, err := users.Create(ctx, User{Email: testEmail(name), Password: "password1"})
+			if err != nil {
+				t.Fatalf("Create user: %v", err)
+			}
+			ids = append(ids, user.ID)
+		}
+
+		var raw []string
+		for _, userID := range []int64{ids[0], ids[0], ids[1]} {
+			_, key, err := keys.Create(ctx, APIKey{UserID: userID, Name: "script", Scopes: SpaceList{ScopeUsersRead}})
+			if err != nil {
+				t.Fatalf("Create key: %v", err)
+			}
+			raw = append(raw, key)
+		}
+
+		key, err := keys.Authenticate(ctx, raw[0])
+		if err != nil || key.UserID != ids[0] || key.LastUsed == nil {
+			t.Errorf("Authenticate = %+v, %v", key, err)
+		}
+		if _, err := keys.Authenticate(ctx, raw[0]+"x"); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("Authenticate with a wrong secret = %v, want ErrInvalidAPIKey", err)
+		}
+
+		if err := keys.DeleteUser(ctx, ids[0]); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		for i, want := range []bool{false, false, true} {
+			if _, err := keys.Authenticate(ctx, raw[i]); (err == nil) != want {
+				t.Errorf("key %d after DeleteUser: %v", i, err)
+			}
+		}
+	})
+}
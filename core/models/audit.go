@@ -0,0 +1,662 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrAuditChainBroken = apperror.New(http.StatusConflict, "audit_chain_broken", "the audit log has been tampered with")
+
+// AuditMeta describes the request behind a change. It travels in the
+// context so that the audited repositories can record it.
+type AuditMeta struct {
+	ActorID   *int64
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+type auditContextKey struct{}
+
+type auditActionKey struct{}
+
+func WithAuditMeta(ctx context.Context, meta AuditMeta) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, meta)
+}
+
+// AuditMetaFrom returns the AuditMeta of ctx, which is empty outside of a
+// request, for example in management commands.
+func AuditMetaFrom(ctx context.Context) AuditMeta {
+	meta, _ := ctx.Value(auditContextKey{}).(AuditMeta)
+	return meta
+}
+
+// withAuditAction makes the audited repositories record the next change
+// under action instead of the name of the method.
+func withAuditAction(ctx context.Context, action string) context.Context {
+	return context.WithValue(ctx, auditActionKey{}, action)
+}
+
+// AuditChange is the value of a field before and after a change.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditDiff is a JSON object mapping each changed field to an AuditChange.
+// It is kept as the exact text that was stored, which DiffHash covers.
+type AuditDiff []byte
+
+// NewAuditDiff compares the JSON encodings of before and after, either of
+// which may be nil, field by field. updated is left out as it changes with
+// every write.
+func NewAuditDiff(before, after any) (AuditDiff, error) {
+	b, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]AuditChange{}
+	for _, fields := range []map[string]any{b, a} {
+		for name := range fields {
+			if name != "updated" && !reflect.DeepEqual(b[name], a[name]) {
+				diff[name] = AuditChange{Before: b[name], After: a[name]}
+			}
+		}
+	}
+	return json.Marshal(diff)
+}
+
+func auditFields(v any) (map[string]any, error) {
+	fields := map[string]any{}
+	if v == nil {
+		return fields, nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("audit %T: %w", v, err)
+	}
+	return fields, nil
+}
+
+func (d AuditDiff) Value() (driver.Value, error) {
+	return string(d), nil
+}
+
+func (d *AuditDiff) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		*d = AuditDiff(v)
+	case []byte:
+		*d = append(AuditDiff(nil), v...)
+	default:
+		return fmt.Errorf("cannot scan %T into AuditDiff", src)
+	}
+	return nil
+}
+
+func (d AuditDiff) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("{}"), nil
+	}
+	return d, nil
+}
+
+// AuditEvent is one change to a user. Each event stores the hash of the
+// event before it, so editing, inserting or removing events breaks the
+// chain, which Verify detects. The hash covers DiffHash rather than Diff so
+// that a diff can be erased later without breaking the chain.
+type AuditEvent struct {
+	ID        int64     `db:"id" json:"id"`
+	ActorID   *int64    `db:"actor_id" json:"actor_id"`
+	Action    string    `db:"action" json:"action"`
+	TargetID  *int64    `db:"target_id" json:"target_id"`
+	Diff      AuditDiff `db:"diff" json:"diff"`
+	DiffHash  string    `db:"diff_hash" json:"diff_hash"`
+	IP        string    `db:"ip" json:"ip"`
+	UserAgent string    `db:"user_agent" json:"user_agent"`
+	RequestID string    `db:"request_id" json:"request_id"`
+	Created   time.Time `db:"created" json:"created"`
+	PrevHash  string    `db:"prev_hash" json:"prev_hash"`
+	Hash      string    `db:"hash" json:"hash"`
+}
+
+// anonymizedDiff replaces the diff of an event about a purged user. It is
+// not covered by DiffHash, which is kept so the chain still verifies as long
+// as a later user.purge event lists the event.
+var anonymizedDiff = AuditDiff(`"[anonymized]"`)
+
+// purgeAction is the action of the event recorded for a purged user. Its
+// diff lists the events anonymized by the purge under anonymized_events.
+const purgeAction = "user.purge"
+
+type purgeDiff struct {
+	Anonymized struct {
+		After []int64 `json:"after"`
+	} `json:"anonymized_events"`
+}
+
+// anonymizeAuditEvents erases the diffs of the events about the user, which
+// hold their email and other details, and records the purge event listing
+// them. The ids, IP addresses and user agents are covered by the hashes and
+// stay. It runs inside auditTx.
+func anonymizeAuditEvents(ctx context.Context, tx queryer, userID int64) error {
+	// Earlier purge events hold no details and must stay readable, in case
+	// the id of a purged user was reused.
+	ids := []int64{}
+	query := "SELECT id FROM audit_events WHERE target_id = ? AND action <> ? ORDER BY id"
+	if err := tx.SelectContext(ctx, &ids, tx.Rebind(query), userID, purgeAction); err != nil {
+		return err
+	}
+
+	query = "UPDATE audit_events SET diff = ? WHERE target_id = ? AND action <> ?"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), anonymizedDiff, userID, purgeAction); err != nil {
+		return err
+	}
+
+	diff, err := NewAuditDiff(nil, map[string][]int64{"anonymized_events": ids})
+	if err != nil {
+		return err
+	}
+	_, err = appendAuditEvent(ctx, tx, newAuditEvent(ctx, purgeAction, userID, diff))
+	return err
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ComputeHash returns the hash the event should have, from every field but
+// ID and Hash.
+func (e AuditEvent) ComputeHash() string {
+	b, _ := json.Marshal([]any{
+		e.PrevHash, e.ActorID, e.Action, e.TargetID, e.DiffHash,
+		e.IP, e.UserAgent, e.RequestID, e.Created.UTC().Format(time.RFC3339Nano),
+	})
+	return hashHex(b)
+}
+
+// AuditListOptions filters the audit log. Listings run newest first and are
+// walked with Cursor, the NextCursor of the previous page.
+type AuditListOptions struct {
+	ActorID  *int64
+	TargetID *int64
+	Action   string
+	After    *time.Time
+	Before   *time.Time
+	Cursor   int64
+	Limit    int
+}
+
+func (opts AuditListOptions) filters() ([]string, []any) {
+	var where []string
+	var args []any
+
+	if opts.ActorID != nil {
+		where = append(where, "actor_id = ?")
+		args = append(args, *opts.ActorID)
+	}
+	if opts.TargetID != nil {
+		where = append(where, "target_id = ?")
+		args = append(args, *opts.TargetID)
+	}
+	if opts.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, opts.Action)
+	}
+	if opts.After != nil {
+		where = append(where, "created > ?")
+		args = append(args, opts.After.UTC())
+	}
+	if opts.Before != nil {
+		where = append(where, "created < ?")
+		args = append(args, opts.Before.UTC())
+	}
+
+	return where, args
+}
+
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor int64        `json:"next_cursor,omitempty"`
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, event AuditEvent) (AuditEvent, error)
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+	List(ctx context.Context, opts AuditListOptions) (AuditPage, error)
+	Each(ctx context.Context, opts AuditListOptions, fn func(AuditEvent) error) error
+	Verify(ctx context.Context) (int64, error)
+}
+
+type SQLAuditRepository struct {
+	DB *sqlx.DB
+}
+
+func NewAuditRepository(db *sqlx.DB) *SQLAuditRepository {
+	return &SQLAuditRepository{DB: db}
+}
+
+const auditColumns = "id, actor_id, action, target_id, diff, diff_hash, ip, user_agent, request_id, created, prev_hash, hash"
+
+// auditMu keeps writers in this process from queueing on the audit_head
+// row lock, which orders writers across processes. See auditTx.
+var auditMu sync.Mutex
+
+// Record appends the event to the chain, in the transaction of InTx when
+// ctx comes from it.
+func (repo *SQLAuditRepository) Record(ctx context.Context, event AuditEvent) (AuditEvent, error) {
+	err := auditTx(ctx, repo.DB, func(ctx context.Context) error {
+		var err error
+		event, err = appendAuditEvent(ctx, conn(ctx, repo.DB), event)
+		return err
+	})
+	if err != nil {
+		return AuditEvent{}, err
+	}
+	return event, nil
+}
+
+// InTx runs fn in a transaction that the events recorded and the changes
+// made with its context join: those of the SQL user and role repositories,
+// of accepting an invitation, of organization memberships, and of signing a
+// user out everywhere.
+func (repo *SQLAuditRepository) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return auditTx(ctx, repo.DB, fn)
+}
+
+// appendAuditEvent inserts the event after the head of the chain. Its
+// created time is truncated to microseconds, the precision every supported
+// database keeps, so that the hash can be computed again from the stored
+// row. It runs inside auditTx.
+func appendAuditEvent(ctx context.Context, tx queryer, event AuditEvent) (AuditEvent, error) {
+	// Writing the head first takes its lock before it is read.
+	if _, err := tx.ExecContext(ctx, tx.Rebind("UPDATE audit_head SET hash = hash WHERE id = 1")); err != nil {
+		return AuditEvent{}, err
+	}
+	if err := tx.GetContext(ctx, &event.PrevHash, tx.Rebind("SELECT hash FROM audit_head WHERE id = 1")); err != nil {
+		return AuditEvent{}, err
+	}
+
+	if len(event.Diff) == 0 {
+		event.Diff = AuditDiff("{}")
+	}
+	event.DiffHash = hashHex(event.Diff)
+	event.Created = time.Now().UTC().Truncate(time.Microsecond)
+	event.Hash = event.ComputeHash()
+
+	var err error
+	query := `INSERT INTO audit_events (actor_id, action, target_id, diff, diff_hash, ip, user_agent, request_id, created, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	event.ID, err = insert(ctx, tx, query, event.ActorID, event.Action, event.TargetID, event.Diff, event.DiffHash,
+		event.IP, event.UserAgent, event.RequestID, event.Created, event.PrevHash, event.Hash)
+	if err != nil {
+		return AuditEvent{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind("UPDATE audit_head SET hash = ? WHERE id = 1"), event.Hash); err != nil {
+		return AuditEvent{}, err
+	}
+	return event, nil
+}
+
+func (repo *SQLAuditRepository) List(ctx context.Context, opts AuditListOptions) (AuditPage, error) {
+	page := AuditPage{Events: []AuditEvent{}}
+	if opts.Limit <= 0 || opts.Limit > MaxListLimit {
+		opts.Limit = DefaultListLimit
+	}
+
+	where, args := opts.filters()
+	if opts.Cursor > 0 {
+		where = append(where, "id < ?")
+		args = append(args, opts.Cursor)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM audit_events%s ORDER BY id DESC LIMIT ?", auditColumns, whereClause(where))
+	args = append(args, opts.Limit+1)
+	if err := repo.DB.SelectContext(ctx, &page.Events, repo.DB.Rebind(query), args...); err != nil {
+		return page, dbError(err)
+	}
+
+	if len(page.Events) > opts.Limit {
+		page.Events = page.Events[:opts.Limit]
+		page.NextCursor = page.Events[opts.Limit-1].ID
+	}
+	return page, nil
+}
+
+// Each calls fn with every event matching opts, oldest first, without
+// holding them all in memory. Cursor and Limit are ignored.
+func (repo *SQLAuditRepository) Each(ctx context.Context, opts AuditListOptions, fn func(AuditEvent) error) error {
+	where, args := opts.filters()
+	query := fmt.Sprintf("SELECT %s FROM audit_events%s ORDER BY id", auditColumns, whereClause(where))
+
+	rows, err := repo.DB.QueryxContext(ctx, repo.DB.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event AuditEvent
+		if err := rows.StructScan(&event); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Verify walks the whole chain and returns the number of events checked.
+// An event whose hash does not match its fields, that does not follow the
+// event before it, or a head that is not the last event, fails with
+// ErrAuditChainBroken naming the event. An anonymized diff is only accepted
+// when a later user.purge event lists the event.
+func (repo *SQLAuditRepository) Verify(ctx context.Context) (int64, error) {
+	var checked int64
+	prev := ""
+	anonymized := map[int64]bool{}
+
+	err := repo.Each(ctx, AuditListOptions{}, func(event AuditEvent) error {
+		switch {
+		case event.PrevHash != prev:
+			return ErrAuditChainBroken.WithField("event", fmt.Sprintf("%d does not follow the event before it", event.ID))
+		case event.ComputeHash() != event.Hash:
+			return ErrAuditChainBroken.WithField("event", fmt.Sprintf("%d does not match its hash", event.ID))
+		case hashHex(event.Diff) == event.DiffHash:
+		case slices.Equal(event.Diff, anonymizedDiff):
+			anonymized[event.ID] = true
+		default:
+			return ErrAuditChainBroken.WithField("event", fmt.Sprintf("%d has a diff that does not match its hash", event.ID))
+		}
+
+		if event.Action == purgeAction && !anonymized[event.ID] {
+			var diff purgeDiff
+			if err := json.Unmarshal(event.Diff, &diff); err != nil {
+				return ErrAuditChainBroken.WithField("event", fmt.Sprintf("%d has an unreadable diff", event.ID))
+			}
+			for _, id := range diff.Anonymized.After {
+				delete(anonymized, id)
+			}
+		}
+
+		prev = event.Hash
+		checked++
+		return nil
+	})
+	if err != nil {
+		return checked, err
+	}
+
+	if len(anonymized) > 0 {
+		id := slices.Min(slices.Collect(maps.Keys(anonymized)))
+		return checked, ErrAuditChainBroken.WithField("event", fmt.Sprintf("%d was anonymized without a purge", id))
+	}
+
+	var head string
+	if err := repo.DB.GetContext(ctx, &head, repo.DB.Rebind("SELECT hash FROM audit_head WHERE id = 1")); err != nil {
+		return checked, err
+	}
+	if head != prev {
+		return checked, ErrAuditChainBroken.WithField("event", "the newest events are missing")
+	}
+	return checked, nil
+}
+
+// AuditedUserRepository records an AuditEvent for every change made through
+// it, with the AuditMeta of the context. Reads go straight to the wrapped
+// repository.
+type AuditedUserRepository struct {
+	UserRepository
+	Audit AuditRepository
+}
+
+func NewAuditedUserRepository(users UserRepository, audit AuditRepository) *AuditedUserRepository {
+	return &AuditedUserRepository{UserRepository: users, Audit: audit}
+}
+
+// record saves an event for a change of target from before to after.
+func record(ctx context.Context, audit AuditRepository, action string, targetID int64, before, after any) error {
+	diff, err := NewAuditDiff(before, after)
+	if err != nil {
+		return fmt.Errorf("audit %s of user %d: %w", action, targetID, err)
+	}
+	return recordDiff(ctx, audit, action, targetID, diff)
+}
+
+// recordDiff saves an event with diff. It runs in the transaction of the
+// change, which a failure to record it rolls back.
+func recordDiff(ctx context.Context, audit AuditRepository, action string, targetID int64, diff AuditDiff) error {
+	if override, ok := ctx.Value(auditActionKey{}).(string); ok {
+		action = override
+	}
+
+	if _, err := audit.Record(ctx, newAuditEvent(ctx, action, targetID, diff)); err != nil {
+		return fmt.Errorf("audit %s of user %d: %w", action, targetID, err)
+	}
+	return nil
+}
+
+// newAuditEvent returns an event about target with the AuditMeta of ctx.
+func newAuditEvent(ctx context.Context, action string, targetID int64, diff AuditDiff) AuditEvent {
+	meta := AuditMetaFrom(ctx)
+	return AuditEvent{
+		ActorID:   meta.ActorID,
+		Action:    action,
+		TargetID:  &targetID,
+		Diff:      diff,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		RequestID: meta.RequestID,
+	}
+}
+
+// passwordDiff only shows that the password changed, never its hash.
+var passwordDiff = AuditDiff(`{"password":{"before":"[redacted]","after":"[redacted]"}}`)
+
+func (repo *AuditedUserRepository) Create(ctx context.Context, user User) (User, error) {
+	var created User
+	err := repo.Audit.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		if created, err = repo.UserRepository.Create(ctx, user); err != nil {
+			return err
+		}
+		return record(ctx, repo.Audit, "user.create", created.ID, nil, created)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return created, nil
+}
+
+// update runs change and records it with the user before and after, in one
+// transaction.
+func (repo *AuditedUserRepository) update(ctx context.Context, action string, id int64, change func(ctx context.Context) (User, error)) (User, error) {
+	var after User
+	err := repo.Audit.InTx(ctx, func(ctx context.Context) error {
+		before, err := repo.UserRepository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if after, err = change(ctx); err != nil {
+			return err
+		}
+		return record(ctx, repo.Audit, action, id, before, after)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return after, nil
+}
+
+func (repo *AuditedUserRepository) UpdateEmail(ctx context.Context, id int64, email string) (User, error) {
+	return repo.update(ctx, "user.update_email", id, func(ctx context.Context) (User, error) {
+		return repo.UserRepository.UpdateEmail(ctx, id, email)
+	})
+}
+
+func (repo *AuditedUserRepository) ConfirmEmail(ctx context.Context, id int64) (User, error) {
+	return repo.update(ctx, "user.confirm_email", id, func(ctx context.Context) (User, error) {
+		return repo.UserRepository.ConfirmEmail(ctx, id)
+	})
+}
+
+func (repo *AuditedUserRepository) UpdatePassword(ctx context.Context, id int64, password string) (User, error) {
+	var after User
+	err := repo.Audit.InTx(ctx, func(ctx context.Context) error {
+		var err error
+		if after, err = repo.UserRepository.UpdatePassword(ctx, id, password); err != nil {
+			return err
+		}
+		return recordDiff(ctx, repo.Audit, "user.update_password", id, passwordDiff)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return after, nil
+}
+
+func (repo *AuditedUserRepository) UpdateActive(ctx context.Context, id int64, active bool) (User, error) {
+	return repo.update(ctx, "user.update_active", id, func(ctx context.Context) (User, error) {
+		return repo.UserRepository.UpdateActive(ctx, id, active)
+	})
+}
+
+func (repo *AuditedUserRepository) UpdateStaff(ctx context.Context, id int64, staff bool) (User, error) {
+	return repo.update(ctx, "user.update_staff", id, func(ctx context.Context) (User, error) {
+		return repo.UserRepository.UpdateStaff(ctx, id, staff)
+	})
+}
+
+func (repo *AuditedUserRepository) UpdateAdmin(ctx context.Context, id int64, admin bool) (User, error) {
+	return repo.update(ctx, "user.update_admin", id, func(ctx context.Context) (User, error) {
+		return repo.UserRepository.UpdateAdmin(ctx, id, admin)
+	})
+}
+
+func (repo *AuditedUserRepository) Verify(ctx context.Context, id int64) (User, error) {
+	return repo.update(ctx, "user.verify", id, func(ctx context.Context) (User, error) {
+		return repo.UserRepository.Verify(ctx, id)
+	})
+}
+
+func (repo *AuditedUserRepository) Delete(ctx context.Context, id int64) error {
+	return repo.Audit.InTx(ctx, func(ctx context.Context) error {
+		before, err := repo.UserRepository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.UserRepository.Delete(ctx, id); err != nil {
+			return err
+		}
+
+		after, err := repo.UserRepository.GetDeleted(ctx, id)
+		if err != nil {
+			return err
+		}
+		return record(ctx, repo.Audit, "user.delete", id, before, after)
+	})
+}
+
+func (repo *AuditedUserRepository) Restore(ctx context.Context, id int64) (User, error) {
+	var after User
+	err := repo.Audit.InTx(ctx, func(ctx context.Context) error {
+		before, err := repo.UserRepository.GetDeleted(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if after, err = repo.UserRepository.Restore(ctx, id); err != nil {
+			return err
+		}
+		return record(ctx, repo.Audit, "user.restore", id, before, after)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return after, nil
+}
+
+// AuditedRoleRepository records the changes to the roles of users made
+// through it, like AuditedUserRepository.
+type AuditedRoleRepository struct {
+	RoleRepository
+	Audit AuditRepository
+}
+
+func NewAuditedRoleRepository(roles RoleRepository, audit AuditRepository) *AuditedRoleRepository {
+	return &AuditedRoleRepository{RoleRepository: roles, Audit: audit}
+}
+
+func (repo *AuditedRoleRepository) roleNames(ctx context.Context, userID int64) (map[string][]string, error) {
+	roles, err := repo.RoleRepository.UserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	slices.Sort(names)
+	return map[string][]string{"roles": names}, nil
+}
+
+// update runs change and records the roles of the user before and after,
+// in one transaction.
+func (repo *AuditedRoleRepository) update(ctx context.Context, action string, userID int64, change func(ctx context.Context) error) error {
+	return repo.Audit.InTx(ctx, func(ctx context.Context) error {
+		before, err := repo.roleNames(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := change(ctx); err != nil {
+			return err
+		}
+
+		after, err := repo.roleNames(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return record(ctx, repo.Audit, action, userID, before, after)
+	})
+}
+
+func (repo *AuditedRoleRepository) SetUserRoles(ctx context.Context, userID int64, names []string) error {
+	return repo.update(ctx, "user.update_roles", userID, func(ctx context.Context) error {
+		return repo.RoleRepository.SetUserRoles(ctx, userID, names)
+	})
+}
+
+func (repo *AuditedRoleRepository) GrantUserRole(ctx context.Context, userID int64, name string) error {
+	return repo.update(ctx, "user.update_roles", userID, func(ctx context.Context) error {
+		return repo.RoleRepository.GrantUserRole(ctx, userID, name)
+	})
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// failingAudit runs changes in the transactions of the audit log but fails
+// to record anything.
+type failingAudit struct {
+	*SQLAuditRepository
+}
+
+var errRecord = errors.New("record failed")
+
+func (failingAudit) Record(ctx context.Context, event AuditEvent) (AuditEvent, error) {
+	return AuditEvent{}, errRecord
+}
+
+func TestAuditedUserRepository(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		audit := NewAuditRepository(db)
+		repo := NewAuditedUserRepository(NewUserRepository(db), audit)
+
+		user, err := repo.Create(ctx, User{Email: testEmail("audited"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := repo.UpdateActive(ctx, user.ID, true); err != nil {
+			t.Fatalf("UpdateActive: %v", err)
+		}
+		if err := NewAuditedRoleRepository(NewRoleRepository(db), audit).GrantUserRole(ctx, user.ID, RoleStaff); err != nil {
+			t.Fatalf("GrantUserRole: %v", err)
+		}
+
+		page, err := audit.List(ctx, AuditListOptions{TargetID: &user.ID})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		var actions []string
+		for _, event := range page.Events {
+			actions = append(actions, event.Action)
+		}
+		if want := []string{"user.update_roles", "user.update_active", "user.create"}; !slices.Equal(actions, want) {
+			t.Errorf("events = %v, want %v", actions, want)
+		}
+
+		if _, err := audit.Verify(ctx); err != nil {
+			t.Errorf("Verify: %v", err)
+		}
+	})
+}
+
+func TestAuditedUserRepositoryRecordFails(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		users := NewUserRepository(db)
+		repo := NewAuditedUserRepository(users, failingAudit{NewAuditRepository(db)})
+		email := testEmail("unrecorded")
+
+		if _, err := repo.Create(ctx, User{Email: email, Password: "password1"}); !errors.Is(err, errRecord) {
+			t.Errorf("Create = %v, want the record error", err)
+		}
+		if _, err := users.GetByEmail(ctx, email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetByEmail after a failed Create = %v, want ErrNotFound", err)
+		}
+
+		user, err := users.Create(ctx, User{Email: email, Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := repo.UpdateAdmin(ctx, user.ID, true); !errors.Is(err, errRecord) {
+			t.Errorf("UpdateAdmin = %v, want the record error", err)
+		}
+		if err := repo.Delete(ctx, user.ID); !errors.Is(err, errRecord) {
+			t.Errorf("Delete = %v, want the record error", err)
+		}
+		if got, err := users.Get(ctx, user.ID); err != nil || got.Admin {
+			t.Errorf("Get after failed changes = %+v, %v, want the user unchanged", got, err)
+		}
+	})
+}
+
+func TestAuditPurge(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		audit := NewAuditRepository(db)
+		repo := NewAuditedUserRepository(NewUserRepository(db), audit)
+
+		user, err := repo.Create(ctx, User{Email: testEmail("purged"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := repo.Purge(ctx, time.Now().Add(time.Second)); err != nil {
+			t.Fatalf("Purge: %v", err)
+		}
+
+		page, err := audit.List(ctx, AuditListOptions{TargetID: &user.ID})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(page.Events) != 3 || page.Events[0].Action != purgeAction {
+			t.Fatalf("events = %+v, want create, delete and purge", page.Events)
+		}
+
+		var diff purgeDiff
+		if err := json.Unmarshal(page.Events[0].Diff, &diff); err != nil {
+			t.Fatalf("purge diff %s: %v", page.Events[0].Diff, err)
+		}
+		want := []int64{page.Events[2].ID, page.Events[1].ID}
+		if !slices.Equal(diff.Anonymized.After, want) {
+			t.Errorf("purge lists %v, want %v", diff.Anonymized.After, want)
+		}
+		for _, event := range page.Events[1:] {
+			if !slices.Equal(event.Diff, anonymizedDiff) {
+				t.Errorf("event %d kept its diff %s", event.ID, event.Diff)
+			}
+		}
+
+		if _, err := audit.Verify(ctx); err != nil {
+			t.Errorf("Verify after Purge: %v", err)
+		}
+	})
+}
+
+func TestAuditVerifyAnonymizedWithoutPurge(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		audit := NewAuditRepository(db)
+		repo := NewAuditedUserRepository(NewUserRepository(db), audit)
+
+		user, err := repo.Create(ctx, User{Email: testEmail("erased"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := db.ExecContext(ctx, db.Rebind("UPDATE audit_events SET diff = ? WHERE target_id = ?"), anonymizedDiff, user.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := audit.Verify(ctx); !errors.Is(err, ErrAuditChainBroken) {
+			t.Errorf("Verify = %v, want ErrAuditChainBroken", err)
+		}
+	})
+}
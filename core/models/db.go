@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+// Queries in this package are written with ? placeholders and go through
+// Rebind, so they run unchanged on SQLite, MySQL and Postgres.
+
+// insert runs an INSERT and returns the id of the new row. Postgres has no
+// LastInsertId, so the id is read back with RETURNING there instead.
+func insert(ctx context.Context, db sqlx.ExtContext, query string, args ...any) (int64, error) {
+	query = db.Rebind(query)
+
+	if sqlx.BindType(db.DriverName()) == sqlx.DOLLAR {
+		var id int64
+		err := db.QueryRowxContext(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// dbError translates driver errors into the package's typed errors. A
+// unique violation becomes ErrConflict, whichever constraint it broke;
+// repositories that know the constraint replace it with their own error.
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	switch e := apperror.From(err); {
+	case errors.Is(e, apperror.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(e, apperror.ErrConflict):
+		return ErrConflict.Wrap(err)
+	}
+
+	return err
+}
+
+// queryer is what a repository method runs its queries on, the database or
+// a transaction.
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+type txContextKey struct{}
+
+// conn returns the transaction auditTx put in ctx, or db outside of one.
+func conn(ctx context.Context, db *sqlx.DB) queryer {
+	if tx, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// auditTx runs fn in a transaction that the audit events and the queries
+// made with its context join, so that a change commits together with its
+// events or not at all. Inside another auditTx, fn joins the outer one.
+//
+// It holds auditMu for the whole transaction, taken before the transaction
+// begins, as SQLite allows a single writer and Record would otherwise wait
+// on the lock for a writer waiting on the database.
+func auditTx(ctx context.Context, db *sqlx.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txContextKey{}, tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// repoTx is the transaction of a repository method that changes several
+// rows. Inside auditTx it is the transaction of ctx, which only auditTx
+// commits or rolls back.
+type repoTx struct {
+	*sqlx.Tx
+	joined bool
+}
+
+func beginTx(ctx context.Context, db *sqlx.DB) (repoTx, error) {
+	if tx, ok := ctx.Value(txContextKey{}).(*sqlx.Tx); ok {
+		return repoTx{Tx: tx, joined: true}, nil
+	}
+	tx, err := db.BeginTxx(ctx, nil)
+	return repoTx{Tx: tx}, err
+}
+
+func (tx repoTx) Commit() error {
+	if tx.joined {
+		return nil
+	}
+	return tx.Tx.Commit()
+}
+
+func (tx repoTx) Rollback() error {
+	if tx.joined {
+		return nil
+	}
+	return tx.Tx.Rollback()
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/hasher"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// postgresStandIn is SQLite registered with Postgres placeholders, so that
+// the $N Rebind and RETURNING paths run without a Postgres server.
+const postgresStandIn = "sqlite3_postgres"
+
+func TestMain(m *testing.M) {
+	sql.Register(postgresStandIn, &sqlite3.SQLiteDriver{})
+	sqlx.BindDriver(postgresStandIn, sqlx.DOLLAR)
+	goose.SetLogger(goose.NopLogger())
+	hasher.Default = hasher.Bcrypt{Cost: bcrypt.MinCost}
+
+	os.Exit(m.Run())
+}
+
+// forEachDB runs test against a fresh SQLite database, against the Postgres
+// stand-in, and, when DB_DRIVER and DB are set, against that database too.
+// The latter is shared by every test, so tests must not assume it is empty.
+func forEachDB(t *testing.T, test func(t *testing.T, db *sqlx.DB)) {
+	t.Run("sqlite3", func(t *testing.T) {
+		test(t, openTestDB(t, "sqlite3", "sqlite3", filepath.Join(t.TempDir(), "db.sqlite")))
+	})
+	t.Run("postgres-standin", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db.sqlite") + "?_foreign_keys=on"
+		test(t, openTestDB(t, postgresStandIn, "sqlite3", dsn))
+	})
+
+	if driver, dsn := os.Getenv("DB_DRIVER"), os.Getenv("DB"); driver != "" && driver != "sqlite3" && dsn != "" {
+		t.Run(driver, func(t *testing.T) {
+			test(t, openTestDB(t, driver, driver, dsn))
+		})
+	}
+}
+
+func openTestDB(t *testing.T, driver, dialect, dsn string) *sqlx.DB {
+	t.Helper()
+
+	var db *sqlx.DB
+	var err error
+	if _, ok := database.Drivers[driver]; ok {
+		db, err = database.Open(driver, dsn)
+	} else {
+		db, err = sqlx.Open(driver, dsn)
+	}
+	if err != nil {
+		t.Fatalf("open %s: %v", driver, err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := goose.SetDialect(dialect); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(db.DB, database.MigrationsDir("../migrations", dialect)); err != nil {
+		t.Fatalf("migrate %s: %v", driver, err)
+	}
+	return db
+}
+
+var emailCounter atomic.Int64
+
+// testEmail returns an address no other test uses, even on a shared database.
+func testEmail(name string) string {
+	return fmt.Sprintf("%s-%d-%d@example.com", name, time.Now().UnixNano(), emailCounter.Add(1))
+}
+
+func TestInsert(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		name := testEmail("insert")
+
+		query := "INSERT INTO permissions (name, description, builtin, created) VALUES (?, ?, ?, ?)"
+		first, err := insert(ctx, db, query, name, "first", false, time.Now().UTC())
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		second, err := insert(ctx, db, query, name+"2", "second", false, time.Now().UTC())
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		if first <= 0 || second <= first {
+			t.Fatalf("ids = %d, %d, want increasing positive ids", first, second)
+		}
+
+		var description string
+		err = db.GetContext(ctx, &description, db.Rebind("SELECT description FROM permissions WHERE id = ? AND name = ?"), second, name+"2")
+		if err != nil {
+			t.Fatalf("read back: %v", err)
+		}
+		if description != "second" {
+			t.Errorf("description = %q, want %q", description, "second")
+		}
+	})
+}
+
+func TestInsertDuplicate(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		name := testEmail("duplicate")
+
+		query := "INSERT INTO permissions (name, description, builtin, created) VALUES (?, ?, ?, ?)"
+		if _, err := insert(ctx, db, query, name, "", false, time.Now().UTC()); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		_, err := insert(ctx, db, query, name, "", false, time.Now().UTC())
+		if err == nil {
+			t.Fatal("duplicate insert succeeded")
+		}
+		if err := dbError(err); !errors.Is(err, ErrConflict) || errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("dbError of a duplicate permission = %v, want ErrConflict", err)
+		}
+	})
+}
+
+func TestRebind(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		query := db.Rebind("SELECT id FROM users WHERE email = ? AND active = ?")
+		want := "SELECT id FROM users WHERE email = ? AND active = ?"
+		if sqlx.BindType(db.DriverName()) == sqlx.DOLLAR {
+			want = "SELECT id FROM users WHERE email = $1 AND active = $2"
+		}
+		if query != want {
+			t.Errorf("Rebind = %q, want %q", query, want)
+		}
+	})
+}
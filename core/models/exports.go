@@ -0,0 +1,361 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+// ExportDuration is how long the link to a data export works.
+const ExportDuration = 24 * time.Hour
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+var (
+	ErrExportPending  = apperror.New(http.StatusConflict, "export_pending", "an export of this user is already being prepared")
+	ErrExportNotReady = apperror.New(http.StatusConflict, "export_not_ready", "the export is not ready yet")
+	ErrInvalidExport  = apperror.New(http.StatusNotFound, "invalid_export", "invalid or expired export link")
+)
+
+// ExportSource returns one part of the personal data held about a user,
+// which is written to the archive as JSON.
+type ExportSource func(ctx context.Context, db *sqlx.DB, userID int64) (any, error)
+
+var exportSources = map[string]ExportSource{}
+
+// RegisterExport adds a source of personal data to every export, written to
+// name.json in the archive. Extensions that store data about users register
+// theirs at init time. Registering a name twice panics.
+func RegisterExport(name string, source ExportSource) {
+	if _, ok := exportSources[name]; ok {
+		panic(fmt.Sprintf("models: export %q registered twice", name))
+	}
+	exportSources[name] = source
+}
+
+// exportRows is an ExportSource that selects the rows of query, which
+// takes the user id as its only argument.
+func exportRows[T any](query string) ExportSource {
+	return func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		rows := []T{}
+		err := db.SelectContext(ctx, &rows, db.Rebind(query), userID)
+		return rows, err
+	}
+}
+
+type exportedRole struct {
+	Name    string    `db:"name" json:"name"`
+	Granted time.Time `db:"created" json:"granted"`
+}
+
+// exportedOAuthToken is an OAuthToken without its hash.
+type exportedOAuthToken struct {
+	ID       int64      `db:"id" json:"id"`
+	Kind     string     `db:"kind" json:"kind"`
+	ClientID string     `db:"client_id" json:"client_id"`
+	Scope    SpaceList  `db:"scope" json:"scope"`
+	Expires  time.Time  `db:"expires" json:"expires"`
+	Revoked  *time.Time `db:"revoked" json:"revoked"`
+	Created  time.Time  `db:"created" json:"created"`
+}
+
+// redacted stands in an export for the details of someone else.
+const redacted = "[redacted]"
+
+// exportedActorEvent is an audit event in which the user changed someone
+// else. Only what the user did is exported, not the other user's details.
+type exportedActorEvent struct {
+	ID       int64     `json:"id"`
+	Action   string    `json:"action"`
+	TargetID *int64    `json:"target_id"`
+	Diff     string    `json:"diff"`
+	Created  time.Time `json:"created"`
+}
+
+// The built-in sources cover every table with a user id. Secrets and the
+// hashes of passwords and tokens are left out by the json tags.
+func init() {
+	RegisterExport("user", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		var user User
+		query := fmt.Sprintf("SELECT %s FROM users WHERE id = ?", userColumns)
+		err := db.GetContext(ctx, &user, db.Rebind(query), userID)
+		return user, dbError(err)
+	})
+	RegisterExport("roles", exportRows[exportedRole](
+		"SELECT r.name, ur.created FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = ? ORDER BY r.name"))
+	RegisterExport("organizations", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		return NewOrganizationRepository(db).ListUser(ctx, userID)
+	})
+	RegisterExport("sessions", exportRows[Session](
+		"SELECT id, user_id, organization_id, expires, created, updated FROM sessions WHERE user_id = ? ORDER BY id"))
+	RegisterExport("refresh_tokens", exportRows[RefreshToken](
+		"SELECT id, family, user_id, revoked, expires, created, updated FROM refresh_tokens WHERE user_id = ? ORDER BY id"))
+	RegisterExport("api_keys", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		return NewAPIKeyRepository(db).ListUser(ctx, userID)
+	})
+	RegisterExport("passkeys", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		return NewPasskeyRepository(db).ListUser(ctx, userID)
+	})
+	RegisterExport("identities", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		return NewUserIdentityRepository(db).ListUser(ctx, userID)
+	})
+	RegisterExport("totp", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		totp, err := NewTOTPRepository(db).Get(ctx, userID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return totp, err
+	})
+	RegisterExport("oauth_consents", exportRows[OAuthConsent](
+		"SELECT id, user_id, client_id, scope, created FROM oauth_consents WHERE user_id = ? ORDER BY id"))
+	RegisterExport("oauth_tokens", exportRows[exportedOAuthToken](
+		"SELECT id, kind, client_id, scope, expires, revoked, created FROM oauth_tokens WHERE user_id = ? ORDER BY id"))
+	// The invitations the user sent hold the addresses of other people.
+	RegisterExport("invitations", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		var email string
+		if err := db.GetContext(ctx, &email, db.Rebind("SELECT email FROM users WHERE id = ?"), userID); err != nil {
+			return nil, dbError(err)
+		}
+
+		invitations := []Invitation{}
+		query := "SELECT " + invitationColumns + " FROM invitations WHERE email = ? OR invited_by = ? ORDER BY id"
+		if err := db.SelectContext(ctx, &invitations, db.Rebind(query), email, userID); err != nil {
+			return nil, err
+		}
+		for i := range invitations {
+			if invitations[i].Email != email {
+				invitations[i].Email = redacted
+			}
+		}
+		return invitations, nil
+	})
+	// The events in which the user only acted hold the details of the
+	// users they changed.
+	RegisterExport("audit_events", func(ctx context.Context, db *sqlx.DB, userID int64) (any, error) {
+		var events []AuditEvent
+		query := "SELECT " + auditColumns + " FROM audit_events WHERE actor_id = ? OR target_id = ? ORDER BY id"
+		if err := db.SelectContext(ctx, &events, db.Rebind(query), userID, userID); err != nil {
+			return nil, err
+		}
+
+		exported := []any{}
+		for _, event := range events {
+			if event.TargetID != nil && *event.TargetID == userID {
+				exported = append(exported, event)
+				continue
+			}
+			exported = append(exported, exportedActorEvent{
+				ID:       event.ID,
+				Action:   event.Action,
+				TargetID: event.TargetID,
+				Diff:     redacted,
+				Created:  event.Created,
+			})
+		}
+		return exported, nil
+	})
+}
+
+// BuildExport collects everything held about the user from every
+// registered source into a ZIP of JSON files, with an index in
+// export.json.
+func BuildExport(ctx context.Context, db *sqlx.DB, userID int64) ([]byte, error) {
+	names := []string{}
+	for name := range exportSources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	now := time.Now().UTC()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	write := func(name string, v any) error {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: name + ".json", Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	index := map[string]any{"user_id": userID, "created": now, "files": names}
+	if err := write("export", index); err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		data, err := exportSources[name](ctx, db, userID)
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", name, err)
+		}
+		if err := write(name, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DataExport is a request for the personal data of a user. The archive is
+// built in the background and downloaded with the token while it has not
+// expired. Only the hash of the token is stored.
+type DataExport struct {
+	ID          int64      `db:"id" json:"id"`
+	UserID      int64      `db:"user_id" json:"user_id"`
+	RequestedBy *int64     `db:"requested_by" json:"requested_by"`
+	Token       string     `db:"token" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	Archive     []byte     `db:"archive" json:"-"`
+	Expires     time.Time  `db:"expires" json:"expires"`
+	Completed   *time.Time `db:"completed" json:"completed"`
+	Created     time.Time  `db:"created" json:"created"`
+	Updated     time.Time  `db:"updated" json:"updated"`
+}
+
+type DataExportRepository interface {
+	Create(ctx context.Context, userID int64, requestedBy *int64) (DataExport, string, error)
+	Get(ctx context.Context, id int64) (DataExport, error)
+	Complete(ctx context.Context, id int64, archive []byte) error
+	Fail(ctx context.Context, id int64) error
+	Download(ctx context.Context, token string) (DataExport, error)
+	DeleteExpired(ctx context.Context) error
+}
+
+type SQLDataExportRepository struct {
+	DB *sqlx.DB
+}
+
+func NewDataExportRepository(db *sqlx.DB) *SQLDataExportRepository {
+	return &SQLDataExportRepository{DB: db}
+}
+
+// dataExportColumns leaves out the archive, which only Download reads.
+const dataExportColumns = "id, user_id, requested_by, token, status, expires, completed, created, updated"
+
+// Create starts a pending export of the user and returns it with the raw
+// token of its download link. A user has one pending export at a time.
+func (repo *SQLDataExportRepository) Create(ctx context.Context, userID int64, requestedBy *int64) (DataExport, string, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return DataExport{}, "", err
+	}
+
+	now := time.Now().UTC()
+	export := DataExport{
+		UserID:      userID,
+		RequestedBy: requestedBy,
+		Token:       hash,
+		Status:      ExportPending,
+		Expires:     now.Add(ExportDuration),
+		Created:     now,
+		Updated:     now,
+	}
+
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return DataExport{}, "", err
+	}
+	defer tx.Rollback()
+
+	var pending int
+	query := "SELECT COUNT(*) FROM data_exports WHERE user_id = ? AND status = ? AND expires > ?"
+	if err := tx.GetContext(ctx, &pending, tx.Rebind(query), userID, ExportPending, now); err != nil {
+		return DataExport{}, "", err
+	}
+	if pending > 0 {
+		return DataExport{}, "", ErrExportPending
+	}
+
+	query = "INSERT INTO data_exports (user_id, requested_by, token, status, expires, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	export.ID, err = insert(ctx, tx, query, export.UserID, export.RequestedBy, export.Token, export.Status, export.Expires, export.Created, export.Updated)
+	if err != nil {
+		return DataExport{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return DataExport{}, "", err
+	}
+	return export, token, nil
+}
+
+func (repo *SQLDataExportRepository) Get(ctx context.Context, id int64) (DataExport, error) {
+	var export DataExport
+	query := "SELECT " + dataExportColumns + " FROM data_exports WHERE id = ?"
+	err := repo.DB.GetContext(ctx, &export, repo.DB.Rebind(query), id)
+	return export, dbError(err)
+}
+
+// Complete stores the archive of a pending export. The link works for
+// ExportDuration from now on.
+func (repo *SQLDataExportRepository) Complete(ctx context.Context, id int64, archive []byte) error {
+	now := time.Now().UTC()
+	query := "UPDATE data_exports SET status = ?, archive = ?, expires = ?, completed = ?, updated = ? WHERE id = ? AND status = ?"
+	return repo.finish(ctx, query, ExportReady, archive, now.Add(ExportDuration), now, now, id, ExportPending)
+}
+
+func (repo *SQLDataExportRepository) Fail(ctx context.Context, id int64) error {
+	now := time.Now().UTC()
+	query := "UPDATE data_exports SET status = ?, completed = ?, updated = ? WHERE id = ? AND status = ?"
+	return repo.finish(ctx, query, ExportFailed, now, now, id, ExportPending)
+}
+
+func (repo *SQLDataExportRepository) finish(ctx context.Context, query string, args ...any) error {
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Download returns the export for the raw token with its archive. Unknown
+// and expired tokens fail with ErrInvalidExport, exports that are not ready
+// with ErrExportNotReady.
+func (repo *SQLDataExportRepository) Download(ctx context.Context, token string) (DataExport, error) {
+	var export DataExport
+	query := "SELECT " + dataExportColumns + ", archive FROM data_exports WHERE token = ? AND expires > ?"
+	err := repo.DB.GetContext(ctx, &export, repo.DB.Rebind(query), HashToken(token), time.Now().UTC())
+	if err = dbError(err); errors.Is(err, ErrNotFound) {
+		return DataExport{}, ErrInvalidExport
+	} else if err != nil {
+		return DataExport{}, err
+	}
+
+	switch export.Status {
+	case ExportReady:
+		return export, nil
+	case ExportPending:
+		return DataExport{}, ErrExportNotReady
+	default:
+		return DataExport{}, ErrInvalidExport
+	}
+}
+
+// DeleteExpired removes the exports whose link has expired, archives
+// included.
+func (repo *SQLDataExportRepository) DeleteExpired(ctx context.Context) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM data_exports WHERE expires <= ?"), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// exportJSON returns the JSON the named source exports for the user.
+func exportJSON(t *testing.T, db *sqlx.DB, name string, userID int64) string {
+	t.Helper()
+
+	data, err := exportSources[name](context.Background(), db, userID)
+	if err != nil {
+		t.Fatalf("export %s: %v", name, err)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExportRedactsOtherUsers(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		users := NewAuditedUserRepository(NewUserRepository(db), NewAuditRepository(db))
+
+		admin, err := users.Create(ctx, User{Email: testEmail("exporter"), Password: "password1", Admin: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		other, err := users.Create(ctx, User{Email: testEmail("other"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		ctx = WithAuditMeta(ctx, AuditMeta{ActorID: &admin.ID})
+		if _, err := users.UpdateActive(ctx, other.ID, true); err != nil {
+			t.Fatalf("UpdateActive: %v", err)
+		}
+		invitee := testEmail("invitee")
+		if _, _, err := NewInvitationRepository(db).Create(ctx, Invitation{Email: invitee, Role: RoleStaff, InvitedBy: &admin.ID}); err != nil {
+			t.Fatalf("Create invitation: %v", err)
+		}
+
+		events := exportJSON(t, db, "audit_events", admin.ID)
+		if strings.Contains(events, other.Email) || !strings.Contains(events, `"action":"user.update_active","target_id":`+strconv.FormatInt(other.ID, 10)+`,"diff":"[redacted]"`) {
+			t.Errorf("audit_events of the actor = %s", events)
+		}
+		if events := exportJSON(t, db, "audit_events", other.ID); !strings.Contains(events, other.Email) {
+			t.Errorf("audit_events of the target = %s, want its own email", events)
+		}
+
+		invitations := exportJSON(t, db, "invitations", admin.ID)
+		if strings.Contains(invitations, invitee) || !strings.Contains(invitations, redacted) {
+			t.Errorf("invitations of the inviter = %s", invitations)
+		}
+	})
+}
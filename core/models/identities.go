@@ -0,0 +1,264 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+const OIDCStateDuration = 10 * time.Minute
+
+var (
+	ErrIdentityLinked          = apperror.New(http.StatusConflict, "identity_linked", "this identity is linked to another account")
+	ErrUnverifiedIdentityEmail = apperror.New(http.StatusBadRequest, "unverified_identity_email", "the provider did not report a verified email address")
+)
+
+// UserIdentity links a user to their account at an external identity
+// provider, identified by the provider's subject.
+type UserIdentity struct {
+	ID        int64      `db:"id" json:"id"`
+	UserID    int64      `db:"user_id" json:"user_id"`
+	Provider  string     `db:"provider" json:"provider"`
+	Subject   string     `db:"subject" json:"subject"`
+	Email     string     `db:"email" json:"email"`
+	LastLogin *time.Time `db:"last_login" json:"last_login"`
+	Created   time.Time  `db:"created" json:"created"`
+}
+
+type UserIdentityRepository interface {
+	Create(ctx context.Context, identity UserIdentity) (UserIdentity, error)
+	Get(ctx context.Context, provider, subject string) (UserIdentity, error)
+	ListUser(ctx context.Context, userID int64) ([]UserIdentity, error)
+	Touch(ctx context.Context, id int64, email string) error
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+type SQLUserIdentityRepository struct {
+	DB *sqlx.DB
+}
+
+func NewUserIdentityRepository(db *sqlx.DB) *SQLUserIdentityRepository {
+	return &SQLUserIdentityRepository{DB: db}
+}
+
+func (repo *SQLUserIdentityRepository) Create(ctx context.Context, identity UserIdentity) (UserIdentity, error) {
+	now := time.Now().UTC()
+	identity.Created, identity.LastLogin = now, &now
+
+	query := "INSERT INTO user_identities (user_id, provider, subject, email, last_login, created) VALUES (?, ?, ?, ?, ?, ?)"
+	id, err := insert(ctx, repo.DB, query, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.LastLogin, identity.Created)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+
+	identity.ID = id
+	return identity, nil
+}
+
+func (repo *SQLUserIdentityRepository) Get(ctx context.Context, provider, subject string) (UserIdentity, error) {
+	var identity UserIdentity
+	query := "SELECT id, user_id, provider, subject, email, last_login, created FROM user_identities WHERE provider = ? AND subject = ?"
+	err := repo.DB.GetContext(ctx, &identity, repo.DB.Rebind(query), provider, subject)
+	return identity, dbError(err)
+}
+
+func (repo *SQLUserIdentityRepository) ListUser(ctx context.Context, userID int64) ([]UserIdentity, error) {
+	identities := []UserIdentity{}
+	query := "SELECT id, user_id, provider, subject, email, last_login, created FROM user_identities WHERE user_id = ? ORDER BY id"
+	err := repo.DB.SelectContext(ctx, &identities, repo.DB.Rebind(query), userID)
+	return identities, dbError(err)
+}
+
+// Touch records a login through the identity and the email the provider
+// currently reports.
+func (repo *SQLUserIdentityRepository) Touch(ctx context.Context, id int64, email string) error {
+	query := "UPDATE user_identities SET email = ?, last_login = ? WHERE id = ?"
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), email, time.Now().UTC(), id)
+	return err
+}
+
+// Delete unlinks an identity of the user. Identities of other users are not
+// found.
+func (repo *SQLUserIdentityRepository) Delete(ctx context.Context, userID, id int64) error {
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM user_identities WHERE id = ? AND user_id = ?"), id, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SignInWithIdentity returns the user an external identity signs in as.
+// Known identities sign in as their user. New ones are linked to linkUserID
+// when set, otherwise to the user with the same email, which the provider
+// must have verified, and a user is created when there is none.
+//
+// An unverified user with the email may have been registered by someone
+// else before its owner arrived. Such an account is claimed: its password is
+// replaced, so that it can only be used through the provider until the owner
+// resets it, and claimed is true so the caller ends its other sign-ins.
+func SignInWithIdentity(ctx context.Context, users UserRepository, identities UserIdentityRepository, identity UserIdentity, emailVerified bool, linkUserID *int64) (user User, claimed bool, err error) {
+	existing, err := identities.Get(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if linkUserID != nil && *linkUserID != existing.UserID {
+			return User{}, false, ErrIdentityLinked
+		}
+		if err := identities.Touch(ctx, existing.ID, identity.Email); err != nil {
+			return User{}, false, err
+		}
+		user, err = users.Get(ctx, existing.UserID)
+		return user, false, err
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return User{}, false, err
+	}
+
+	switch {
+	case linkUserID != nil:
+		user, err = users.Get(ctx, *linkUserID)
+	case identity.Email == "" || !emailVerified:
+		return User{}, false, ErrUnverifiedIdentityEmail
+	default:
+		user, err = users.GetByEmail(ctx, identity.Email)
+		if errors.Is(err, ErrNotFound) {
+			// The account can only be used through the provider until the
+			// user sets a password with the reset flow.
+			var password string
+			if password, _, err = NewToken(); err != nil {
+				return User{}, false, err
+			}
+			user, err = users.Create(ctx, User{Email: identity.Email, Password: password, Active: true, Verified: true})
+		} else if err == nil && !user.Verified {
+			user, err = claimUser(ctx, users, user.ID)
+			claimed = true
+		}
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+
+	identity.UserID = user.ID
+	if _, err := identities.Create(ctx, identity); err != nil {
+		return User{}, false, err
+	}
+	return user, claimed, nil
+}
+
+// claimUser verifies an unverified user for the owner of its email, after
+// replacing the password whoever registered it chose.
+func claimUser(ctx context.Context, users UserRepository, id int64) (User, error) {
+	password, _, err := NewToken()
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := users.UpdatePassword(ctx, id, password); err != nil {
+		return User{}, err
+	}
+	return users.Verify(ctx, id)
+}
+
+// OIDCState is a sign-in attempt in progress with an external provider: the
+// state sent along with the user, and the nonce and PKCE verifier needed to
+// check the result. UserID is set when an already signed in user is linking
+// a new identity.
+type OIDCState struct {
+	ID       int64     `db:"id"`
+	State    string    `db:"state"`
+	Provider string    `db:"provider"`
+	Nonce    string    `db:"nonce"`
+	Verifier string    `db:"verifier"`
+	Redirect string    `db:"redirect"`
+	UserID   *int64    `db:"user_id"`
+	Expires  time.Time `db:"expires"`
+	Created  time.Time `db:"created"`
+}
+
+type OIDCStateRepository interface {
+	Create(ctx context.Context, provider, redirect string, userID *int64) (OIDCState, string, error)
+	Consume(ctx context.Context, state, provider string) (OIDCState, error)
+}
+
+type SQLOIDCStateRepository struct {
+	DB *sqlx.DB
+}
+
+func NewOIDCStateRepository(db *sqlx.DB) *SQLOIDCStateRepository {
+	return &SQLOIDCStateRepository{DB: db}
+}
+
+// Create starts a sign-in attempt and returns it with the raw state. The
+// nonce and verifier are fresh random values; only the state is hashed, as
+// the others have to be sent to the provider later.
+func (repo *SQLOIDCStateRepository) Create(ctx context.Context, provider, redirect string, userID *int64) (OIDCState, string, error) {
+	state, hash, err := NewToken()
+	if err != nil {
+		return OIDCState{}, "", err
+	}
+	nonce, _, err := NewToken()
+	if err != nil {
+		return OIDCState{}, "", err
+	}
+	verifier, _, err := NewToken()
+	if err != nil {
+		return OIDCState{}, "", err
+	}
+
+	now := time.Now().UTC()
+	s := OIDCState{
+		State:    hash,
+		Provider: provider,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Redirect: redirect,
+		UserID:   userID,
+		Expires:  now.Add(OIDCStateDuration),
+		Created:  now,
+	}
+
+	if _, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM oidc_states WHERE expires <= ?"), now); err != nil {
+		return OIDCState{}, "", err
+	}
+
+	query := "INSERT INTO oidc_states (state, provider, nonce, verifier, redirect, user_id, expires, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	s.ID, err = insert(ctx, repo.DB, query, s.State, s.Provider, s.Nonce, s.Verifier, s.Redirect, s.UserID, s.Expires, s.Created)
+	if err != nil {
+		return OIDCState{}, "", err
+	}
+
+	return s, state, nil
+}
+
+// Consume returns and deletes the unexpired attempt for the raw state, or
+// fails with ErrInvalidVerificationToken.
+func (repo *SQLOIDCStateRepository) Consume(ctx context.Context, state, provider string) (OIDCState, error) {
+	var s OIDCState
+	query := "SELECT id, state, provider, nonce, verifier, redirect, user_id, expires, created FROM oidc_states WHERE state = ? AND provider = ? AND expires > ?"
+	if err := repo.DB.GetContext(ctx, &s, repo.DB.Rebind(query), HashToken(state), provider, time.Now().UTC()); err != nil {
+		if err = dbError(err); errors.Is(err, ErrNotFound) {
+			return OIDCState{}, ErrInvalidVerificationToken
+		}
+		return OIDCState{}, err
+	}
+
+	// Only the request that deletes the row may use it.
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM oidc_states WHERE id = ?"), s.ID)
+	if err != nil {
+		return OIDCState{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return OIDCState{}, err
+	} else if n == 0 {
+		return OIDCState{}, ErrInvalidVerificationToken
+	}
+
+	return s, nil
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSignInWithIdentity(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		users, identities := NewUserRepository(db), NewUserIdentityRepository(db)
+		email := testEmail("identity")
+
+		identity := UserIdentity{Provider: "test", Subject: email, Email: email}
+		if _, _, err := SignInWithIdentity(ctx, users, identities, identity, false, nil); !errors.Is(err, ErrUnverifiedIdentityEmail) {
+			t.Errorf("SignInWithIdentity with an unverified email = %v, want ErrUnverifiedIdentityEmail", err)
+		}
+		if _, err := users.GetByEmail(ctx, email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("user created for an unverified email: %v", err)
+		}
+
+		created, claimed, err := SignInWithIdentity(ctx, users, identities, identity, true, nil)
+		if err != nil {
+			t.Fatalf("SignInWithIdentity: %v", err)
+		}
+		if created.Email != email || !created.Verified || !created.Active || claimed {
+			t.Errorf("SignInWithIdentity created %+v, claimed %t", created, claimed)
+		}
+
+		// Known identities sign in as their user whatever email they report.
+		identity.Email = testEmail("renamed")
+		again, _, err := SignInWithIdentity(ctx, users, identities, identity, false, nil)
+		if err != nil || again.ID != created.ID {
+			t.Errorf("SignInWithIdentity again = %d, %v, want %d", again.ID, err, created.ID)
+		}
+
+		other, err := users.Create(ctx, User{Email: testEmail("other"), Password: "password1", Active: true, Verified: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, _, err := SignInWithIdentity(ctx, users, identities, identity, true, &other.ID); !errors.Is(err, ErrIdentityLinked) {
+			t.Errorf("linking an identity of another user = %v, want ErrIdentityLinked", err)
+		}
+
+		// Linking does not need a verified email.
+		link := UserIdentity{Provider: "test", Subject: testEmail("link")}
+		linked, _, err := SignInWithIdentity(ctx, users, identities, link, false, &other.ID)
+		if err != nil || linked.ID != other.ID {
+			t.Errorf("SignInWithIdentity linking = %d, %v, want %d", linked.ID, err, other.ID)
+		}
+		if list, err := identities.ListUser(ctx, other.ID); err != nil || len(list) != 1 {
+			t.Errorf("ListUser = %d identities, %v, want 1", len(list), err)
+		}
+	})
+}
+
+func TestSignInWithIdentityExistingUser(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		users, identities := NewUserRepository(db), NewUserIdentityRepository(db)
+
+		verified, err := users.Create(ctx, User{Email: testEmail("verified"), Password: "password1", Active: true, Verified: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		identity := UserIdentity{Provider: "test", Subject: verified.Email, Email: verified.Email}
+		user, claimed, err := SignInWithIdentity(ctx, users, identities, identity, true, nil)
+		if err != nil || user.ID != verified.ID || claimed {
+			t.Errorf("SignInWithIdentity = %d, %t, %v, want %d unclaimed", user.ID, claimed, err, verified.ID)
+		}
+		if got, _ := users.Get(ctx, verified.ID); !CheckPassword(got.Password, "password1") {
+			t.Error("linking a verified user changed its password")
+		}
+
+		// Whoever registered the unverified account loses the password
+		// they chose.
+		squatted, err := users.Create(ctx, User{Email: testEmail("squatted"), Password: "password1", Active: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		identity = UserIdentity{Provider: "test", Subject: squatted.Email, Email: squatted.Email}
+		user, claimed, err = SignInWithIdentity(ctx, users, identities, identity, true, nil)
+		if err != nil || user.ID != squatted.ID || !claimed {
+			t.Fatalf("SignInWithIdentity = %d, %t, %v, want %d claimed", user.ID, claimed, err, squatted.ID)
+		}
+		got, err := users.Get(ctx, squatted.ID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if !got.Verified || CheckPassword(got.Password, "password1") {
+			t.Errorf("claimed user verified %t, old password kept %t", got.Verified, CheckPassword(got.Password, "password1"))
+		}
+	})
+}
+
+func TestOIDCStateConsume(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewOIDCStateRepository(db)
+
+		s, state, err := repo.Create(ctx, "test", "/next", nil)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if s.State == state || s.Nonce == "" || s.Verifier == "" {
+			t.Errorf("Create = %+v", s)
+		}
+
+		if _, err := repo.Consume(ctx, state, "other"); !errors.Is(err, ErrInvalidVerificationToken) {
+			t.Errorf("Consume for another provider = %v, want ErrInvalidVerificationToken", err)
+		}
+		got, err := repo.Consume(ctx, state, "test")
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+		if got.Nonce != s.Nonce || got.Verifier != s.Verifier || got.Redirect != "/next" {
+			t.Errorf("Consume = %+v, want %+v", got, s)
+		}
+		if _, err := repo.Consume(ctx, state, "test"); !errors.Is(err, ErrInvalidVerificationToken) {
+			t.Errorf("Consume again = %v, want ErrInvalidVerificationToken", err)
+		}
+
+		_, expired, err := repo.Create(ctx, "test", "", nil)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		query := db.Rebind("UPDATE oidc_states SET expires = ? WHERE state = ?")
+		if _, err := db.ExecContext(ctx, query, time.Now().UTC().Add(-time.Minute), HashToken(expired)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Consume(ctx, expired, "test"); !errors.Is(err, ErrInvalidVerificationToken) {
+			t.Errorf("Consume expired = %v, want ErrInvalidVerificationToken", err)
+		}
+	})
+}
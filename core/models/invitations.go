@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+const InvitationDuration = 7 * 24 * time.Hour
+
+var ErrInvalidInvitation = apperror.New(http.StatusBadRequest, "invalid_invitation", "invalid or expired invitation")
+
+// Invitation is mailed to an email address to let its owner join, with a
+// platform role and a role in an organization when they are set. Like a
+// verification token, only the hash of its token is stored.
+type Invitation struct {
+	ID               int64      `db:"id" json:"id"`
+	Email            string     `db:"email" json:"email"`
+	Token            string     `db:"token" json:"-"`
+	Role             string     `db:"role" json:"role"`
+	OrganizationID   *int64     `db:"organization_id" json:"organization_id"`
+	OrganizationRole string     `db:"organization_role" json:"organization_role"`
+	InvitedBy        *int64     `db:"invited_by" json:"invited_by"`
+	Expires          time.Time  `db:"expires" json:"expires"`
+	Accepted         *time.Time `db:"accepted" json:"accepted"`
+	Created          time.Time  `db:"created" json:"created"`
+	Updated          time.Time  `db:"updated" json:"updated"`
+}
+
+type InvitationRepository interface {
+	Create(ctx context.Context, invitation Invitation) (Invitation, string, error)
+	Get(ctx context.Context, id int64) (Invitation, error)
+	GetByToken(ctx context.Context, token string) (Invitation, error)
+	ListPending(ctx context.Context, organizationID *int64) ([]Invitation, error)
+	Resend(ctx context.Context, id int64) (Invitation, string, error)
+	Accept(ctx context.Context, token string) (Invitation, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type SQLInvitationRepository struct {
+	DB *sqlx.DB
+}
+
+func NewInvitationRepository(db *sqlx.DB) *SQLInvitationRepository {
+	return &SQLInvitationRepository{DB: db}
+}
+
+const invitationColumns = "id, email, token, role, organization_id, organization_role, invited_by, expires, accepted, created, updated"
+
+// Create stores the invitation and returns it with its raw token, valid for
+// InvitationDuration.
+func (repo *SQLInvitationRepository) Create(ctx context.Context, invitation Invitation) (Invitation, string, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	now := time.Now().UTC()
+	invitation.Token = hash
+	invitation.Expires = now.Add(InvitationDuration)
+	invitation.Accepted = nil
+	invitation.Created = now
+	invitation.Updated = now
+
+	query := `INSERT INTO invitations (email, token, role, organization_id, organization_role, invited_by, expires, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	invitation.ID, err = insert(ctx, repo.DB, query, invitation.Email, invitation.Token, invitation.Role, invitation.OrganizationID,
+		invitation.OrganizationRole, invitation.InvitedBy, invitation.Expires, invitation.Created, invitation.Updated)
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	return invitation, token, nil
+}
+
+func (repo *SQLInvitationRepository) Get(ctx context.Context, id int64) (Invitation, error) {
+	var invitation Invitation
+	query := "SELECT " + invitationColumns + " FROM invitations WHERE id = ?"
+	err := repo.DB.GetContext(ctx, &invitation, repo.DB.Rebind(query), id)
+	return invitation, dbError(err)
+}
+
+// GetByToken returns the pending, unexpired invitation for the raw token,
+// or ErrInvalidInvitation.
+func (repo *SQLInvitationRepository) GetByToken(ctx context.Context, token string) (Invitation, error) {
+	var invitation Invitation
+	query := "SELECT " + invitationColumns + " FROM invitations WHERE token = ? AND accepted IS NULL AND expires > ?"
+	err := repo.DB.GetContext(ctx, &invitation, repo.DB.Rebind(query), HashToken(token), time.Now().UTC())
+	if err = dbError(err); errors.Is(err, ErrNotFound) {
+		return Invitation{}, ErrInvalidInvitation
+	}
+	return invitation, err
+}
+
+// ListPending returns the invitations that have not been accepted, expired
+// ones included so that they can be resent. With organizationID it only
+// returns the invitations into that organization.
+func (repo *SQLInvitationRepository) ListPending(ctx context.Context, organizationID *int64) ([]Invitation, error) {
+	invitations := []Invitation{}
+	query := "SELECT " + invitationColumns + " FROM invitations WHERE accepted IS NULL"
+	var args []any
+	if organizationID != nil {
+		query += " AND organization_id = ?"
+		args = append(args, *organizationID)
+	}
+	query += " ORDER BY created DESC, id DESC"
+
+	err := repo.DB.SelectContext(ctx, &invitations, repo.DB.Rebind(query), args...)
+	return invitations, dbError(err)
+}
+
+// Resend replaces the token of a pending invitation, which invalidates the
+// one sent before, and restarts its expiry.
+func (repo *SQLInvitationRepository) Resend(ctx context.Context, id int64) (Invitation, string, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return Invitation{}, "", err
+	}
+
+	now := time.Now().UTC()
+	query := "UPDATE invitations SET token = ?, expires = ?, updated = ? WHERE id = ? AND accepted IS NULL"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), hash, now.Add(InvitationDuration), now, id)
+	if err != nil {
+		return Invitation{}, "", err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Invitation{}, "", err
+	} else if n == 0 {
+		return Invitation{}, "", ErrNotFound
+	}
+
+	invitation, err := repo.Get(ctx, id)
+	return invitation, token, err
+}
+
+// Accept marks the pending invitation for the raw token as accepted and
+// returns it. Each invitation can be accepted once.
+func (repo *SQLInvitationRepository) Accept(ctx context.Context, token string) (Invitation, error) {
+	now := time.Now().UTC()
+	query := "UPDATE invitations SET accepted = ?, updated = ? WHERE token = ? AND accepted IS NULL AND expires > ?"
+	result, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), now, now, HashToken(token), now)
+	if err != nil {
+		return Invitation{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Invitation{}, err
+	} else if n == 0 {
+		return Invitation{}, ErrInvalidInvitation
+	}
+
+	var invitation Invitation
+	query = "SELECT " + invitationColumns + " FROM invitations WHERE token = ?"
+	err = conn(ctx, repo.DB).GetContext(ctx, &invitation, repo.DB.Rebind(query), HashToken(token))
+	return invitation, dbError(err)
+}
+
+// Delete revokes an invitation. Accepted ones are kept, as a record of how
+// their user joined.
+func (repo *SQLInvitationRepository) Delete(ctx context.Context, id int64) error {
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM invitations WHERE id = ? AND accepted IS NULL"), id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
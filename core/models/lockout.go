@@ -0,0 +1,240 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrTooManyAttempts = apperror.New(http.StatusTooManyRequests, "too_many_attempts", "too many failed sign-in attempts, try again later")
+
+// MaxLockout caps the lockouts that grow with every repeated one.
+const MaxLockout = 24 * time.Hour
+
+// LockoutPolicy limits failed sign-ins, per email and per client IP. Each
+// failure for an email delays the next attempt by Backoff, doubled with
+// every failure after the first. Reaching a threshold locks the email or
+// IP out for Duration, doubled with every lockout that follows until the
+// failures are forgotten after Window without one.
+type LockoutPolicy struct {
+	Threshold   int
+	IPThreshold int
+	Backoff     time.Duration
+	Duration    time.Duration
+	Window      time.Duration
+}
+
+var DefaultLockoutPolicy = LockoutPolicy{
+	Threshold:   10,
+	IPThreshold: 100,
+	Backoff:     time.Second,
+	Duration:    15 * time.Minute,
+	Window:      time.Hour,
+}
+
+// LockoutPolicyFromEnv reads the policy from LOCKOUT_THRESHOLD,
+// LOCKOUT_IP_THRESHOLD, LOCKOUT_BACKOFF, LOCKOUT_DURATION and
+// LOCKOUT_WINDOW, using DefaultLockoutPolicy for those that are not set. A
+// threshold or backoff of 0 turns that limit off.
+func LockoutPolicyFromEnv() (LockoutPolicy, error) {
+	var err error
+	count := func(name string, value int) int {
+		raw := os.Getenv(name)
+		if raw == "" || err != nil {
+			return value
+		}
+		n, parseErr := strconv.Atoi(raw)
+		if parseErr != nil || n < 0 {
+			err = fmt.Errorf("invalid %s %q", name, raw)
+		}
+		return n
+	}
+	duration := func(name string, value time.Duration) time.Duration {
+		raw := os.Getenv(name)
+		if raw == "" || err != nil {
+			return value
+		}
+		d, parseErr := time.ParseDuration(raw)
+		if parseErr != nil || d < 0 {
+			err = fmt.Errorf("invalid %s %q", name, raw)
+		}
+		return d
+	}
+
+	policy := LockoutPolicy{
+		Threshold:   count("LOCKOUT_THRESHOLD", DefaultLockoutPolicy.Threshold),
+		IPThreshold: count("LOCKOUT_IP_THRESHOLD", DefaultLockoutPolicy.IPThreshold),
+		Backoff:     duration("LOCKOUT_BACKOFF", DefaultLockoutPolicy.Backoff),
+		Duration:    duration("LOCKOUT_DURATION", DefaultLockoutPolicy.Duration),
+		Window:      duration("LOCKOUT_WINDOW", DefaultLockoutPolicy.Window),
+	}
+	return policy, err
+}
+
+// ForIP returns the policy for the counters of client IPs, which lock out
+// at IPThreshold and have no backoff, as one IP may be shared by many
+// users.
+func (policy LockoutPolicy) ForIP() LockoutPolicy {
+	policy.Threshold = policy.IPThreshold
+	policy.Backoff = 0
+	return policy
+}
+
+// EmailSubject and IPSubject name the counters of failed sign-ins. Emails
+// are counted whether or not an account has them, and only their hash is
+// stored.
+func EmailSubject(email string) string {
+	return "email:" + HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+func IPSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginAttempt counts the recent failed sign-ins of a subject.
+// BlockedUntil is when the next attempt is allowed, after a backoff delay
+// or a lockout.
+type LoginAttempt struct {
+	Subject      string     `db:"subject" json:"-"`
+	Failures     int        `db:"failures" json:"failures"`
+	Lockouts     int        `db:"lockouts" json:"lockouts"`
+	LastFailure  time.Time  `db:"last_failure" json:"last_failure"`
+	BlockedUntil *time.Time `db:"blocked_until" json:"blocked_until"`
+}
+
+type LoginAttemptRepository interface {
+	Blocked(ctx context.Context, subjects ...string) (time.Time, error)
+	Fail(ctx context.Context, subject string, policy LockoutPolicy) (LoginAttempt, bool, error)
+	Reset(ctx context.Context, subject string) error
+	DeleteStale(ctx context.Context, window time.Duration) error
+}
+
+type SQLLoginAttemptRepository struct {
+	DB *sqlx.DB
+}
+
+func NewLoginAttemptRepository(db *sqlx.DB) *SQLLoginAttemptRepository {
+	return &SQLLoginAttemptRepository{DB: db}
+}
+
+const loginAttemptColumns = "subject, failures, lockouts, last_failure, blocked_until"
+
+// Blocked returns the time until which one of the subjects may not try to
+// sign in, or the zero time when none is blocked.
+func (repo *SQLLoginAttemptRepository) Blocked(ctx context.Context, subjects ...string) (time.Time, error) {
+	query, args, err := sqlx.In("SELECT blocked_until FROM login_attempts WHERE subject IN (?) AND blocked_until > ?", subjects, time.Now().UTC())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var blocked []time.Time
+	if err := repo.DB.SelectContext(ctx, &blocked, repo.DB.Rebind(query), args...); err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	for _, t := range blocked {
+		if t.After(until) {
+			until = t
+		}
+	}
+	return until, nil
+}
+
+// Fail counts a failed sign-in of the subject and blocks it for the backoff
+// delay of the policy, or locks it out once it has failed Threshold times.
+// It reports whether this failure started a lockout.
+func (repo *SQLLoginAttemptRepository) Fail(ctx context.Context, subject string, policy LockoutPolicy) (LoginAttempt, bool, error) {
+	now := time.Now().UTC()
+
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return LoginAttempt{}, false, err
+	}
+	defer tx.Rollback()
+
+	// Writing the row first takes its lock before it is read, as in
+	// SQLAuditRepository.Record.
+	result, err := tx.ExecContext(ctx, tx.Rebind("UPDATE login_attempts SET failures = failures WHERE subject = ?"), subject)
+	if err != nil {
+		return LoginAttempt{}, false, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return LoginAttempt{}, false, err
+	} else if n == 0 {
+		query := "INSERT INTO login_attempts (subject, failures, lockouts, last_failure) VALUES (?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), subject, 0, 0, now); err != nil {
+			return LoginAttempt{}, false, err
+		}
+	}
+
+	var attempt LoginAttempt
+	query := "SELECT " + loginAttemptColumns + " FROM login_attempts WHERE subject = ?"
+	if err := tx.GetContext(ctx, &attempt, tx.Rebind(query), subject); err != nil {
+		return LoginAttempt{}, false, err
+	}
+
+	// The window runs from the end of the last block, so that lockouts
+	// longer than it still grow.
+	quiet := attempt.LastFailure
+	if attempt.BlockedUntil != nil && attempt.BlockedUntil.After(quiet) {
+		quiet = *attempt.BlockedUntil
+	}
+	if now.Sub(quiet) > policy.Window {
+		attempt.Failures, attempt.Lockouts = 0, 0
+	}
+	attempt.Failures++
+	attempt.LastFailure = now
+
+	locked := policy.Threshold > 0 && policy.Duration > 0 && attempt.Failures >= policy.Threshold
+	var delay time.Duration
+	switch {
+	case locked:
+		attempt.Failures = 0
+		attempt.Lockouts++
+		delay = doubled(policy.Duration, attempt.Lockouts-1, MaxLockout)
+	case policy.Backoff > 0:
+		delay = doubled(policy.Backoff, attempt.Failures-1, policy.Duration)
+	}
+	if delay > 0 {
+		until := now.Add(delay)
+		attempt.BlockedUntil = &until
+	}
+
+	query = "UPDATE login_attempts SET failures = ?, lockouts = ?, last_failure = ?, blocked_until = ? WHERE subject = ?"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), attempt.Failures, attempt.Lockouts, attempt.LastFailure, attempt.BlockedUntil, subject); err != nil {
+		return LoginAttempt{}, false, err
+	}
+
+	return attempt, locked, tx.Commit()
+}
+
+// doubled returns d doubled n times, at most max.
+func doubled(d time.Duration, n int, max time.Duration) time.Duration {
+	for ; n > 0 && d < max; n-- {
+		d *= 2
+	}
+	return min(d, max)
+}
+
+// Reset forgets the failures of the subject, lifting any lockout.
+func (repo *SQLLoginAttemptRepository) Reset(ctx context.Context, subject string) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM login_attempts WHERE subject = ?"), subject)
+	return err
+}
+
+// DeleteStale removes the counters that Fail would start over, having had
+// neither a failure nor a block within window.
+func (repo *SQLLoginAttemptRepository) DeleteStale(ctx context.Context, window time.Duration) error {
+	since := time.Now().UTC().Add(-window)
+	query := "DELETE FROM login_attempts WHERE last_failure < ? AND (blocked_until IS NULL OR blocked_until < ?)"
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), since, since)
+	return err
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var testLockoutPolicy = LockoutPolicy{
+	Threshold: 5,
+	Backoff:   time.Second,
+	Duration:  time.Minute,
+	Window:    time.Hour,
+}
+
+// blockedFor is how long the failure recorded in attempt blocks for.
+func blockedFor(attempt LoginAttempt) time.Duration {
+	if attempt.BlockedUntil == nil {
+		return 0
+	}
+	return attempt.BlockedUntil.Sub(attempt.LastFailure)
+}
+
+func TestLoginAttemptBackoffAndLockout(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewLoginAttemptRepository(db)
+		subject := EmailSubject(testEmail("lockout"))
+
+		// Each failure doubles the delay, until the threshold locks out.
+		for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+			attempt, locked, err := repo.Fail(ctx, subject, testLockoutPolicy)
+			if err != nil {
+				t.Fatalf("Fail: %v", err)
+			}
+			if locked || attempt.Failures != i+1 || blockedFor(attempt) != want {
+				t.Errorf("failure %d = %d failures, locked %t, blocked %s, want %s", i+1, attempt.Failures, locked, blockedFor(attempt), want)
+			}
+		}
+
+		attempt, locked, err := repo.Fail(ctx, subject, testLockoutPolicy)
+		if err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		if !locked || attempt.Lockouts != 1 || attempt.Failures != 0 || blockedFor(attempt) != time.Minute {
+			t.Errorf("failure at the threshold = %+v, locked %t, want a lockout of a minute", attempt, locked)
+		}
+
+		until, err := repo.Blocked(ctx, subject, IPSubject("192.0.2.99"))
+		if err != nil || !until.Equal(*attempt.BlockedUntil) {
+			t.Errorf("Blocked = %s, %v, want %s", until, err, attempt.BlockedUntil)
+		}
+
+		// The next lockout lasts twice as long.
+		for range testLockoutPolicy.Threshold {
+			attempt, locked, err = repo.Fail(ctx, subject, testLockoutPolicy)
+			if err != nil {
+				t.Fatalf("Fail: %v", err)
+			}
+		}
+		if !locked || attempt.Lockouts != 2 || blockedFor(attempt) != 2*time.Minute {
+			t.Errorf("second lockout = %+v, locked %t, want two minutes", attempt, locked)
+		}
+
+		if err := repo.Reset(ctx, subject); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+		if until, err := repo.Blocked(ctx, subject); err != nil || !until.IsZero() {
+			t.Errorf("Blocked after Reset = %s, %v", until, err)
+		}
+	})
+}
+
+func TestLoginAttemptBackoffCap(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewLoginAttemptRepository(db)
+		subject := EmailSubject(testEmail("backoff"))
+		policy := LockoutPolicy{Backoff: 10 * time.Second, Duration: 30 * time.Second, Window: time.Hour}
+
+		var attempt LoginAttempt
+		for range 5 {
+			var err error
+			if attempt, _, err = repo.Fail(ctx, subject, policy); err != nil {
+				t.Fatalf("Fail: %v", err)
+			}
+		}
+		// Without a threshold there is no lockout, and the backoff stops
+		// growing at Duration.
+		if attempt.Lockouts != 0 || blockedFor(attempt) != 30*time.Second {
+			t.Errorf("fifth failure = %+v, blocked %s, want 30s", attempt, blockedFor(attempt))
+		}
+
+		attempt, _, err := repo.Fail(ctx, IPSubject("192.0.2.98"), policy.ForIP())
+		if err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		if attempt.BlockedUntil != nil {
+			t.Errorf("failure of an IP blocked until %s, want no backoff", attempt.BlockedUntil)
+		}
+	})
+}
+
+func TestLoginAttemptWindowAndMaxLockout(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewLoginAttemptRepository(db)
+		subject := EmailSubject(testEmail("window"))
+
+		if _, _, err := repo.Fail(ctx, subject, testLockoutPolicy); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		set := func(failures, lockouts int, last time.Time) {
+			t.Helper()
+			query := "UPDATE login_attempts SET failures = ?, lockouts = ?, last_failure = ?, blocked_until = ? WHERE subject = ?"
+			if _, err := db.ExecContext(ctx, db.Rebind(query), failures, lockouts, last.UTC(), last.UTC(), subject); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Failures older than the window are forgotten with their lockouts.
+		set(4, 3, time.Now().Add(-2*time.Hour))
+		attempt, locked, err := repo.Fail(ctx, subject, testLockoutPolicy)
+		if err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		if locked || attempt.Failures != 1 || attempt.Lockouts != 0 || blockedFor(attempt) != time.Second {
+			t.Errorf("failure after the window = %+v, locked %t, want a first failure", attempt, locked)
+		}
+
+		// Within the window they are kept, and lockouts stop growing at
+		// MaxLockout.
+		set(4, 20, time.Now().Add(-time.Minute))
+		attempt, locked, err = repo.Fail(ctx, subject, testLockoutPolicy)
+		if err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		if !locked || attempt.Lockouts != 21 || blockedFor(attempt) != MaxLockout {
+			t.Errorf("21st lockout = %+v, blocked %s, want %s", attempt, blockedFor(attempt), MaxLockout)
+		}
+
+		// DeleteStale keeps counters that are blocked.
+		if err := repo.DeleteStale(ctx, time.Minute); err != nil {
+			t.Fatalf("DeleteStale: %v", err)
+		}
+		if until, err := repo.Blocked(ctx, subject); err != nil || until.IsZero() {
+			t.Errorf("Blocked after DeleteStale = %s, %v, want the lockout kept", until, err)
+		}
+	})
+}
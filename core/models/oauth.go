@@ -0,0 +1,399 @@
+package models
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	OAuthCodeDuration    = time.Minute
+	OAuthAccessDuration  = time.Hour
+	OAuthRefreshDuration = 30 * 24 * time.Hour
+
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+
+	TokenKindAccess  = "access"
+	TokenKindRefresh = "refresh"
+)
+
+var (
+	ErrInvalidClient = apperror.New(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	ErrInvalidGrant  = apperror.New(http.StatusBadRequest, "invalid_grant", "invalid or expired grant")
+)
+
+// SpaceList is a list of words stored space separated, as OAuth does for
+// scopes. Redirect URIs and grant types cannot contain spaces either.
+type SpaceList []string
+
+func (l SpaceList) Value() (driver.Value, error) {
+	return strings.Join(l, " "), nil
+}
+
+func (l *SpaceList) Scan(src any) error {
+	switch v := src.(type) {
+	case string:
+		*l = strings.Fields(v)
+	case []byte:
+		*l = strings.Fields(string(v))
+	case nil:
+		*l = nil
+	default:
+		return fmt.Errorf("cannot scan %T into SpaceList", src)
+	}
+	return nil
+}
+
+func (l SpaceList) String() string {
+	return strings.Join(l, " ")
+}
+
+// Contains reports whether every word of other is in the list.
+func (l SpaceList) Contains(other ...string) bool {
+	for _, word := range other {
+		if !slices.Contains(l, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// OAuthClient is an application registered to sign users in through this
+// service. Public clients, such as single page and mobile apps, have no
+// secret and must use PKCE.
+type OAuthClient struct {
+	ID           int64     `db:"id" json:"id"`
+	ClientID     string    `db:"client_id" json:"client_id"`
+	Secret       string    `db:"secret" json:"-"`
+	Name         string    `db:"name" json:"name"`
+	RedirectURIs SpaceList `db:"redirect_uris" json:"redirect_uris"`
+	GrantTypes   SpaceList `db:"grant_types" json:"grant_types"`
+	Scopes       SpaceList `db:"scopes" json:"scopes"`
+	Public       bool      `db:"public" json:"public"`
+	Created      time.Time `db:"created" json:"created"`
+}
+
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client OAuthClient) (OAuthClient, string, error)
+	Get(ctx context.Context, clientID string) (OAuthClient, error)
+	List(ctx context.Context) ([]OAuthClient, error)
+	Delete(ctx context.Context, id int64) error
+	Authenticate(ctx context.Context, clientID, secret string) (OAuthClient, error)
+}
+
+type SQLOAuthClientRepository struct {
+	DB *sqlx.DB
+}
+
+func NewOAuthClientRepository(db *sqlx.DB) *SQLOAuthClientRepository {
+	return &SQLOAuthClientRepository{DB: db}
+}
+
+const oauthClientColumns = "id, client_id, secret, name, redirect_uris, grant_types, scopes, public, created"
+
+// Create registers the client with a random client id and, unless it is
+// public, a secret that is returned once and only stored hashed.
+func (repo *SQLOAuthClientRepository) Create(ctx context.Context, client OAuthClient) (OAuthClient, string, error) {
+	clientID, _, err := NewToken()
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+	client.ClientID = clientID[:22]
+
+	var secret string
+	if !client.Public {
+		var hash string
+		if secret, hash, err = NewToken(); err != nil {
+			return OAuthClient{}, "", err
+		}
+		client.Secret = hash
+	}
+	client.Created = time.Now().UTC()
+
+	query := "INSERT INTO oauth_clients (client_id, secret, name, redirect_uris, grant_types, scopes, public, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	client.ID, err = insert(ctx, repo.DB, query, client.ClientID, client.Secret, client.Name, client.RedirectURIs, client.GrantTypes, client.Scopes, client.Public, client.Created)
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+
+	return client, secret, nil
+}
+
+func (repo *SQLOAuthClientRepository) Get(ctx context.Context, clientID string) (OAuthClient, error) {
+	var client OAuthClient
+	query := fmt.Sprintf("SELECT %s FROM oauth_clients WHERE client_id = ?", oauthClientColumns)
+	err := repo.DB.GetContext(ctx, &client, repo.DB.Rebind(query), clientID)
+	return client, dbError(err)
+}
+
+func (repo *SQLOAuthClientRepository) List(ctx context.Context) ([]OAuthClient, error) {
+	clients := []OAuthClient{}
+	query := fmt.Sprintf("SELECT %s FROM oauth_clients ORDER BY id", oauthClientColumns)
+	err := repo.DB.SelectContext(ctx, &clients, repo.DB.Rebind(query))
+	return clients, dbError(err)
+}
+
+func (repo *SQLOAuthClientRepository) Delete(ctx context.Context, id int64) error {
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM oauth_clients WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the client if secret is its secret. Public clients
+// authenticate with their client id alone.
+func (repo *SQLOAuthClientRepository) Authenticate(ctx context.Context, clientID, secret string) (OAuthClient, error) {
+	client, err := repo.Get(ctx, clientID)
+	if errors.Is(err, ErrNotFound) {
+		return OAuthClient{}, ErrInvalidClient
+	}
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return OAuthClient{}, ErrInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(HashToken(secret)), []byte(client.Secret)) != 1 {
+		return OAuthClient{}, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens by
+// the client it was issued to.
+type OAuthCode struct {
+	ID            int64      `db:"id"`
+	Code          string     `db:"code"`
+	ClientID      string     `db:"client_id"`
+	UserID        int64      `db:"user_id"`
+	RedirectURI   string     `db:"redirect_uri"`
+	Scope         SpaceList  `db:"scope"`
+	Nonce         string     `db:"nonce"`
+	CodeChallenge string     `db:"code_challenge"`
+	AuthTime      time.Time  `db:"auth_time"`
+	Expires       time.Time  `db:"expires"`
+	Used          *time.Time `db:"used"`
+	Created       time.Time  `db:"created"`
+}
+
+type OAuthCodeRepository interface {
+	Create(ctx context.Context, code OAuthCode) (string, error)
+	Consume(ctx context.Context, code string) (OAuthCode, error)
+}
+
+type SQLOAuthCodeRepository struct {
+	DB *sqlx.DB
+}
+
+func NewOAuthCodeRepository(db *sqlx.DB) *SQLOAuthCodeRepository {
+	return &SQLOAuthCodeRepository{DB: db}
+}
+
+// Create stores the code and returns its raw value.
+func (repo *SQLOAuthCodeRepository) Create(ctx context.Context, code OAuthCode) (string, error) {
+	raw, hash, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	code.Code, code.Expires, code.Created = hash, now.Add(OAuthCodeDuration), now
+
+	if _, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM oauth_codes WHERE expires <= ?"), now); err != nil {
+		return "", err
+	}
+
+	query := "INSERT INTO oauth_codes (code, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = insert(ctx, repo.DB, query, code.Code, code.ClientID, code.UserID, code.RedirectURI, code.Scope, code.Nonce, code.CodeChallenge, code.AuthTime.UTC(), code.Expires, code.Created)
+	return raw, err
+}
+
+// Consume marks an unused, unexpired code as used and returns it, or fails
+// with ErrInvalidGrant.
+func (repo *SQLOAuthCodeRepository) Consume(ctx context.Context, code string) (OAuthCode, error) {
+	now := time.Now().UTC()
+	query := "UPDATE oauth_codes SET used = ? WHERE code = ? AND used IS NULL AND expires > ?"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), now, HashToken(code), now)
+	if err != nil {
+		return OAuthCode{}, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return OAuthCode{}, err
+	} else if n == 0 {
+		return OAuthCode{}, ErrInvalidGrant
+	}
+
+	var c OAuthCode
+	query = "SELECT id, code, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires, used, created FROM oauth_codes WHERE code = ?"
+	err = repo.DB.GetContext(ctx, &c, repo.DB.Rebind(query), HashToken(code))
+	return c, dbError(err)
+}
+
+// OAuthToken is an opaque access or refresh token issued to a client. UserID
+// is nil for client_credentials tokens, which act for the client itself.
+type OAuthToken struct {
+	ID       int64      `db:"id"`
+	Token    string     `db:"token"`
+	Kind     string     `db:"kind"`
+	ClientID string     `db:"client_id"`
+	UserID   *int64     `db:"user_id"`
+	Scope    SpaceList  `db:"scope"`
+	Expires  time.Time  `db:"expires"`
+	Revoked  *time.Time `db:"revoked"`
+	Created  time.Time  `db:"created"`
+}
+
+// Active reports whether the token can still be used.
+func (t OAuthToken) Active() bool {
+	return t.Revoked == nil && time.Now().Before(t.Expires)
+}
+
+type OAuthTokenRepository interface {
+	Create(ctx context.Context, kind, clientID string, userID *int64, scope SpaceList, ttl time.Duration) (OAuthToken, string, error)
+	Get(ctx context.Context, token string) (OAuthToken, error)
+	Revoke(ctx context.Context, id int64) (bool, error)
+	RevokeGrant(ctx context.Context, clientID string, userID int64) error
+	RevokeUser(ctx context.Context, userID int64) error
+}
+
+type SQLOAuthTokenRepository struct {
+	DB *sqlx.DB
+}
+
+func NewOAuthTokenRepository(db *sqlx.DB) *SQLOAuthTokenRepository {
+	return &SQLOAuthTokenRepository{DB: db}
+}
+
+func (repo *SQLOAuthTokenRepository) Create(ctx context.Context, kind, clientID string, userID *int64, scope SpaceList, ttl time.Duration) (OAuthToken, string, error) {
+	raw, hash, err := NewToken()
+	if err != nil {
+		return OAuthToken{}, "", err
+	}
+
+	now := time.Now().UTC()
+	t := OAuthToken{Token: hash, Kind: kind, ClientID: clientID, UserID: userID, Scope: scope, Expires: now.Add(ttl), Created: now}
+
+	query := "INSERT INTO oauth_tokens (token, kind, client_id, user_id, scope, expires, created) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	t.ID, err = insert(ctx, repo.DB, query, t.Token, t.Kind, t.ClientID, t.UserID, t.Scope, t.Expires, t.Created)
+	if err != nil {
+		return OAuthToken{}, "", err
+	}
+
+	return t, raw, nil
+}
+
+// Get returns the token whether or not it is still active.
+func (repo *SQLOAuthTokenRepository) Get(ctx context.Context, token string) (OAuthToken, error) {
+	var t OAuthToken
+	query := "SELECT id, token, kind, client_id, user_id, scope, expires, revoked, created FROM oauth_tokens WHERE token = ?"
+	err := repo.DB.GetContext(ctx, &t, repo.DB.Rebind(query), HashToken(token))
+	return t, dbError(err)
+}
+
+// Revoke revokes the token and reports whether this call did it, so that a
+// refresh token can only be rotated once.
+func (repo *SQLOAuthTokenRepository) Revoke(ctx context.Context, id int64) (bool, error) {
+	query := "UPDATE oauth_tokens SET revoked = ? WHERE id = ? AND revoked IS NULL"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), time.Now().UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// RevokeGrant revokes every token the client holds for the user.
+func (repo *SQLOAuthTokenRepository) RevokeGrant(ctx context.Context, clientID string, userID int64) error {
+	query := "UPDATE oauth_tokens SET revoked = ? WHERE client_id = ? AND user_id = ? AND revoked IS NULL"
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), time.Now().UTC(), clientID, userID)
+	return err
+}
+
+// RevokeUser revokes every token any client holds for the user.
+func (repo *SQLOAuthTokenRepository) RevokeUser(ctx context.Context, userID int64) error {
+	query := "UPDATE oauth_tokens SET revoked = ? WHERE user_id = ? AND revoked IS NULL"
+	_, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), time.Now().UTC(), userID)
+	return err
+}
+
+// OAuthConsent records the scopes a user has allowed a client, so that they
+// are only asked again for new scopes.
+type OAuthConsent struct {
+	ID       int64     `db:"id" json:"id"`
+	UserID   int64     `db:"user_id" json:"user_id"`
+	ClientID string    `db:"client_id" json:"client_id"`
+	Scope    SpaceList `db:"scope" json:"scope"`
+	Created  time.Time `db:"created" json:"created"`
+}
+
+type OAuthConsentRepository interface {
+	Get(ctx context.Context, userID int64, clientID string) (OAuthConsent, error)
+	Grant(ctx context.Context, userID int64, clientID string, scope SpaceList) error
+}
+
+type SQLOAuthConsentRepository struct {
+	DB *sqlx.DB
+}
+
+func NewOAuthConsentRepository(db *sqlx.DB) *SQLOAuthConsentRepository {
+	return &SQLOAuthConsentRepository{DB: db}
+}
+
+func (repo *SQLOAuthConsentRepository) Get(ctx context.Context, userID int64, clientID string) (OAuthConsent, error) {
+	var consent OAuthConsent
+	query := "SELECT id, user_id, client_id, scope, created FROM oauth_consents WHERE user_id = ? AND client_id = ?"
+	err := repo.DB.GetContext(ctx, &consent, repo.DB.Rebind(query), userID, clientID)
+	return consent, dbError(err)
+}
+
+// Grant adds scope to what the user has allowed the client.
+func (repo *SQLOAuthConsentRepository) Grant(ctx context.Context, userID int64, clientID string, scope SpaceList) error {
+	existing, err := repo.Get(ctx, userID, clientID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	for _, s := range scope {
+		if !slices.Contains(existing.Scope, s) {
+			existing.Scope = append(existing.Scope, s)
+		}
+	}
+
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM oauth_consents WHERE user_id = ? AND client_id = ?"), userID, clientID); err != nil {
+		return err
+	}
+	query := "INSERT INTO oauth_consents (user_id, client_id, scope, created) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), userID, clientID, existing.Scope, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestOAuthTokenRevokeUser(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		users, tokens := NewUserRepository(db), NewOAuthTokenRepository(db)
+
+		client, _, err := NewOAuthClientRepository(db).Create(ctx, OAuthClient{Name: "client", GrantTypes: SpaceList{"authorization_code"}})
+		if err != nil {
+			t.Fatalf("Create client: %v", err)
+		}
+		var ids []int64
+		for _, name := range []string{"revoked", "kept"} {
+			user, err := users.Create(ctx, User{Email: testEmail(name), Password: "password1"})
+			if err != nil {
+				t.Fatalf("Create user: %v", err)
+			}
+			ids = append(ids, user.ID)
+		}
+
+		var raw []string
+		for _, userID := range []*int64{&ids[0], &ids[0], &ids[1], nil} {
+			_, token, err := tokens.Create(ctx, TokenKindAccess, client.ClientID, userID, nil, time.Hour)
+			if err != nil {
+				t.Fatalf("Create token: %v", err)
+			}
+			raw = append(raw, token)
+		}
+
+		if err := tokens.RevokeUser(ctx, ids[0]); err != nil {
+			t.Fatalf("RevokeUser: %v", err)
+		}
+		for i, want := range []bool{false, false, true, true} {
+			got, err := tokens.Get(ctx, raw[i])
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Active() != want {
+				t.Errorf("token %d active = %t, want %t", i, got.Active(), want)
+			}
+		}
+	})
+}
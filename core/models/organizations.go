@@ -0,0 +1,263 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+// Roles of a member within an organization, from most to least privileged.
+// They are separate from the platform roles in roles.go.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember}
+
+var (
+	ErrNotMember      = apperror.New(http.StatusForbidden, "not_member", "not a member of the organization")
+	ErrNoOrganization = apperror.New(http.StatusBadRequest, "no_organization", "no active organization")
+	ErrLastOwner      = apperror.New(http.StatusConflict, "last_owner", "the last owner cannot leave the organization")
+)
+
+// OrgRoleAtLeast reports whether role is role min or a more privileged one.
+func OrgRoleAtLeast(role, min string) bool {
+	i := slices.Index(OrgRoles, role)
+	return i >= 0 && i <= slices.Index(OrgRoles, min)
+}
+
+type Organization struct {
+	ID   int64  `db:"id" json:"id"`
+	Name string `db:"name" json:"name"`
+	Slug string `db:"slug" json:"slug"`
+	// Role is the role of the user the organization was listed for.
+	Role    string    `db:"role" json:"role,omitempty"`
+	Created time.Time `db:"created" json:"created"`
+	Updated time.Time `db:"updated" json:"updated"`
+}
+
+type Member struct {
+	OrganizationID int64     `db:"organization_id" json:"organization_id"`
+	UserID         int64     `db:"user_id" json:"user_id"`
+	Email          string    `db:"email" json:"email"`
+	Role           string    `db:"role" json:"role"`
+	Created        time.Time `db:"created" json:"created"`
+}
+
+// OrganizationRepository keeps organizations and their members. Every
+// member query takes the organization id, so the data of one organization
+// is never read or changed through another.
+type OrganizationRepository interface {
+	Create(ctx context.Context, organization Organization, ownerID int64) (Organization, error)
+	Get(ctx context.Context, id int64) (Organization, error)
+	ListUser(ctx context.Context, userID int64) ([]Organization, error)
+	Delete(ctx context.Context, id int64) error
+	Member(ctx context.Context, id, userID int64) (Member, error)
+	Members(ctx context.Context, id int64) ([]Member, error)
+	AddMember(ctx context.Context, id, userID int64, role string) (Member, error)
+	UpdateMember(ctx context.Context, id, userID int64, role string) (Member, error)
+	RemoveMember(ctx context.Context, id, userID int64) error
+}
+
+type SQLOrganizationRepository struct {
+	DB *sqlx.DB
+}
+
+func NewOrganizationRepository(db *sqlx.DB) *SQLOrganizationRepository {
+	return &SQLOrganizationRepository{DB: db}
+}
+
+const memberColumns = "m.organization_id, m.user_id, u.email, m.role, m.created"
+
+// Create inserts the organization with ownerID as its first owner.
+func (repo *SQLOrganizationRepository) Create(ctx context.Context, organization Organization, ownerID int64) (Organization, error) {
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	organization.Created = time.Now().UTC()
+	organization.Updated = organization.Created
+
+	query := "INSERT INTO organizations (name, slug, created, updated) VALUES (?, ?, ?, ?)"
+	organization.ID, err = insert(ctx, tx, query, organization.Name, organization.Slug, organization.Created, organization.Updated)
+	if err != nil {
+		return Organization{}, err
+	}
+
+	query = "INSERT INTO organization_members (organization_id, user_id, role, created) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), organization.ID, ownerID, OrgRoleOwner, organization.Created); err != nil {
+		return Organization{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+
+	organization.Role = OrgRoleOwner
+	return organization, nil
+}
+
+func (repo *SQLOrganizationRepository) Get(ctx context.Context, id int64) (Organization, error) {
+	var organization Organization
+	query := "SELECT id, name, slug, '' AS role, created, updated FROM organizations WHERE id = ?"
+	err := repo.DB.GetContext(ctx, &organization, repo.DB.Rebind(query), id)
+	return organization, dbError(err)
+}
+
+// ListUser returns the organizations the user is a member of, with their
+// role in each.
+func (repo *SQLOrganizationRepository) ListUser(ctx context.Context, userID int64) ([]Organization, error) {
+	organizations := []Organization{}
+	query := `SELECT o.id, o.name, o.slug, m.role, o.created, o.updated FROM organizations o
+		JOIN organization_members m ON m.organization_id = o.id
+		WHERE m.user_id = ? ORDER BY o.name`
+	err := repo.DB.SelectContext(ctx, &organizations, repo.DB.Rebind(query), userID)
+	return organizations, dbError(err)
+}
+
+// Delete removes the organization with its members, and clears it from the
+// sessions that have it active.
+func (repo *SQLOrganizationRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind("UPDATE sessions SET organization_id = NULL WHERE organization_id = ?"), id); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM organizations WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+// Member returns the membership of the user, or ErrNotMember.
+func (repo *SQLOrganizationRepository) Member(ctx context.Context, id, userID int64) (Member, error) {
+	var member Member
+	query := "SELECT " + memberColumns + " FROM organization_members m JOIN users u ON u.id = m.user_id WHERE m.organization_id = ? AND m.user_id = ? AND u.deleted_at IS NULL"
+	err := conn(ctx, repo.DB).GetContext(ctx, &member, repo.DB.Rebind(query), id, userID)
+	if err = dbError(err); errors.Is(err, ErrNotFound) {
+		return Member{}, ErrNotMember
+	}
+	return member, err
+}
+
+func (repo *SQLOrganizationRepository) Members(ctx context.Context, id int64) ([]Member, error) {
+	members := []Member{}
+	query := "SELECT " + memberColumns + " FROM organization_members m JOIN users u ON u.id = m.user_id WHERE m.organization_id = ? AND u.deleted_at IS NULL ORDER BY u.email"
+	err := repo.DB.SelectContext(ctx, &members, repo.DB.Rebind(query), id)
+	return members, dbError(err)
+}
+
+// AddMember makes the user a member with role. A user who already is a
+// member keeps their membership and gets the role.
+func (repo *SQLOrganizationRepository) AddMember(ctx context.Context, id, userID int64, role string) (Member, error) {
+	if _, err := repo.Member(ctx, id, userID); err == nil {
+		return repo.UpdateMember(ctx, id, userID, role)
+	} else if !errors.Is(err, ErrNotMember) {
+		return Member{}, err
+	}
+
+	query := "INSERT INTO organization_members (organization_id, user_id, role, created) VALUES (?, ?, ?, ?)"
+	if _, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), id, userID, role, time.Now().UTC()); err != nil {
+		return Member{}, err
+	}
+	return repo.Member(ctx, id, userID)
+}
+
+// UpdateMember changes the role of a member. The last owner cannot be
+// demoted.
+func (repo *SQLOrganizationRepository) UpdateMember(ctx context.Context, id, userID int64, role string) (Member, error) {
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return Member{}, err
+	}
+	defer tx.Rollback()
+
+	if role != OrgRoleOwner {
+		if err := checkLastOwner(ctx, tx.Tx, id, userID); err != nil {
+			return Member{}, err
+		}
+	}
+
+	query := "UPDATE organization_members SET role = ? WHERE organization_id = ? AND user_id = ?"
+	result, err := tx.ExecContext(ctx, tx.Rebind(query), role, id, userID)
+	if err != nil {
+		return Member{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return Member{}, err
+	} else if n == 0 {
+		return Member{}, ErrNotMember
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Member{}, err
+	}
+	return repo.Member(ctx, id, userID)
+}
+
+// RemoveMember ends the membership of the user and clears the organization
+// from their sessions. The last owner cannot be removed.
+func (repo *SQLOrganizationRepository) RemoveMember(ctx context.Context, id, userID int64) error {
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkLastOwner(ctx, tx, id, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM organization_members WHERE organization_id = ? AND user_id = ?"), id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotMember
+	}
+
+	query := "UPDATE sessions SET organization_id = NULL WHERE organization_id = ? AND user_id = ?"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), id, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkLastOwner fails with ErrLastOwner when the user is the only owner of
+// the organization. Deleted users do not count.
+func checkLastOwner(ctx context.Context, tx *sqlx.Tx, id, userID int64) error {
+	var owners []int64
+	query := `SELECT m.user_id FROM organization_members m JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = ? AND m.role = ? AND u.deleted_at IS NULL`
+	if err := tx.SelectContext(ctx, &owners, tx.Rebind(query), id, OrgRoleOwner); err != nil {
+		return err
+	}
+	if len(owners) == 1 && owners[0] == userID {
+		return ErrLastOwner
+	}
+	return nil
+}
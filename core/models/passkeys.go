@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	PurposePasskeyRegister = "passkey_register"
+	PurposePasskeyLogin    = "passkey_login"
+
+	PasskeyChallengeDuration = 5 * time.Minute
+)
+
+// Passkey is a WebAuthn credential registered to a user. A user can have
+// several, one per authenticator.
+type Passkey struct {
+	ID           int64      `db:"id" json:"id"`
+	CredentialID []byte     `db:"credential_id" json:"credential_id"`
+	UserID       int64      `db:"user_id" json:"user_id"`
+	PublicKey    []byte     `db:"public_key" json:"-"`
+	SignCount    int64      `db:"sign_count" json:"-"`
+	Transports   string     `db:"transports" json:"transports"`
+	AAGUID       string     `db:"aaguid" json:"aaguid"`
+	Attestation  string     `db:"attestation" json:"attestation"`
+	Name         string     `db:"name" json:"name"`
+	LastUsed     *time.Time `db:"last_used" json:"last_used"`
+	Created      time.Time  `db:"created" json:"created"`
+}
+
+type PasskeyRepository interface {
+	Create(ctx context.Context, passkey Passkey) (Passkey, error)
+	GetByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error)
+	ListUser(ctx context.Context, userID int64) ([]Passkey, error)
+	Use(ctx context.Context, id int64, signCount int64) error
+	Delete(ctx context.Context, userID, id int64) error
+}
+
+type SQLPasskeyRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPasskeyRepository(db *sqlx.DB) *SQLPasskeyRepository {
+	return &SQLPasskeyRepository{DB: db}
+}
+
+const passkeyColumns = "id, credential_id, user_id, public_key, sign_count, transports, aaguid, attestation, name, last_used, created"
+
+func (repo *SQLPasskeyRepository) Create(ctx context.Context, passkey Passkey) (Passkey, error) {
+	passkey.Created = time.Now().UTC()
+
+	query := "INSERT INTO passkeys (credential_id, user_id, public_key, sign_count, transports, aaguid, attestation, name, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	id, err := insert(ctx, repo.DB, query, passkey.CredentialID, passkey.UserID, passkey.PublicKey, passkey.SignCount, passkey.Transports, passkey.AAGUID, passkey.Attestation, passkey.Name, passkey.Created)
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	passkey.ID = id
+	return passkey, nil
+}
+
+func (repo *SQLPasskeyRepository) GetByCredentialID(ctx context.Context, credentialID []byte) (Passkey, error) {
+	var passkey Passkey
+	query := fmt.Sprintf("SELECT %s FROM passkeys WHERE credential_id = ?", passkeyColumns)
+	err := repo.DB.GetContext(ctx, &passkey, repo.DB.Rebind(query), credentialID)
+	return passkey, dbError(err)
+}
+
+func (repo *SQLPasskeyRepository) ListUser(ctx context.Context, userID int64) ([]Passkey, error) {
+	passkeys := []Passkey{}
+	query := fmt.Sprintf("SELECT %s FROM passkeys WHERE user_id = ? ORDER BY id", passkeyColumns)
+	err := repo.DB.SelectContext(ctx, &passkeys, repo.DB.Rebind(query), userID)
+	return passkeys, dbError(err)
+}
+
+// Use records a successful login with the passkey and its new signature
+// counter.
+func (repo *SQLPasskeyRepository) Use(ctx context.Context, id int64, signCount int64) error {
+	query := "UPDATE passkeys SET sign_count = ?, last_used = ? WHERE id = ?"
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), signCount, time.Now().UTC(), id)
+	return err
+}
+
+// Delete removes a passkey of the user. Passkeys of other users are not
+// found.
+func (repo *SQLPasskeyRepository) Delete(ctx context.Context, userID, id int64) error {
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM passkeys WHERE id = ? AND user_id = ?"), id, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+const RefreshTokenDuration = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = apperror.New(http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
+	ErrRefreshTokenReused  = apperror.New(http.StatusUnauthorized, "refresh_token_reused", "refresh token reuse detected")
+)
+
+// RefreshToken is one link of a rotation chain. Every token issued from the
+// same login shares a family, so reuse of a rotated token revokes them all.
+type RefreshToken struct {
+	ID      int64     `db:"id" json:"id"`
+	Token   string    `db:"token" json:"-"`
+	Family  string    `db:"family" json:"family"`
+	UserID  int64     `db:"user_id" json:"user_id"`
+	Revoked bool      `db:"revoked" json:"revoked"`
+	Expires time.Time `db:"expires" json:"expires"`
+	Created time.Time `db:"created" json:"created"`
+	Updated time.Time `db:"updated" json:"updated"`
+}
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, userID int64, family string) (RefreshToken, string, error)
+	Rotate(ctx context.Context, token string) (RefreshToken, string, error)
+	Revoke(ctx context.Context, token string) error
+	RevokeFamily(ctx context.Context, family string) error
+	RevokeUser(ctx context.Context, userID int64) error
+}
+
+type SQLRefreshTokenRepository struct {
+	DB *sqlx.DB
+}
+
+func NewRefreshTokenRepository(db *sqlx.DB) *SQLRefreshTokenRepository {
+	return &SQLRefreshTokenRepository{DB: db}
+}
+
+// Create issues a refresh token in family, starting a new family when it is
+// empty.
+func (repo *SQLRefreshTokenRepository) Create(ctx context.Context, userID int64, family string) (RefreshToken, string, error) {
+	return createRefreshToken(ctx, repo.DB, userID, family)
+}
+
+func createRefreshToken(ctx context.Context, db sqlx.ExtContext, userID int64, family string) (RefreshToken, string, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+
+	if family == "" {
+		if family, _, err = NewToken(); err != nil {
+			return RefreshToken{}, "", err
+		}
+	}
+
+	now := time.Now().UTC()
+	refresh := RefreshToken{
+		Token:   hash,
+		Family:  family,
+		UserID:  userID,
+		Expires: now.Add(RefreshTokenDuration),
+		Created: now,
+		Updated: now,
+	}
+
+	query := "INSERT INTO refresh_tokens (token, family, user_id, revoked, expires, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	refresh.ID, err = insert(ctx, db, query, refresh.Token, refresh.Family, refresh.UserID, false, refresh.Expires, refresh.Created, refresh.Updated)
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+
+	return refresh, token, nil
+}
+
+// Rotate revokes the presented token and issues its successor. Presenting a
+// token that was already rotated revokes the whole family.
+func (repo *SQLRefreshTokenRepository) Rotate(ctx context.Context, token string) (RefreshToken, string, error) {
+	var current RefreshToken
+	query := "SELECT id, token, family, user_id, revoked, expires, created, updated FROM refresh_tokens WHERE token = ?"
+	err := repo.DB.GetContext(ctx, &current, repo.DB.Rebind(query), HashToken(token))
+	if err := dbError(err); errors.Is(err, ErrNotFound) {
+		return RefreshToken{}, "", ErrInvalidRefreshToken
+	} else if err != nil {
+		return RefreshToken{}, "", err
+	}
+
+	if current.Revoked {
+		if err := repo.RevokeFamily(ctx, current.Family); err != nil {
+			return RefreshToken{}, "", err
+		}
+		return RefreshToken{}, "", ErrRefreshTokenReused
+	}
+
+	if time.Now().After(current.Expires) {
+		return RefreshToken{}, "", ErrInvalidRefreshToken
+	}
+
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+	defer tx.Rollback()
+
+	// Guard on revoked so that two concurrent rotations cannot both succeed.
+	result, err := tx.ExecContext(ctx, tx.Rebind("UPDATE refresh_tokens SET revoked = ?, updated = ? WHERE id = ? AND revoked = ?"), true, time.Now().UTC(), current.ID, false)
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		return RefreshToken{}, "", ErrRefreshTokenReused
+	}
+
+	next, raw, err := createRefreshToken(ctx, tx, current.UserID, current.Family)
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+
+	return next, raw, tx.Commit()
+}
+
+func (repo *SQLRefreshTokenRepository) Revoke(ctx context.Context, token string) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("UPDATE refresh_tokens SET revoked = ?, updated = ? WHERE token = ?"), true, time.Now().UTC(), HashToken(token))
+	return err
+}
+
+func (repo *SQLRefreshTokenRepository) RevokeFamily(ctx context.Context, family string) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("UPDATE refresh_tokens SET revoked = ?, updated = ? WHERE family = ?"), true, time.Now().UTC(), family)
+	return err
+}
+
+// RevokeUser revokes every refresh token belonging to the user.
+func (repo *SQLRefreshTokenRepository) RevokeUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind("UPDATE refresh_tokens SET revoked = ?, updated = ? WHERE user_id = ?"), true, time.Now().UTC(), userID)
+	return err
+}
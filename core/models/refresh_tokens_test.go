@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		user, err := NewUserRepository(db).Create(ctx, User{Email: testEmail("refresh"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create user: %v", err)
+		}
+		repo := NewRefreshTokenRepository(db)
+
+		first, raw, err := repo.Create(ctx, user.ID, "")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		other, otherRaw, err := repo.Create(ctx, user.ID, "")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if first.Family == "" || first.Family == other.Family {
+			t.Fatalf("families %q and %q, want two new families", first.Family, other.Family)
+		}
+
+		next, nextRaw, err := repo.Rotate(ctx, raw)
+		if err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+		if next.Family != first.Family || nextRaw == raw {
+			t.Errorf("Rotate = %+v, want a new token of family %s", next, first.Family)
+		}
+
+		// Presenting the rotated token again revokes its successor too.
+		if _, _, err := repo.Rotate(ctx, raw); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Errorf("Rotate of a rotated token = %v, want ErrRefreshTokenReused", err)
+		}
+		if _, _, err := repo.Rotate(ctx, nextRaw); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Errorf("Rotate of its successor = %v, want ErrRefreshTokenReused", err)
+		}
+
+		// Other families are left alone.
+		if _, _, err := repo.Rotate(ctx, otherRaw); err != nil {
+			t.Errorf("Rotate of another family: %v", err)
+		}
+		if _, _, err := repo.Rotate(ctx, "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("Rotate of an unknown token = %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+}
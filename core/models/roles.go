@@ -0,0 +1,480 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+// Built-in roles. admin holds every permission, including ones added later,
+// without being granted them. They replace the old staff and admin flags.
+const (
+	RoleAdmin = "admin"
+	RoleStaff = "staff"
+)
+
+// Built-in permissions, checked by the views.
+const (
+	PermUsersRead     = "users.read"
+	PermUsersUpdate   = "users.update"
+	PermUsersDelete   = "users.delete"
+	PermUsersRoles    = "users.roles"
+	PermUsersInvite   = "users.invite"
+	PermUsersExport   = "users.export"
+	PermRolesManage   = "roles.manage"
+	PermOAuthClients  = "oauth.clients"
+	PermAPIKeysManage = "api_keys.manage"
+	PermAuditRead     = "audit.read"
+)
+
+var (
+	ErrBuiltinRole       = apperror.New(http.StatusConflict, "builtin_role", "built-in roles cannot be changed")
+	ErrBuiltinPermission = apperror.New(http.StatusConflict, "builtin_permission", "built-in permissions cannot be deleted")
+	ErrLastAdmin         = apperror.New(http.StatusConflict, "last_admin", "the last admin cannot lose the admin role")
+)
+
+type Role struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Builtin     bool      `db:"builtin" json:"builtin"`
+	Permissions []string  `db:"-" json:"permissions"`
+	Created     time.Time `db:"created" json:"created"`
+	Updated     time.Time `db:"updated" json:"updated"`
+}
+
+type Permission struct {
+	ID          int64     `db:"id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Builtin     bool      `db:"builtin" json:"builtin"`
+	Created     time.Time `db:"created" json:"created"`
+}
+
+type RoleRepository interface {
+	Create(ctx context.Context, role Role) (Role, error)
+	Get(ctx context.Context, id int64) (Role, error)
+	List(ctx context.Context) ([]Role, error)
+	Update(ctx context.Context, id int64, description string, permissions []string) (Role, error)
+	Delete(ctx context.Context, id int64) error
+	Permissions(ctx context.Context, names []string) ([]string, error)
+	UserRoles(ctx context.Context, userID int64) ([]Role, error)
+	SetUserRoles(ctx context.Context, userID int64, names []string) error
+	GrantUserRole(ctx context.Context, userID int64, name string) error
+	UserPermissions(ctx context.Context, userID int64) ([]string, error)
+	HasPermission(ctx context.Context, userID int64, permission string) (bool, error)
+}
+
+type SQLRoleRepository struct {
+	DB *sqlx.DB
+}
+
+func NewRoleRepository(db *sqlx.DB) *SQLRoleRepository {
+	return &SQLRoleRepository{DB: db}
+}
+
+const roleColumns = "id, name, description, builtin, created, updated"
+
+// hasRole is an SQL condition on a row of users that is true when the user
+// has the role. role is always one of the built-in role constants.
+func hasRole(role string) string {
+	return fmt.Sprintf("EXISTS (SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id AND r.name = '%s')", role)
+}
+
+// Create inserts the role with the named permissions, which must exist.
+func (repo *SQLRoleRepository) Create(ctx context.Context, role Role) (Role, error) {
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return Role{}, err
+	}
+	defer tx.Rollback()
+
+	role.Builtin = false
+	role.Created = time.Now().UTC()
+	role.Updated = role.Created
+
+	query := "INSERT INTO roles (name, description, builtin, created, updated) VALUES (?, ?, ?, ?, ?)"
+	role.ID, err = insert(ctx, tx, query, role.Name, role.Description, role.Builtin, role.Created, role.Updated)
+	if err != nil {
+		return Role{}, err
+	}
+
+	if err := setRolePermissions(ctx, tx.Tx, role.ID, role.Permissions); err != nil {
+		return Role{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Role{}, err
+	}
+	return repo.Get(ctx, role.ID)
+}
+
+func (repo *SQLRoleRepository) Get(ctx context.Context, id int64) (Role, error) {
+	var role Role
+	query := fmt.Sprintf("SELECT %s FROM roles WHERE id = ?", roleColumns)
+	if err := conn(ctx, repo.DB).GetContext(ctx, &role, repo.DB.Rebind(query), id); err != nil {
+		return Role{}, dbError(err)
+	}
+
+	roles := []Role{role}
+	if err := repo.loadPermissions(ctx, roles); err != nil {
+		return Role{}, err
+	}
+	return roles[0], nil
+}
+
+func (repo *SQLRoleRepository) List(ctx context.Context) ([]Role, error) {
+	roles := []Role{}
+	query := fmt.Sprintf("SELECT %s FROM roles ORDER BY name", roleColumns)
+	if err := conn(ctx, repo.DB).SelectContext(ctx, &roles, repo.DB.Rebind(query)); err != nil {
+		return nil, dbError(err)
+	}
+
+	if err := repo.loadPermissions(ctx, roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// loadPermissions fills in the permissions of roles. The admin role lists
+// every permission.
+func (repo *SQLRoleRepository) loadPermissions(ctx context.Context, roles []Role) error {
+	var rows []struct {
+		RoleID int64  `db:"role_id"`
+		Name   string `db:"name"`
+	}
+	query := "SELECT rp.role_id, p.name FROM role_permissions rp JOIN permissions p ON p.id = rp.permission_id ORDER BY p.name"
+	if err := conn(ctx, repo.DB).SelectContext(ctx, &rows, repo.DB.Rebind(query)); err != nil {
+		return err
+	}
+
+	var all []string
+	if err := conn(ctx, repo.DB).SelectContext(ctx, &all, repo.DB.Rebind("SELECT name FROM permissions ORDER BY name")); err != nil {
+		return err
+	}
+
+	for i := range roles {
+		roles[i].Permissions = []string{}
+		if roles[i].Name == RoleAdmin {
+			roles[i].Permissions = all
+			continue
+		}
+		for _, row := range rows {
+			if row.RoleID == roles[i].ID {
+				roles[i].Permissions = append(roles[i].Permissions, row.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Update sets the description and permissions of the role. The permissions
+// of admin are fixed.
+func (repo *SQLRoleRepository) Update(ctx context.Context, id int64, description string, permissions []string) (Role, error) {
+	role, err := repo.Get(ctx, id)
+	if err != nil {
+		return Role{}, err
+	}
+	if role.Name == RoleAdmin {
+		return Role{}, ErrBuiltinRole
+	}
+
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return Role{}, err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE roles SET description = ?, updated = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), description, time.Now().UTC(), id); err != nil {
+		return Role{}, err
+	}
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM role_permissions WHERE role_id = ?"), id); err != nil {
+		return Role{}, err
+	}
+	if err := setRolePermissions(ctx, tx.Tx, id, permissions); err != nil {
+		return Role{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Role{}, err
+	}
+	return repo.Get(ctx, id)
+}
+
+func setRolePermissions(ctx context.Context, tx *sqlx.Tx, roleID int64, names []string) error {
+	var done []string
+	for _, name := range names {
+		if slices.Contains(done, name) {
+			continue
+		}
+		done = append(done, name)
+
+		var permissionID int64
+		if err := tx.GetContext(ctx, &permissionID, tx.Rebind("SELECT id FROM permissions WHERE name = ?"), name); errors.Is(dbError(err), ErrNotFound) {
+			return apperror.ErrValidation.WithField("permissions", fmt.Sprintf("unknown permission %q", name))
+		} else if err != nil {
+			return err
+		}
+
+		query := "INSERT INTO role_permissions (role_id, permission_id) VALUES (?, ?)"
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), roleID, permissionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes a role that is not built in. Its users lose it.
+func (repo *SQLRoleRepository) Delete(ctx context.Context, id int64) error {
+	role, err := repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+
+	_, err = conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind("DELETE FROM roles WHERE id = ?"), id)
+	return err
+}
+
+// Permissions returns the permissions of the named roles, or every
+// permission when they include admin.
+func (repo *SQLRoleRepository) Permissions(ctx context.Context, names []string) ([]string, error) {
+	permissions := []string{}
+	if len(names) == 0 {
+		return permissions, nil
+	}
+
+	if slices.Contains(names, RoleAdmin) {
+		err := conn(ctx, repo.DB).SelectContext(ctx, &permissions, repo.DB.Rebind("SELECT name FROM permissions ORDER BY name"))
+		return permissions, err
+	}
+
+	query, args, err := sqlx.In("SELECT DISTINCT p.name FROM roles r JOIN role_permissions rp ON rp.role_id = r.id JOIN permissions p ON p.id = rp.permission_id WHERE r.name IN (?) ORDER BY p.name", names)
+	if err != nil {
+		return nil, err
+	}
+	err = conn(ctx, repo.DB).SelectContext(ctx, &permissions, repo.DB.Rebind(query), args...)
+	return permissions, err
+}
+
+func (repo *SQLRoleRepository) UserRoles(ctx context.Context, userID int64) ([]Role, error) {
+	roles := []Role{}
+	query := "SELECT r.id, r.name, r.description, r.builtin, r.created, r.updated FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = ? ORDER BY r.name"
+	if err := conn(ctx, repo.DB).SelectContext(ctx, &roles, repo.DB.Rebind(query), userID); err != nil {
+		return nil, err
+	}
+
+	if err := repo.loadPermissions(ctx, roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles of the user with the named ones, which
+// must exist. It refuses to take admin from the last admin.
+func (repo *SQLRoleRepository) SetUserRoles(ctx context.Context, userID int64, names []string) error {
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := touchUser(ctx, tx.Tx, userID); err != nil {
+		return err
+	}
+
+	if !slices.Contains(names, RoleAdmin) {
+		if err := revokeRole(ctx, tx.Tx, userID, RoleAdmin); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM user_roles WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := grantRole(ctx, tx.Tx, userID, name); errors.Is(err, ErrNotFound) {
+			return apperror.ErrValidation.WithField("roles", fmt.Sprintf("unknown role %q", name))
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GrantUserRole adds the named role to the roles of the user.
+func (repo *SQLRoleRepository) GrantUserRole(ctx context.Context, userID int64, name string) error {
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := touchUser(ctx, tx.Tx, userID); err != nil {
+		return err
+	}
+	if err := grantRole(ctx, tx.Tx, userID, name); errors.Is(err, ErrNotFound) {
+		return apperror.ErrValidation.WithField("role", fmt.Sprintf("unknown role %q", name))
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repo *SQLRoleRepository) UserPermissions(ctx context.Context, userID int64) ([]string, error) {
+	roles, err := repo.UserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []string{}
+	for _, role := range roles {
+		for _, p := range role.Permissions {
+			if !slices.Contains(permissions, p) {
+				permissions = append(permissions, p)
+			}
+		}
+	}
+	slices.Sort(permissions)
+	return permissions, nil
+}
+
+// HasPermission reports whether one of the user's roles grants permission.
+func (repo *SQLRoleRepository) HasPermission(ctx context.Context, userID int64, permission string) (bool, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ? AND (r.name = ? OR p.name = ?)`
+	err := conn(ctx, repo.DB).GetContext(ctx, &n, repo.DB.Rebind(query), userID, RoleAdmin, permission)
+	return n > 0, err
+}
+
+// grantRole gives the named role to the user, if they do not have it yet.
+func grantRole(ctx context.Context, tx *sqlx.Tx, userID int64, name string) error {
+	var roleID int64
+	if err := tx.GetContext(ctx, &roleID, tx.Rebind("SELECT id FROM roles WHERE name = ?"), name); err != nil {
+		return dbError(err)
+	}
+
+	var n int
+	if err := tx.GetContext(ctx, &n, tx.Rebind("SELECT COUNT(*) FROM user_roles WHERE user_id = ? AND role_id = ?"), userID, roleID); err != nil || n > 0 {
+		return err
+	}
+
+	query := "INSERT INTO user_roles (user_id, role_id, created) VALUES (?, ?, ?)"
+	_, err := tx.ExecContext(ctx, tx.Rebind(query), userID, roleID, time.Now().UTC())
+	return err
+}
+
+// checkLastAdmin fails with ErrLastAdmin when the user is the only admin
+// left. Deleted users do not count.
+func checkLastAdmin(ctx context.Context, tx *sqlx.Tx, userID int64) error {
+	var admins []int64
+	query := `SELECT ur.user_id FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		JOIN users u ON u.id = ur.user_id
+		WHERE r.name = ? AND u.deleted_at IS NULL`
+	if err := tx.SelectContext(ctx, &admins, tx.Rebind(query), RoleAdmin); err != nil {
+		return err
+	}
+	if len(admins) == 1 && admins[0] == userID {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// revokeRole takes the named role from the user. Taking admin from the last
+// admin fails with ErrLastAdmin.
+func revokeRole(ctx context.Context, tx *sqlx.Tx, userID int64, name string) error {
+	if name == RoleAdmin {
+		if err := checkLastAdmin(ctx, tx, userID); err != nil {
+			return err
+		}
+	}
+
+	query := "DELETE FROM user_roles WHERE user_id = ? AND role_id IN (SELECT id FROM roles WHERE name = ?)"
+	_, err := tx.ExecContext(ctx, tx.Rebind(query), userID, name)
+	return err
+}
+
+// touchUser bumps the user's updated time, failing with ErrNotFound for an
+// unknown or deleted user.
+func touchUser(ctx context.Context, tx *sqlx.Tx, userID int64) error {
+	result, err := tx.ExecContext(ctx, tx.Rebind("UPDATE users SET updated = ? WHERE id = ? AND deleted_at IS NULL"), time.Now().UTC(), userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type PermissionRepository interface {
+	Create(ctx context.Context, permission Permission) (Permission, error)
+	List(ctx context.Context) ([]Permission, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type SQLPermissionRepository struct {
+	DB *sqlx.DB
+}
+
+func NewPermissionRepository(db *sqlx.DB) *SQLPermissionRepository {
+	return &SQLPermissionRepository{DB: db}
+}
+
+// Create adds a permission for other applications to check, for example
+// through the roles of a user.
+func (repo *SQLPermissionRepository) Create(ctx context.Context, permission Permission) (Permission, error) {
+	permission.Builtin = false
+	permission.Created = time.Now().UTC()
+
+	query := "INSERT INTO permissions (name, description, builtin, created) VALUES (?, ?, ?, ?)"
+	id, err := insert(ctx, repo.DB, query, permission.Name, permission.Description, permission.Builtin, permission.Created)
+	if err != nil {
+		return Permission{}, err
+	}
+
+	permission.ID = id
+	return permission, nil
+}
+
+func (repo *SQLPermissionRepository) List(ctx context.Context) ([]Permission, error) {
+	permissions := []Permission{}
+	query := "SELECT id, name, description, builtin, created FROM permissions ORDER BY name"
+	err := repo.DB.SelectContext(ctx, &permissions, repo.DB.Rebind(query))
+	return permissions, dbError(err)
+}
+
+// Delete removes a permission that is not built in, and takes it from every
+// role.
+func (repo *SQLPermissionRepository) Delete(ctx context.Context, id int64) error {
+	var builtin bool
+	if err := repo.DB.GetContext(ctx, &builtin, repo.DB.Rebind("SELECT builtin FROM permissions WHERE id = ?"), id); err != nil {
+		return dbError(err)
+	}
+	if builtin {
+		return ErrBuiltinPermission
+	}
+
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM permissions WHERE id = ?"), id)
+	return err
+}
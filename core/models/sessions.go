@@ -0,0 +1,201 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/hasher"
+	"github.com/jmoiron/sqlx"
+)
+
+const SessionDuration = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidCredentials = apperror.New(http.StatusUnauthorized, "invalid_credentials", "invalid email or password")
+	ErrInactiveUser       = apperror.New(http.StatusForbidden, "inactive_user", "account is inactive")
+	ErrUnverifiedEmail    = apperror.New(http.StatusForbidden, "unverified_email", "email address is not verified")
+)
+
+// dummyHash is compared against when the email is unknown so that a failed
+// login takes the same time whether or not the account exists. It is made
+// on first use, once hasher.Default has been configured.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := hasher.Hash("potential-go")
+	return hash
+})
+
+type Session struct {
+	ID     int64  `db:"id" json:"id"`
+	Token  string `db:"token" json:"-"`
+	UserID int64  `db:"user_id" json:"user_id"`
+	// OrganizationID is the active organization, chosen with
+	// SetOrganization. It is cleared when the user leaves the organization
+	// or it is deleted.
+	OrganizationID *int64    `db:"organization_id" json:"organization_id"`
+	Expires        time.Time `db:"expires" json:"expires"`
+	Created        time.Time `db:"created" json:"created"`
+	Updated        time.Time `db:"updated" json:"updated"`
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, userID int64) (Session, string, error)
+	Get(ctx context.Context, token string) (Session, error)
+	Renew(ctx context.Context, session Session) (Session, bool, error)
+	SetOrganization(ctx context.Context, id int64, organizationID *int64) error
+	Delete(ctx context.Context, token string) error
+	DeleteUser(ctx context.Context, userID int64) error
+	DeleteExpired(ctx context.Context) error
+}
+
+type SQLSessionRepository struct {
+	DB *sqlx.DB
+}
+
+func NewSessionRepository(db *sqlx.DB) *SQLSessionRepository {
+	return &SQLSessionRepository{DB: db}
+}
+
+// NewToken returns a random url-safe token and the hash that is stored in
+// the database in its place.
+func NewToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns the active user with the email if password matches
+// the stored hash, rehashing it with hasher.Default when it is outdated.
+func Authenticate(ctx context.Context, users UserRepository, email, password string) (User, error) {
+	user, err := users.GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return User{}, err
+	}
+
+	hash := user.Password
+	if err != nil {
+		hash = dummyHash()
+	}
+
+	ok, rehash, _ := hasher.Verify(hash, password)
+	if !ok || err != nil {
+		return User{}, ErrInvalidCredentials
+	}
+
+	// Upgrade hashes made with an older algorithm or parameters while the
+	// plaintext is at hand. A failure leaves the old hash, which still works.
+	if rehash {
+		ctx := withAuditAction(ctx, "user.rehash_password")
+		if updated, err := users.UpdatePassword(ctx, user.ID, password); err == nil {
+			user = updated
+		}
+	}
+
+	if !user.Verified {
+		return User{}, ErrUnverifiedEmail
+	}
+
+	if !user.Active {
+		return User{}, ErrInactiveUser
+	}
+
+	return user, nil
+}
+
+func (repo *SQLSessionRepository) Create(ctx context.Context, userID int64) (Session, string, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	now := time.Now().UTC()
+	session := Session{
+		Token:   hash,
+		UserID:  userID,
+		Expires: now.Add(SessionDuration),
+		Created: now,
+		Updated: now,
+	}
+
+	query := "INSERT INTO sessions (token, user_id, expires, created, updated) VALUES (?, ?, ?, ?, ?)"
+	session.ID, err = insert(ctx, repo.DB, query, session.Token, session.UserID, session.Expires, session.Created, session.Updated)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	return session, token, nil
+}
+
+// Get returns the unexpired session for the raw cookie token.
+func (repo *SQLSessionRepository) Get(ctx context.Context, token string) (Session, error) {
+	var session Session
+	query := "SELECT id, token, user_id, organization_id, expires, created, updated FROM sessions WHERE token = ? AND expires > ?"
+	err := repo.DB.GetContext(ctx, &session, repo.DB.Rebind(query), HashToken(token), time.Now().UTC())
+	return session, dbError(err)
+}
+
+// Renew slides the expiry forward once less than half of the session
+// lifetime remains. It reports whether the session was extended.
+func (repo *SQLSessionRepository) Renew(ctx context.Context, session Session) (Session, bool, error) {
+	if time.Until(session.Expires) > SessionDuration/2 {
+		return session, false, nil
+	}
+
+	now := time.Now().UTC()
+	query := "UPDATE sessions SET expires = ?, updated = ? WHERE id = ?"
+	if _, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), now.Add(SessionDuration), now, session.ID); err != nil {
+		return session, false, err
+	}
+
+	session.Expires, session.Updated = now.Add(SessionDuration), now
+	return session, true, nil
+}
+
+// SetOrganization makes organizationID the active organization of the
+// session, or clears it when nil.
+func (repo *SQLSessionRepository) SetOrganization(ctx context.Context, id int64, organizationID *int64) error {
+	query := "UPDATE sessions SET organization_id = ?, updated = ? WHERE id = ?"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), organizationID, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (repo *SQLSessionRepository) Delete(ctx context.Context, token string) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM sessions WHERE token = ?"), HashToken(token))
+	return err
+}
+
+// DeleteUser revokes every session belonging to the user.
+func (repo *SQLSessionRepository) DeleteUser(ctx context.Context, userID int64) error {
+	_, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind("DELETE FROM sessions WHERE user_id = ?"), userID)
+	return err
+}
+
+func (repo *SQLSessionRepository) DeleteExpired(ctx context.Context) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM sessions WHERE expires <= ?"), time.Now().UTC())
+	return err
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/totp"
+	"github.com/jmoiron/sqlx"
+)
+
+const RecoveryCodeCount = 10
+
+var (
+	ErrOTPRequired     = apperror.New(http.StatusUnauthorized, "otp_required", "a two-factor code is required")
+	ErrInvalidOTP      = apperror.New(http.StatusUnauthorized, "invalid_otp", "invalid two-factor code")
+	ErrTOTPEnabled     = apperror.New(http.StatusConflict, "totp_enabled", "two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled = apperror.New(http.StatusBadRequest, "totp_not_enrolled", "two-factor authentication is not being set up")
+)
+
+// TOTP is the authenticator app secret of a user. It only guards logins once
+// Confirmed is set, which happens after the user has entered a first code.
+type TOTP struct {
+	UserID      int64      `db:"user_id" json:"user_id"`
+	Secret      string     `db:"secret" json:"-"`
+	Confirmed   *time.Time `db:"confirmed" json:"confirmed"`
+	LastCounter int64      `db:"last_counter" json:"-"`
+	Created     time.Time  `db:"created" json:"created"`
+}
+
+type TOTPRepository interface {
+	Get(ctx context.Context, userID int64) (TOTP, error)
+	Enroll(ctx context.Context, userID int64) (TOTP, error)
+	Confirm(ctx context.Context, userID int64, counter int64) error
+	UseCounter(ctx context.Context, userID int64, counter int64) error
+	Delete(ctx context.Context, userID int64) error
+}
+
+type SQLTOTPRepository struct {
+	DB *sqlx.DB
+}
+
+func NewTOTPRepository(db *sqlx.DB) *SQLTOTPRepository {
+	return &SQLTOTPRepository{DB: db}
+}
+
+func (repo *SQLTOTPRepository) Get(ctx context.Context, userID int64) (TOTP, error) {
+	var t TOTP
+	query := "SELECT user_id, secret, confirmed, last_counter, created FROM totp WHERE user_id = ?"
+	err := repo.DB.GetContext(ctx, &t, repo.DB.Rebind(query), userID)
+	return t, dbError(err)
+}
+
+// Enroll stores a new unconfirmed secret for the user, replacing an earlier
+// unconfirmed one. It fails with ErrTOTPEnabled once 2FA is confirmed.
+func (repo *SQLTOTPRepository) Enroll(ctx context.Context, userID int64) (TOTP, error) {
+	existing, err := repo.Get(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return TOTP{}, err
+	}
+	if err == nil && existing.Confirmed != nil {
+		return TOTP{}, ErrTOTPEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return TOTP{}, err
+	}
+	t := TOTP{UserID: userID, Secret: secret, Created: time.Now().UTC()}
+
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return TOTP{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM totp WHERE user_id = ? AND confirmed IS NULL"), userID); err != nil {
+		return TOTP{}, err
+	}
+	query := "INSERT INTO totp (user_id, secret, last_counter, created) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, tx.Rebind(query), t.UserID, t.Secret, t.LastCounter, t.Created); err != nil {
+		return TOTP{}, err
+	}
+
+	return t, tx.Commit()
+}
+
+// Confirm turns on 2FA for the user, recording the step of the code used.
+func (repo *SQLTOTPRepository) Confirm(ctx context.Context, userID int64, counter int64) error {
+	query := "UPDATE totp SET confirmed = ?, last_counter = ? WHERE user_id = ? AND confirmed IS NULL"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), time.Now().UTC(), counter, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTOTPNotEnrolled
+	}
+	return nil
+}
+
+// UseCounter records that the code for counter was used. A code for the same
+// or an earlier step is rejected with ErrInvalidOTP, so it cannot be
+// replayed.
+func (repo *SQLTOTPRepository) UseCounter(ctx context.Context, userID int64, counter int64) error {
+	query := "UPDATE totp SET last_counter = ? WHERE user_id = ? AND last_counter < ?"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), counter, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+func (repo *SQLTOTPRepository) Delete(ctx context.Context, userID int64) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM totp WHERE user_id = ?"), userID)
+	return err
+}
+
+// RecoveryCodeRepository stores the single-use codes that stand in for a
+// TOTP code when the authenticator is lost. Only their hashes are stored.
+type RecoveryCodeRepository interface {
+	Replace(ctx context.Context, userID int64) ([]string, error)
+	Use(ctx context.Context, userID int64, code string) error
+	DeleteUser(ctx context.Context, userID int64) error
+}
+
+type SQLRecoveryCodeRepository struct {
+	DB *sqlx.DB
+}
+
+func NewRecoveryCodeRepository(db *sqlx.DB) *SQLRecoveryCodeRepository {
+	return &SQLRecoveryCodeRepository{DB: db}
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// normalizeRecoveryCode makes codes match regardless of case, spaces and the
+// dash they are displayed with.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// Replace discards the user's recovery codes and returns RecoveryCodeCount
+// new ones, formatted xxxxx-xxxxx.
+func (repo *SQLRecoveryCodeRepository) Replace(ctx context.Context, userID int64) ([]string, error) {
+	tx, err := repo.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+
+		query := "INSERT INTO recovery_codes (code, user_id, created) VALUES (?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, tx.Rebind(query), HashToken(normalizeRecoveryCode(code)), userID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// Use marks an unused recovery code of the user as used, or fails with
+// ErrInvalidOTP.
+func (repo *SQLRecoveryCodeRepository) Use(ctx context.Context, userID int64, code string) error {
+	query := "UPDATE recovery_codes SET used = ? WHERE user_id = ? AND code = ? AND used IS NULL"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), time.Now().UTC(), userID, HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidOTP
+	}
+	return nil
+}
+
+func (repo *SQLRecoveryCodeRepository) DeleteUser(ctx context.Context, userID int64) error {
+	_, err := repo.DB.ExecContext(ctx, repo.DB.Rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID)
+	return err
+}
+
+// VerifySecondFactor checks the second factor of a user who passed the
+// password check. Users without confirmed TOTP pass with any code. Others
+// need a current TOTP code or an unused recovery code.
+func VerifySecondFactor(ctx context.Context, totps TOTPRepository, codes RecoveryCodeRepository, userID int64, code string) error {
+	t, err := totps.Get(ctx, userID)
+	if errors.Is(err, ErrNotFound) || (err == nil && t.Confirmed == nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if code == "" {
+		return ErrOTPRequired
+	}
+
+	if counter, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		return totps.UseCounter(ctx, userID, counter)
+	}
+
+	return codes.Use(ctx, userID, code)
+}
@@ -1,328 +1,611 @@
 package models
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/hasher"
 	"github.com/jmoiron/sqlx"
-	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	ErrNotFound       = apperror.ErrNotFound
+	ErrConflict       = apperror.ErrConflict
+	ErrDuplicateEmail = apperror.New(http.StatusConflict, "duplicate_email", "email already exists").WithField("email", "is already registered")
+)
+
+// User is an account. Staff and Admin report membership of the built-in
+// roles of the same name; other roles are read with RoleRepository.
+// DeletedAt is set once the user is deleted, until they are restored or
+// purged. PendingEmail is the address the user is changing to, until it is
+// confirmed.
 type User struct {
-	ID       int64     `db:"id" json:"id"`
-	Email    string    `db:"email" json:"email"`
-	Password string    `db:"password" json:"password"`
-	Active   bool      `db:"active" json:"active"`
-	Staff    bool      `db:"staff" json:"staff"`
-	Admin    bool      `db:"admin" json:"admin"`
-	Created  time.Time `db:"created" json:"created"`
-	Updated  time.Time `db:"updated" json:"updated"`
+	ID        int64      `db:"id" json:"id"`
+	Email     string     `db:"email" json:"email"`
+	Password  string     `db:"password" json:"-"`
+	Active    bool       `db:"active" json:"active"`
+	Staff     bool       `db:"staff" json:"staff"`
+	Admin     bool       `db:"admin" json:"admin"`
+	Verified  bool       `db:"verified" json:"verified"`
+	Created   time.Time  `db:"created" json:"created"`
+	Updated   time.Time  `db:"updated" json:"updated"`
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+
+	PendingEmail *string `db:"pending_email" json:"pending_email,omitempty"`
 }
 
-func (u *User) UserCreate(db *sqlx.DB, confirm_password string, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
-	}
+// UserRepository is the data access for users. It knows nothing about HTTP,
+// so it can be used from views, commands and background jobs alike.
+//
+// Delete only marks a user as deleted. Deleted users are left out of every
+// read, and of List unless it asks for them, until Restore brings them back
+// or Purge removes them for good.
+type UserRepository interface {
+	Create(ctx context.Context, user User) (User, error)
+	Get(ctx context.Context, id int64) (User, error)
+	GetDeleted(ctx context.Context, id int64) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	List(ctx context.Context, opts UserListOptions) (UserPage, error)
+	UpdateEmail(ctx context.Context, id int64, email string) (User, error)
+	ConfirmEmail(ctx context.Context, id int64) (User, error)
+	UpdatePassword(ctx context.Context, id int64, password string) (User, error)
+	UpdateActive(ctx context.Context, id int64, active bool) (User, error)
+	UpdateStaff(ctx context.Context, id int64, staff bool) (User, error)
+	UpdateAdmin(ctx context.Context, id int64, admin bool) (User, error)
+	Verify(ctx context.Context, id int64) (User, error)
+	Delete(ctx context.Context, id int64) error
+	Restore(ctx context.Context, id int64) (User, error)
+	Purge(ctx context.Context, deletedBefore time.Time) ([]int64, error)
+}
+
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// UserListOptions filters and orders a user listing. Pages are walked with
+// Cursor, the NextCursor of the previous page, unless Page is set, in which
+// case plain offset paging is used.
+type UserListOptions struct {
+	Limit  int
+	Cursor string
+	Page   int
+
+	Active        *bool
+	Staff         *bool
+	Admin         *bool
+	Email         string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+
+	Sort  string
+	Desc  bool
+	Total bool
+
+	IncludeDeleted bool
+}
 
-	if u.Password != confirm_password {
-		w.WriteHeader(http.StatusBadRequest)
-		return errors.New("password is invalid")
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Page       int    `json:"page,omitempty"`
+	NextPage   int    `json:"next_page,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// userSortColumns whitelists the fields a listing can be ordered by.
+var userSortColumns = map[string]string{
+	"":        "id",
+	"id":      "id",
+	"email":   "email",
+	"created": "created",
+	"updated": "updated",
+}
+
+func (opts UserListOptions) filters() ([]string, []any) {
+	var where []string
+	var args []any
+
+	add := func(clause string, value any) {
+		where = append(where, clause)
+		args = append(args, value)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+	if !opts.IncludeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if opts.Active != nil {
+		add("active = ?", *opts.Active)
+	}
+	if opts.Staff != nil {
+		where = append(where, roleFilter(RoleStaff, *opts.Staff))
+	}
+	if opts.Admin != nil {
+		where = append(where, roleFilter(RoleAdmin, *opts.Admin))
+	}
+	if opts.Email != "" {
+		// ! rather than \ as the escape character, which MySQL treats
+		// specially inside string literals.
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(opts.Email)
+		add("email LIKE ? ESCAPE '!'", "%"+escaped+"%")
+	}
+	if opts.CreatedAfter != nil {
+		add("created >= ?", opts.CreatedAfter.UTC())
+	}
+	if opts.CreatedBefore != nil {
+		add("created < ?", opts.CreatedBefore.UTC())
+	}
+	if opts.UpdatedAfter != nil {
+		add("updated >= ?", opts.UpdatedAfter.UTC())
+	}
+	if opts.UpdatedBefore != nil {
+		add("updated < ?", opts.UpdatedBefore.UTC())
 	}
 
-	var user User
-	query := "INSERT INTO users (email, password, active, staff, admin, created) VALUES (?, ?, ?, ?, ?, ?) RETURNING id, email, created, updated;"
-	row := db.QueryRow(query, u.Email, string(hash), u.Active, u.Staff, u.Admin, u.Created)
-	err = row.Scan(&user.ID, &user.Email, &user.Created, &user.Updated)
+	return where, args
+}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+func roleFilter(role string, has bool) string {
+	if has {
+		return hasRole(role)
 	}
+	return "NOT " + hasRole(role)
+}
 
-	var data struct {
-		User `json:"user"`
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
 	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
 
-	data.User = user
+// listCursor is the position after the last row of a page: the value of the
+// sort field and the id that breaks ties.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int64  `json:"id"`
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+	value any
 }
 
-func (u *User) UserRead(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+func encodeCursor(user User, sort string) string {
+	cursor := listCursor{Sort: sort, ID: user.ID}
+	switch sort {
+	case "email":
+		cursor.Value = user.Email
+	case "created":
+		cursor.Value = user.Created.Format(time.RFC3339Nano)
+	case "updated":
+		cursor.Value = user.Updated.Format(time.RFC3339Nano)
 	}
 
-	query := "SELECT id, email, active, admin, isstaff, created, updated FROM users WHERE id = ?;"
-	row := db.QueryRow(query, u.ID)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+func decodeCursor(s, sort string) (listCursor, error) {
+	invalid := apperror.ErrValidation.WithField("cursor", "invalid cursor")
+
+	var cursor listCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &cursor) != nil || cursor.Sort != sort {
+		return cursor, invalid
 	}
 
-	var data struct {
-		User `json:"user"`
+	cursor.value = cursor.Value
+	if sort == "created" || sort == "updated" {
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		if err != nil {
+			return cursor, invalid
+		}
+		cursor.value = t.UTC()
 	}
 
-	data.User = *u
+	return cursor, nil
+}
+
+type SQLUserRepository struct {
+	DB *sqlx.DB
+}
+
+func NewUserRepository(db *sqlx.DB) *SQLUserRepository {
+	return &SQLUserRepository{DB: db}
+}
+
+var userColumns = "id, email, password, active, verified, created, updated, deleted_at, pending_email, " +
+	hasRole(RoleStaff) + " AS staff, " + hasRole(RoleAdmin) + " AS admin"
+
+// HashPassword returns the hash stored in the password column for password.
+func HashPassword(password string) (string, error) {
+	return hasher.Hash(password)
+}
+
+// CheckPassword reports whether password matches the stored hash.
+func CheckPassword(hash, password string) bool {
+	ok, _, _ := hasher.Verify(hash, password)
+	return ok
+}
+
+// IsPasswordHash reports whether a stored password is a hash rather than the
+// plaintext that UpdatePassword used to write.
+func IsPasswordHash(stored string) bool {
+	return hasher.Identify(stored) != nil
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+// emailError is dbError for statements on users whose only unique column
+// is email, so that a conflict means the email is taken.
+func emailError(err error) error {
+	err = dbError(err)
+	if errors.Is(err, ErrConflict) {
+		return ErrDuplicateEmail.Wrap(err)
+	}
+	return err
 }
 
-func (u *User) UserReadByEmail(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+// Create hashes user.Password and inserts the user, with the staff and
+// admin roles when Staff and Admin are set.
+func (repo *SQLUserRepository) Create(ctx context.Context, user User) (User, error) {
+	hash, err := HashPassword(user.Password)
+	if err != nil {
+		return User{}, err
 	}
 
-	query := "SELECT id, email, active, admin, isstaff, created, updated FROM users WHERE email = ?;"
-	row := db.QueryRow(query, u.Email)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
+	user.Password = hash
+	user.Created = time.Now().UTC()
+	user.Updated = user.Created
 
+	tx, err := beginTx(ctx, repo.DB)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+		return User{}, err
 	}
+	defer tx.Rollback()
 
-	var data struct {
-		User `json:"user"`
+	query := "INSERT INTO users (email, password, active, verified, created, updated) VALUES (?, ?, ?, ?, ?, ?)"
+	user.ID, err = insert(ctx, tx, query, user.Email, user.Password, user.Active, user.Verified, user.Created, user.Updated)
+	if err != nil {
+		return User{}, emailError(err)
 	}
 
-	data.User = *u
+	for role, has := range map[string]bool{RoleStaff: user.Staff, RoleAdmin: user.Admin} {
+		if has {
+			if err := grantRole(ctx, tx.Tx, user.ID, role); err != nil {
+				return User{}, err
+			}
+		}
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return user, nil
 }
 
-func (u *User) UserList(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
-	}
+func (repo *SQLUserRepository) Get(ctx context.Context, id int64) (User, error) {
+	var user User
+	query := fmt.Sprintf("SELECT %s FROM users WHERE id = ? AND deleted_at IS NULL", userColumns)
+	err := conn(ctx, repo.DB).GetContext(ctx, &user, repo.DB.Rebind(query), id)
+	return user, dbError(err)
+}
 
-	query := "SELECT id, email, isactive, isadmin, isstaff, created_at, updated_at FROM users ORDER BY id ASC;"
-	rows, err := db.Query(query)
+// GetDeleted returns a user that has been deleted but not purged yet.
+func (repo *SQLUserRepository) GetDeleted(ctx context.Context, id int64) (User, error) {
+	var user User
+	query := fmt.Sprintf("SELECT %s FROM users WHERE id = ? AND deleted_at IS NOT NULL", userColumns)
+	err := conn(ctx, repo.DB).GetContext(ctx, &user, repo.DB.Rebind(query), id)
+	return user, dbError(err)
+}
 
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+// GetByEmail returns the user with email. The email of a deleted user stays
+// taken until it is purged, so that the user can be restored.
+func (repo *SQLUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	query := fmt.Sprintf("SELECT %s FROM users WHERE email = ? AND deleted_at IS NULL", userColumns)
+	err := conn(ctx, repo.DB).GetContext(ctx, &user, repo.DB.Rebind(query), email)
+	return user, dbError(err)
+}
+
+func (repo *SQLUserRepository) List(ctx context.Context, opts UserListOptions) (UserPage, error) {
+	page := UserPage{Users: []User{}, Page: opts.Page}
+
+	sort, ok := userSortColumns[opts.Sort]
+	if !ok {
+		return page, apperror.ErrValidation.WithField("sort", "unknown sort field")
+	}
+	if opts.Limit <= 0 || opts.Limit > MaxListLimit {
+		opts.Limit = DefaultListLimit
 	}
 
-	defer rows.Close()
-	var users []User
-
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.Active,
-			&user.Admin,
-			&user.Staff,
-			&user.Created,
-			&user.Updated,
-		); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return err
+	where, args := opts.filters()
+
+	if opts.Total {
+		var total int64
+		query := fmt.Sprintf("SELECT COUNT(*) FROM users%s", whereClause(where))
+		if err := conn(ctx, repo.DB).GetContext(ctx, &total, repo.DB.Rebind(query), args...); err != nil {
+			return page, dbError(err)
 		}
-		users = append(users, user)
+		page.Total = &total
 	}
-	if err := rows.Close(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+
+	cmp, order := ">", "ASC"
+	if opts.Desc {
+		cmp, order = "<", "DESC"
 	}
-	if err := rows.Err(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor, opts.Sort)
+		if err != nil {
+			return page, err
+		}
+
+		if sort == "id" {
+			where = append(where, fmt.Sprintf("id %s ?", cmp))
+			args = append(args, cursor.ID)
+		} else {
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sort, cmp))
+			args = append(args, cursor.value, cursor.value, cursor.ID)
+		}
 	}
 
-	var data struct {
-		Users []User `json:"users"`
+	query := fmt.Sprintf("SELECT %s FROM users%s ORDER BY ", userColumns, whereClause(where))
+	if sort != "id" {
+		query += fmt.Sprintf("%s %s, ", sort, order)
 	}
+	query += fmt.Sprintf("id %s LIMIT ?", order)
+	args = append(args, opts.Limit+1)
 
-	data.Users = users
+	if opts.Page > 0 {
+		query += " OFFSET ?"
+		args = append(args, (opts.Page-1)*opts.Limit)
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
-}
+	if err := conn(ctx, repo.DB).SelectContext(ctx, &page.Users, repo.DB.Rebind(query), args...); err != nil {
+		return page, dbError(err)
+	}
 
-func (u *User) UserUpdateEmail(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+	if len(page.Users) > opts.Limit {
+		page.Users = page.Users[:opts.Limit]
+		if opts.Page > 0 {
+			page.NextPage = opts.Page + 1
+		} else {
+			page.NextCursor = encodeCursor(page.Users[opts.Limit-1], opts.Sort)
+		}
 	}
 
-	u.Updated = time.Now()
-	query := "UPDATE users SET email = ?, updated = ? WHERE id = ? RETURNING id, email, active, admin, isstaff, created, updated"
-	row := db.QueryRow(query, u.Email, u.Updated.String(), u.ID)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
+	return page, nil
+}
 
+// update sets a single column on the user and returns the updated row.
+// column is always one of the constants passed by the methods below.
+func (repo *SQLUserRepository) update(ctx context.Context, id int64, column string, value any) (User, error) {
+	query := fmt.Sprintf("UPDATE users SET %s = ?, updated = ? WHERE id = ? AND deleted_at IS NULL", column)
+	result, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), value, time.Now().UTC(), id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+		return User{}, dbError(err)
 	}
 
-	var data struct {
-		User `json:"user"`
+	if n, err := result.RowsAffected(); err != nil {
+		return User{}, err
+	} else if n == 0 {
+		return User{}, ErrNotFound
 	}
 
-	data.User = *u
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+	return repo.Get(ctx, id)
 }
 
-func (u *User) UserUpdatePassword(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+// UpdateEmail sets the email the user is changing to. It only replaces the
+// current one once ConfirmEmail is called, after the new address has been
+// proven. Setting the current email cancels a pending change.
+func (repo *SQLUserRepository) UpdateEmail(ctx context.Context, id int64, email string) (User, error) {
+	user, err := repo.Get(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if email == user.Email {
+		return repo.update(ctx, id, "pending_email", nil)
 	}
 
-	u.Updated = time.Now()
-	query := "UPDATE users SET password = ?, updated = ? WHERE id = ? RETURNING id, email, active, admin, isstaff, created, updated;"
-	row := db.QueryRow(query, u.Password, u.Updated.String(), u.ID)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
+	// The email is checked again on confirmation, this only spares mailing
+	// an address that cannot be used.
+	var taken int
+	if err := conn(ctx, repo.DB).GetContext(ctx, &taken, repo.DB.Rebind("SELECT COUNT(*) FROM users WHERE email = ?"), email); err != nil {
+		return User{}, err
+	}
+	if taken != 0 {
+		return User{}, ErrDuplicateEmail
+	}
 
+	return repo.update(ctx, id, "pending_email", email)
+}
+
+// ConfirmEmail replaces the user's email with the pending one, which the
+// user has proven to control, so it is verified too.
+func (repo *SQLUserRepository) ConfirmEmail(ctx context.Context, id int64) (User, error) {
+	query := "UPDATE users SET email = pending_email, pending_email = NULL, verified = ?, updated = ? WHERE id = ? AND pending_email IS NOT NULL AND deleted_at IS NULL"
+	result, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), true, time.Now().UTC(), id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+		return User{}, emailError(err)
 	}
 
-	var data struct {
-		User `json:"user"`
+	if n, err := result.RowsAffected(); err != nil {
+		return User{}, err
+	} else if n == 0 {
+		return User{}, ErrNotFound
 	}
 
-	data.User = *u
-
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+	return repo.Get(ctx, id)
 }
 
-func (u *User) UserUpdateActive(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+// UpdatePassword hashes password and stores it.
+func (repo *SQLUserRepository) UpdatePassword(ctx context.Context, id int64, password string) (User, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return User{}, err
 	}
+	return repo.update(ctx, id, "password", hash)
+}
 
-	u.Updated = time.Now()
-	query := "UPDATE users SET active = ?, updated = ? WHERE id = ? RETURNING id, email, active, admin, isstaff, created, updated;"
-	row := db.QueryRow(query, u.Active, u.Updated.String(), u.ID)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
-
+// RehashPassword hashes a password stored in plaintext, deleted users
+// included, as they can be restored. It does nothing once the stored
+// password has changed.
+func (repo *SQLUserRepository) RehashPassword(ctx context.Context, id int64, plaintext string) error {
+	hash, err := HashPassword(plaintext)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		return err
 	}
+	query := "UPDATE users SET password = ?, updated = ? WHERE id = ? AND password = ?"
+	_, err = repo.DB.ExecContext(ctx, repo.DB.Rebind(query), hash, time.Now().UTC(), id, plaintext)
+	return err
+}
 
-	var data struct {
-		User `json:"user"`
-	}
+func (repo *SQLUserRepository) UpdateActive(ctx context.Context, id int64, active bool) (User, error) {
+	return repo.update(ctx, id, "active", active)
+}
 
-	data.User = *u
+// UpdateStaff grants or revokes the built-in staff role.
+func (repo *SQLUserRepository) UpdateStaff(ctx context.Context, id int64, staff bool) (User, error) {
+	return repo.updateRole(ctx, id, RoleStaff, staff)
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+// UpdateAdmin grants or revokes the built-in admin role. The last admin
+// cannot lose it.
+func (repo *SQLUserRepository) UpdateAdmin(ctx context.Context, id int64, admin bool) (User, error) {
+	return repo.updateRole(ctx, id, RoleAdmin, admin)
 }
 
-func (u *User) UserUpdateStaff(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+func (repo *SQLUserRepository) updateRole(ctx context.Context, id int64, role string, has bool) (User, error) {
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return User{}, err
 	}
+	defer tx.Rollback()
 
-	u.Updated = time.Now()
-	query := "UPDATE users SET staff = ?, updated = ? WHERE id = ? RETURNING id, email, active, admin, isstaff, created, updated;"
-	row := db.QueryRow(query, u.Staff, u.Updated.String(), u.ID)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
+	if err := touchUser(ctx, tx.Tx, id); err != nil {
+		return User{}, err
+	}
 
+	if has {
+		err = grantRole(ctx, tx.Tx, id, role)
+	} else {
+		err = revokeRole(ctx, tx.Tx, id, role)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+		return User{}, err
 	}
 
-	var data struct {
-		User `json:"user"`
+	if err := tx.Commit(); err != nil {
+		return User{}, err
 	}
+	return repo.Get(ctx, id)
+}
 
-	data.User = *u
+// Verify marks the user's email as verified. It leaves active alone, so a
+// deactivated account stays off.
+func (repo *SQLUserRepository) Verify(ctx context.Context, id int64) (User, error) {
+	query := "UPDATE users SET verified = ?, updated = ? WHERE id = ? AND deleted_at IS NULL"
+	result, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), true, time.Now().UTC(), id)
+	if err != nil {
+		return User{}, dbError(err)
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+	if n, err := result.RowsAffected(); err != nil {
+		return User{}, err
+	} else if n == 0 {
+		return User{}, ErrNotFound
+	}
+
+	return repo.Get(ctx, id)
 }
 
-func (u *User) UserUpdateAdmin(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPut {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
+// Delete marks the user as deleted. The last admin cannot be deleted.
+func (repo *SQLUserRepository) Delete(ctx context.Context, id int64) error {
+	tx, err := beginTx(ctx, repo.DB)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	u.Updated = time.Now()
-	query := "UPDATE users SET admin = ?, updated = ? WHERE id = ? RETURNING id, email, active, admin, isstaff, created, updated;"
-	row := db.QueryRow(query, u.Active, u.Updated.String(), u.ID)
-	err := row.Scan(&u.ID, &u.Email, &u.Active, &u.Admin, &u.Staff, &u.Created, &u.Updated)
+	if err := checkLastAdmin(ctx, tx.Tx, id); err != nil {
+		return err
+	}
 
+	now := time.Now().UTC()
+	query := "UPDATE users SET deleted_at = ?, updated = ? WHERE id = ? AND deleted_at IS NULL"
+	result, err := tx.ExecContext(ctx, tx.Rebind(query), now, now, id)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		return dbError(err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
 		return err
+	} else if n == 0 {
+		return ErrNotFound
 	}
 
-	var data struct {
-		User `json:"user"`
+	return tx.Commit()
+}
+
+// Restore undoes Delete for a user that has not been purged yet.
+func (repo *SQLUserRepository) Restore(ctx context.Context, id int64) (User, error) {
+	query := "UPDATE users SET deleted_at = NULL, updated = ? WHERE id = ? AND deleted_at IS NOT NULL"
+	result, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), time.Now().UTC(), id)
+	if err != nil {
+		return User{}, dbError(err)
 	}
 
-	data.User = *u
+	if n, err := result.RowsAffected(); err != nil {
+		return User{}, err
+	} else if n == 0 {
+		return User{}, ErrNotFound
+	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
-	return nil
+	return repo.Get(ctx, id)
 }
 
-func (u *User) UserDelete(db *sqlx.DB, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return errors.New("method not allowed")
-	}
+// Purge removes the users deleted before deletedBefore for good, with
+// everything that belongs to them, and returns their ids. What is kept as a
+// record of their account, their audit events and accepted invitations,
+// is anonymized, and a user.purge event listing the anonymized events is
+// recorded in the same transaction.
+func (repo *SQLUserRepository) Purge(ctx context.Context, deletedBefore time.Time) ([]int64, error) {
+	ids := []int64{}
+	err := auditTx(ctx, repo.DB, func(ctx context.Context) error {
+		tx := conn(ctx, repo.DB)
+
+		var users []User
+		query := "SELECT id, email FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?"
+		if err := tx.SelectContext(ctx, &users, tx.Rebind(query), deletedBefore.UTC()); err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if err := anonymizeAuditEvents(ctx, tx, user.ID); err != nil {
+				return err
+			}
 
-	query := "DELETE FROM users WHERE id = ?;"
-	row := db.QueryRow(query, u.ID)
-	err := row.Scan()
+			query := "UPDATE invitations SET email = ? WHERE email = ? AND accepted IS NOT NULL"
+			if _, err := tx.ExecContext(ctx, tx.Rebind(query), fmt.Sprintf("purged-user-%d", user.ID), user.Email); err != nil {
+				return err
+			}
 
+			if _, err := tx.ExecContext(ctx, tx.Rebind("DELETE FROM users WHERE id = ?"), user.ID); err != nil {
+				return err
+			}
+			ids = append(ids, user.ID)
+		}
+		return nil
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return err
+		return nil, err
 	}
-
-	w.WriteHeader(http.StatusOK)
-	return nil
+	return ids, nil
 }
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestUserRepository(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewUserRepository(db)
+		email := testEmail("user")
+
+		user, err := repo.Create(ctx, User{Email: email, Password: "password1", Active: true, Staff: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if user.ID <= 0 {
+			t.Fatalf("Create returned id %d", user.ID)
+		}
+		if user.Password == "password1" || !CheckPassword(user.Password, "password1") {
+			t.Error("Create did not hash the password")
+		}
+
+		got, err := repo.Get(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got.Email != email || !got.Active || !got.Staff || got.Admin {
+			t.Errorf("Get = %+v", got)
+		}
+
+		if got, err := repo.GetByEmail(ctx, email); err != nil || got.ID != user.ID {
+			t.Errorf("GetByEmail = %d, %v, want %d", got.ID, err, user.ID)
+		}
+
+		if _, err := repo.Create(ctx, User{Email: email, Password: "password1"}); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Create duplicate = %v, want ErrDuplicateEmail", err)
+		}
+
+		if _, err := repo.Get(ctx, user.ID+1000000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get missing = %v, want ErrNotFound", err)
+		}
+
+		if got, err := repo.UpdatePassword(ctx, user.ID, "password2"); err != nil || !CheckPassword(got.Password, "password2") {
+			t.Errorf("UpdatePassword = %v", err)
+		}
+		if got, err := repo.UpdateAdmin(ctx, user.ID, true); err != nil || !got.Admin {
+			t.Errorf("UpdateAdmin = %+v, %v", got, err)
+		}
+	})
+}
+
+func TestUserRepositoryUpdateEmail(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewUserRepository(db)
+		email := testEmail("email")
+		taken := testEmail("taken")
+
+		user, err := repo.Create(ctx, User{Email: email, Password: "password1", Active: true, Verified: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := repo.Create(ctx, User{Email: taken, Password: "password1"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		if _, err := repo.UpdateEmail(ctx, user.ID, taken); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("UpdateEmail to a taken email = %v, want ErrDuplicateEmail", err)
+		}
+
+		changed := testEmail("changed")
+		got, err := repo.UpdateEmail(ctx, user.ID, changed)
+		if err != nil {
+			t.Fatalf("UpdateEmail: %v", err)
+		}
+		if got.Email != email || got.PendingEmail == nil || *got.PendingEmail != changed {
+			t.Errorf("UpdateEmail = %q pending %v, want %q pending %q", got.Email, got.PendingEmail, email, changed)
+		}
+
+		got, err = repo.ConfirmEmail(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("ConfirmEmail: %v", err)
+		}
+		if got.Email != changed || got.PendingEmail != nil || !got.Verified {
+			t.Errorf("ConfirmEmail = %q pending %v verified %t", got.Email, got.PendingEmail, got.Verified)
+		}
+		if _, err := repo.ConfirmEmail(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("ConfirmEmail without a pending email = %v, want ErrNotFound", err)
+		}
+
+		// An email taken between the change and its confirmation.
+		raced := testEmail("raced")
+		if _, err := repo.UpdateEmail(ctx, user.ID, raced); err != nil {
+			t.Fatalf("UpdateEmail: %v", err)
+		}
+		if _, err := repo.Create(ctx, User{Email: raced, Password: "password1"}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := repo.ConfirmEmail(ctx, user.ID); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("ConfirmEmail to a taken email = %v, want ErrDuplicateEmail", err)
+		}
+
+		if _, err := repo.UpdateEmail(ctx, user.ID, testEmail("cancelled")); err != nil {
+			t.Fatalf("UpdateEmail: %v", err)
+		}
+		if got, err := repo.UpdateEmail(ctx, user.ID, changed); err != nil || got.PendingEmail != nil {
+			t.Errorf("UpdateEmail to the current email = pending %v, %v, want no pending email", got.PendingEmail, err)
+		}
+	})
+}
+
+func TestUserRepositoryList(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewUserRepository(db)
+		prefix := testEmail("list")
+
+		var ids []int64
+		for _, name := range []string{"a", "b", "c"} {
+			user, err := repo.Create(ctx, User{Email: name + "-" + prefix, Password: "password1"})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			ids = append(ids, user.ID)
+		}
+
+		opts := UserListOptions{Email: prefix, Sort: "email", Limit: 2}
+		first, err := repo.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(first.Users) != 2 || first.NextCursor == "" {
+			t.Fatalf("first page = %d users, cursor %q", len(first.Users), first.NextCursor)
+		}
+
+		opts.Cursor = first.NextCursor
+		second, err := repo.List(ctx, opts)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(second.Users) != 1 || second.Users[0].ID != ids[2] || second.NextCursor != "" {
+			t.Fatalf("second page = %+v", second)
+		}
+
+		if err := repo.Delete(ctx, ids[0]); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		page, err := repo.List(ctx, UserListOptions{Email: prefix, Sort: "id"})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(page.Users) != 2 {
+			t.Errorf("List after Delete = %d users, want 2", len(page.Users))
+		}
+		if _, err := repo.Restore(ctx, ids[0]); err != nil {
+			t.Errorf("Restore: %v", err)
+		}
+	})
+}
+
+func TestUserRepositoryDeleteLastAdmin(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewUserRepository(db)
+
+		var admins int
+		query := "SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE r.name = ?"
+		if err := db.GetContext(ctx, &admins, db.Rebind(query), RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+		if admins > 0 {
+			t.Skip("the database already has admins")
+		}
+
+		admin, err := repo.Create(ctx, User{Email: testEmail("admin"), Password: "password1", Admin: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := repo.Delete(ctx, admin.ID); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("Delete of the last admin = %v, want ErrLastAdmin", err)
+		}
+
+		other, err := repo.Create(ctx, User{Email: testEmail("admin"), Password: "password1", Admin: true})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := repo.Delete(ctx, admin.ID); err != nil {
+			t.Errorf("Delete of an admin with another left = %v", err)
+		}
+		if err := repo.Delete(ctx, other.ID); !errors.Is(err, ErrLastAdmin) {
+			t.Errorf("Delete of the last admin left = %v, want ErrLastAdmin", err)
+		}
+	})
+}
+
+func TestUserRepositoryVerify(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewUserRepository(db)
+
+		for _, active := range []bool{true, false} {
+			user, err := repo.Create(ctx, User{Email: testEmail("verify"), Password: "password1", Active: active})
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			got, err := repo.Verify(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !got.Verified || got.Active != active {
+				t.Errorf("Verify of a user with active %t = verified %t active %t", active, got.Verified, got.Active)
+			}
+		}
+	})
+}
+
+func TestUserRepositoryRehashPassword(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *sqlx.DB) {
+		ctx := context.Background()
+		repo := NewUserRepository(db)
+
+		user, err := repo.Create(ctx, User{Email: testEmail("rehash"), Password: "password1"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := db.ExecContext(ctx, db.Rebind("UPDATE users SET password = ? WHERE id = ?"), "plaintext1", user.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+
+		if err := repo.RehashPassword(ctx, user.ID, "plaintext1"); err != nil {
+			t.Fatalf("RehashPassword: %v", err)
+		}
+		got, err := repo.GetDeleted(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("GetDeleted: %v", err)
+		}
+		if !IsPasswordHash(got.Password) || !CheckPassword(got.Password, "plaintext1") {
+			t.Errorf("password of a deleted user after RehashPassword = %q", got.Password)
+		}
+
+		// A password that changed since it was read is left alone.
+		if err := repo.RehashPassword(ctx, user.ID, "plaintext1"); err != nil {
+			t.Fatalf("RehashPassword: %v", err)
+		}
+		if again, err := repo.GetDeleted(ctx, user.ID); err != nil || again.Password != got.Password {
+			t.Errorf("second RehashPassword changed the password: %v", err)
+		}
+	})
+}
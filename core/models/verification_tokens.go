@@ -0,0 +1,119 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeChangeEmail   = "change_email"
+	PurposeOIDCOTP       = "oidc_otp"
+
+	VerifyEmailTokenDuration   = 24 * time.Hour
+	ResetPasswordTokenDuration = time.Hour
+	ChangeEmailTokenDuration   = 24 * time.Hour
+	OIDCOTPTokenDuration       = 5 * time.Minute
+)
+
+var ErrInvalidVerificationToken = apperror.New(http.StatusBadRequest, "invalid_token", "invalid or expired token")
+
+// VerificationToken is a single-use token mailed to a user to prove control
+// of an email address, for verification, a password reset or a change of
+// email. Sign-ins through a provider that still need a second factor are
+// also held by one. Only its hash is stored.
+type VerificationToken struct {
+	ID      int64      `db:"id" json:"id"`
+	Token   string     `db:"token" json:"-"`
+	Purpose string     `db:"purpose" json:"purpose"`
+	UserID  int64      `db:"user_id" json:"user_id"`
+	Expires time.Time  `db:"expires" json:"expires"`
+	Used    *time.Time `db:"used" json:"used"`
+	Created time.Time  `db:"created" json:"created"`
+}
+
+type VerificationTokenRepository interface {
+	Create(ctx context.Context, userID int64, purpose string, ttl time.Duration) (VerificationToken, string, error)
+	Get(ctx context.Context, token, purpose string) (VerificationToken, error)
+	Consume(ctx context.Context, token, purpose string) (VerificationToken, error)
+	DeleteUser(ctx context.Context, userID int64, purpose string) error
+}
+
+type SQLVerificationTokenRepository struct {
+	DB *sqlx.DB
+}
+
+func NewVerificationTokenRepository(db *sqlx.DB) *SQLVerificationTokenRepository {
+	return &SQLVerificationTokenRepository{DB: db}
+}
+
+func (repo *SQLVerificationTokenRepository) Create(ctx context.Context, userID int64, purpose string, ttl time.Duration) (VerificationToken, string, error) {
+	token, hash, err := NewToken()
+	if err != nil {
+		return VerificationToken{}, "", err
+	}
+
+	now := time.Now().UTC()
+	verification := VerificationToken{
+		Token:   hash,
+		Purpose: purpose,
+		UserID:  userID,
+		Expires: now.Add(ttl),
+		Created: now,
+	}
+
+	query := "INSERT INTO verification_tokens (token, purpose, user_id, expires, created) VALUES (?, ?, ?, ?, ?)"
+	verification.ID, err = insert(ctx, repo.DB, query, verification.Token, verification.Purpose, verification.UserID, verification.Expires, verification.Created)
+	if err != nil {
+		return VerificationToken{}, "", err
+	}
+
+	return verification, token, nil
+}
+
+// Get returns an unused, unexpired token for purpose without using it. Any
+// other token yields ErrInvalidVerificationToken.
+func (repo *SQLVerificationTokenRepository) Get(ctx context.Context, token, purpose string) (VerificationToken, error) {
+	var verification VerificationToken
+	query := "SELECT id, token, purpose, user_id, expires, used, created FROM verification_tokens WHERE token = ? AND purpose = ? AND used IS NULL AND expires > ?"
+	err := repo.DB.GetContext(ctx, &verification, repo.DB.Rebind(query), HashToken(token), purpose, time.Now().UTC())
+	if err = dbError(err); errors.Is(err, ErrNotFound) {
+		return VerificationToken{}, ErrInvalidVerificationToken
+	}
+	return verification, err
+}
+
+// Consume marks an unused, unexpired token for purpose as used and returns
+// it. Any other token yields ErrInvalidVerificationToken.
+func (repo *SQLVerificationTokenRepository) Consume(ctx context.Context, token, purpose string) (VerificationToken, error) {
+	now := time.Now().UTC()
+	query := "UPDATE verification_tokens SET used = ? WHERE token = ? AND purpose = ? AND used IS NULL AND expires > ?"
+	result, err := repo.DB.ExecContext(ctx, repo.DB.Rebind(query), now, HashToken(token), purpose, now)
+	if err != nil {
+		return VerificationToken{}, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return VerificationToken{}, err
+	} else if n == 0 {
+		return VerificationToken{}, ErrInvalidVerificationToken
+	}
+
+	var verification VerificationToken
+	query = "SELECT id, token, purpose, user_id, expires, used, created FROM verification_tokens WHERE token = ?"
+	err = repo.DB.GetContext(ctx, &verification, repo.DB.Rebind(query), HashToken(token))
+	return verification, dbError(err)
+}
+
+// DeleteUser invalidates every outstanding token of the user for purpose.
+func (repo *SQLVerificationTokenRepository) DeleteUser(ctx context.Context, userID int64, purpose string) error {
+	query := "DELETE FROM verification_tokens WHERE user_id = ? AND purpose = ?"
+	_, err := conn(ctx, repo.DB).ExecContext(ctx, repo.DB.Rebind(query), userID, purpose)
+	return err
+}
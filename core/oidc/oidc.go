@@ -0,0 +1,450 @@
+// Package oidc is an OpenID Connect relying party for the authorization
+// code flow with PKCE. Providers are configured from the environment, or a
+// JSON file, and their endpoints are discovered from the issuer unless set
+// explicitly, which also allows plain OAuth2 providers such as GitHub that
+// only have a userinfo endpoint.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+)
+
+var (
+	ErrUnknownProvider = apperror.New(http.StatusNotFound, "unknown_provider", "unknown identity provider")
+	ErrProvider        = apperror.New(http.StatusBadGateway, "provider_error", "identity provider request failed")
+	ErrInvalidIDToken  = apperror.New(http.StatusBadRequest, "invalid_id_token", "invalid id token")
+)
+
+// Providers are the configured identity providers by name. It is set from
+// the environment at startup.
+var Providers = map[string]*Provider{}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+type Provider struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+
+	// Endpoints, discovered from Issuer when empty.
+	AuthURL     string `json:"auth_url"`
+	TokenURL    string `json:"token_url"`
+	UserinfoURL string `json:"userinfo_url"`
+	JWKSURL     string `json:"jwks_url"`
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+// FromEnv loads the providers listed in OIDC_PROVIDERS (comma separated
+// names). For each NAME it reads OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _SCOPES (space separated) and the optional _AUTH_URL,
+// _TOKEN_URL, _USERINFO_URL and _JWKS_URL. OIDC_PROVIDERS_FILE may name a
+// JSON file holding an array of providers instead.
+func FromEnv() (map[string]*Provider, error) {
+	var providers []*Provider
+
+	if path := os.Getenv("OIDC_PROVIDERS_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &providers); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv(fmt.Sprintf("OIDC_%s_%s", strings.ToUpper(name), key))
+		}
+		providers = append(providers, &Provider{
+			Name:         name,
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			Scopes:       strings.Fields(env("SCOPES")),
+			AuthURL:      env("AUTH_URL"),
+			TokenURL:     env("TOKEN_URL"),
+			UserinfoURL:  env("USERINFO_URL"),
+			JWKSURL:      env("JWKS_URL"),
+		})
+	}
+
+	byName := map[string]*Provider{}
+	for _, p := range providers {
+		if p.Name == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("oidc provider %q needs a name and a client id", p.Name)
+		}
+		if p.Issuer == "" && (p.AuthURL == "" || p.TokenURL == "") {
+			return nil, fmt.Errorf("oidc provider %q needs an issuer or auth and token urls", p.Name)
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "email", "profile"}
+		}
+		byName[p.Name] = p
+	}
+	return byName, nil
+}
+
+// discover fills the endpoints that were not configured from the issuer's
+// discovery document. It is retried on every call until it succeeds.
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.AuthURL != "" && p.TokenURL != "" && (p.JWKSURL != "" || p.Issuer == "") {
+		return nil
+	}
+
+	var doc struct {
+		Issuer      string `json:"issuer"`
+		AuthURL     string `json:"authorization_endpoint"`
+		TokenURL    string `json:"token_endpoint"`
+		UserinfoURL string `json:"userinfo_endpoint"`
+		JWKSURL     string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return err
+	}
+	if doc.Issuer != p.Issuer {
+		return ErrProvider.Wrap(fmt.Errorf("discovery issuer %q does not match %q", doc.Issuer, p.Issuer))
+	}
+
+	p.AuthURL = cmpOr(p.AuthURL, doc.AuthURL)
+	p.TokenURL = cmpOr(p.TokenURL, doc.TokenURL)
+	p.UserinfoURL = cmpOr(p.UserinfoURL, doc.UserinfoURL)
+	p.JWKSURL = cmpOr(p.JWKSURL, doc.JWKSURL)
+	return nil
+}
+
+func cmpOr(a, b string) string {
+	if a != "" {
+		return a
+	}
+	return b
+}
+
+// Challenge is the S256 PKCE code challenge for verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where the user is sent to sign in with the provider.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, nonce, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", redirectURI)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", Challenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode(), nil
+}
+
+// Claims are the facts about the user taken from the ID token or userinfo.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Exchange redeems the authorization code and returns the user's claims,
+// from the ID token when the provider issued one and from userinfo
+// otherwise.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, verifier, nonce string) (Claims, error) {
+	if err := p.discover(ctx); err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+	}
+	if err := doJSON(req, &token); err != nil {
+		return Claims{}, err
+	}
+	if token.Error != "" || token.AccessToken == "" {
+		return Claims{}, ErrProvider.Wrap(fmt.Errorf("token endpoint: %s", cmpOr(token.Error, "no access token")))
+	}
+
+	if token.IDToken != "" {
+		return p.verifyIDToken(ctx, token.IDToken, nonce)
+	}
+	return p.userinfo(ctx, token.AccessToken)
+}
+
+func (p *Provider) userinfo(ctx context.Context, accessToken string) (Claims, error) {
+	if p.UserinfoURL == "" {
+		return Claims{}, ErrProvider.Wrap(errors.New("no id token and no userinfo endpoint"))
+	}
+
+	var info map[string]any
+	if err := getJSON(ctx, p.UserinfoURL, accessToken, &info); err != nil {
+		return Claims{}, err
+	}
+
+	claims := claimsFrom(info)
+	if claims.Subject == "" {
+		// GitHub and other OAuth2-only providers use a numeric id.
+		if id, ok := info["id"].(float64); ok {
+			claims.Subject = fmt.Sprintf("%.0f", id)
+		}
+	}
+	if claims.Subject == "" {
+		return Claims{}, ErrProvider.Wrap(errors.New("userinfo has no subject"))
+	}
+	return claims, nil
+}
+
+func claimsFrom(m map[string]any) Claims {
+	claims := Claims{}
+	claims.Subject, _ = m["sub"].(string)
+	claims.Email, _ = m["email"].(string)
+	claims.Name, _ = m["name"].(string)
+	switch v := m["email_verified"].(type) {
+	case bool:
+		claims.EmailVerified = v
+	case string:
+		claims.EmailVerified = v == "true"
+	}
+	return claims
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// an ID token.
+func (p *Provider) verifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, ErrInvalidIDToken.Wrap(err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, ErrInvalidIDToken.Wrap(err)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verify(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return Claims{}, ErrInvalidIDToken.Wrap(err)
+	}
+
+	var payload map[string]any
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return Claims{}, ErrInvalidIDToken.Wrap(err)
+	}
+
+	var aud []string
+	switch v := payload["aud"].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+	iss, _ := payload["iss"].(string)
+	exp, _ := payload["exp"].(float64)
+	tokenNonce, _ := payload["nonce"].(string)
+
+	switch {
+	case iss != p.Issuer:
+		return Claims{}, ErrInvalidIDToken.Wrap(fmt.Errorf("issuer %q", iss))
+	case !slices.Contains(aud, p.ClientID):
+		return Claims{}, ErrInvalidIDToken.Wrap(errors.New("audience does not include the client"))
+	case time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)):
+		return Claims{}, ErrInvalidIDToken.Wrap(errors.New("expired"))
+	case tokenNonce != nonce:
+		return Claims{}, ErrInvalidIDToken.Wrap(errors.New("nonce mismatch"))
+	}
+
+	claims := claimsFrom(payload)
+	if claims.Subject == "" {
+		return Claims{}, ErrInvalidIDToken.Wrap(errors.New("no subject"))
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verify(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg == "RS256" {
+			return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig)
+		}
+	case *ecdsa.PublicKey:
+		// JWS ECDSA signatures are r||s, not ASN.1.
+		if alg == "ES256" && len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(k, digest[:], r, s) {
+				return nil
+			}
+			return errors.New("invalid signature")
+		}
+	}
+	return fmt.Errorf("unsupported alg %q for key", alg)
+}
+
+// key returns the provider's signing key kid, refetching the JWKS when the
+// kid is unknown so that key rotation is picked up.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if p.JWKSURL == "" {
+		return nil, ErrProvider.Wrap(errors.New("no jwks endpoint"))
+	}
+	if err := getJSON(ctx, p.JWKSURL, "", &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		b := func(s string) *big.Int {
+			raw, _ := base64.RawURLEncoding.DecodeString(s)
+			return new(big.Int).SetBytes(raw)
+		}
+		switch {
+		case k.Kty == "RSA":
+			keys[k.Kid] = &rsa.PublicKey{N: b(k.N), E: int(b(k.E).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: b(k.X), Y: b(k.Y)}
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrInvalidIDToken.Wrap(fmt.Errorf("unknown key id %q", kid))
+}
+
+func getJSON(ctx context.Context, url, bearer string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return doJSON(req, v)
+}
+
+func doJSON(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return ErrProvider.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return ErrProvider.Wrap(err)
+	}
+	// Token endpoints report OAuth errors with 400 and a JSON body, which
+	// the caller inspects.
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusBadRequest {
+		return ErrProvider.Wrap(fmt.Errorf("%s: %s", req.URL.Redacted(), resp.Status))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return ErrProvider.Wrap(err)
+	}
+	return nil
+}
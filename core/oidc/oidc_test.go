@@ -0,0 +1,326 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "client"
+	testRedirectURI = "https://app.example.com/user/oidc/callback/mock"
+)
+
+// mockIdP is an OpenID provider for the authorization code flow. Codes are
+// handed out by authorize rather than by a sign-in page, and ID tokens are
+// signed with an ES256 key published in its JWKS.
+type mockIdP struct {
+	*httptest.Server
+	key *ecdsa.PrivateKey
+
+	// issuer overrides the issuer in the discovery document, and claims
+	// changes the claims of the ID tokens issued.
+	issuer string
+	claims func(claims map[string]any)
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("POST /token", idp.token)
+	mux.HandleFunc("GET /userinfo", idp.userinfo)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *mockIdP) provider() *Provider {
+	return &Provider{Name: "mock", Issuer: idp.URL, ClientID: testClientID, ClientSecret: "secret", Scopes: []string{"openid", "email"}}
+}
+
+// authorize plays the user signing in at the auth URL and returns the code
+// the provider redirects back with.
+func (idp *mockIdP) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.URL+"/authorize?") {
+		t.Fatalf("auth url %q is not the discovered endpoint", authURL)
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mu.Lock()
+	idp.codes[code] = u.Query()
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 cmpOr(idp.issuer, idp.URL),
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"userinfo_endpoint":      idp.URL + "/userinfo",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "EC",
+		"crv": "P-256",
+		"kid": "k1",
+		"use": "sig",
+		"x":   base64.RawURLEncoding.EncodeToString(idp.key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(idp.key.Y.FillBytes(make([]byte, 32))),
+	}}})
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	switch {
+	case !ok, r.PostFormValue("redirect_uri") != auth.Get("redirect_uri"), r.PostFormValue("client_id") != testClientID:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	case auth.Get("code_challenge_method") != "S256" || Challenge(r.PostFormValue("code_verifier")) != auth.Get("code_challenge"):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "code verifier"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":            idp.URL,
+		"aud":            testClientID,
+		"sub":            "user-1",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          auth.Get("nonce"),
+	}
+	if idp.claims != nil {
+		idp.claims(claims)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": idp.sign(claims)})
+}
+
+func (idp *mockIdP) userinfo(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer access" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"id": 42, "email": "user@example.com"})
+}
+
+func (idp *mockIdP) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, idp.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, testRedirectURI, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURI,
+		"scope":                 "openid email",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        Challenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(key); got != want {
+			t.Errorf("auth url %s = %q, want %q", key, got, want)
+		}
+	}
+
+	claims, err := p.Exchange(ctx, testRedirectURI, idp.authorize(t, authURL), "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := Claims{Subject: "user-1", Email: "user@example.com", EmailVerified: true}
+	if claims != want {
+		t.Errorf("Exchange = %+v, want %+v", claims, want)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, testRedirectURI, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if _, err := p.Exchange(ctx, testRedirectURI, idp.authorize(t, authURL), "other", "nonce"); !errors.Is(err, ErrProvider) {
+		t.Errorf("Exchange with another verifier = %v, want ErrProvider", err)
+	}
+}
+
+func TestExchangeRejectsIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims func(claims map[string]any)
+		nonce  string
+	}{
+		{"issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, "nonce"},
+		{"audience", func(c map[string]any) { c["aud"] = "other" }, "nonce"},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "nonce"},
+		{"no expiry", func(c map[string]any) { delete(c, "exp") }, "nonce"},
+		{"nonce", nil, "another nonce"},
+		{"no subject", func(c map[string]any) { delete(c, "sub") }, "nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.claims = tt.claims
+			p := idp.provider()
+			ctx := context.Background()
+
+			authURL, err := p.AuthCodeURL(ctx, testRedirectURI, "state", "nonce", "verifier")
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+			if _, err := p.Exchange(ctx, testRedirectURI, idp.authorize(t, authURL), "verifier", tt.nonce); !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("Exchange = %v, want ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
+
+func TestExchangeAudienceList(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.claims = func(c map[string]any) { c["aud"] = []string{"other", testClientID} }
+	p := idp.provider()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, testRedirectURI, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if _, err := p.Exchange(ctx, testRedirectURI, idp.authorize(t, authURL), "verifier", "nonce"); err != nil {
+		t.Errorf("Exchange: %v", err)
+	}
+}
+
+func TestVerifyIDTokenSignature(t *testing.T) {
+	idp := newMockIdP(t)
+	p := idp.provider()
+	ctx := context.Background()
+	if err := p.discover(ctx); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+
+	claims := map[string]any{"iss": idp.URL, "aud": testClientID, "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix(), "nonce": "nonce"}
+	token := idp.sign(claims)
+	if _, err := p.verifyIDToken(ctx, token, "nonce"); err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	claims["sub"] = "admin"
+	payload, _ := json.Marshal(claims)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	if _, err := p.verifyIDToken(ctx, tampered, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("verifyIDToken with a changed payload = %v, want ErrInvalidIDToken", err)
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`))
+	if _, err := p.verifyIDToken(ctx, header+"."+parts[1]+".", "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("verifyIDToken with alg none = %v, want ErrInvalidIDToken", err)
+	}
+
+	header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"unknown"}`))
+	if _, err := p.verifyIDToken(ctx, header+"."+parts[1]+"."+parts[2], "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("verifyIDToken with an unknown key = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.issuer = "https://evil.example.com"
+
+	if _, err := idp.provider().AuthCodeURL(context.Background(), testRedirectURI, "state", "nonce", "verifier"); !errors.Is(err, ErrProvider) {
+		t.Errorf("AuthCodeURL = %v, want ErrProvider", err)
+	}
+}
+
+// Providers without an issuer, such as GitHub, are read from userinfo.
+func TestExchangeUserinfo(t *testing.T) {
+	idp := newMockIdP(t)
+	p := &Provider{Name: "plain", ClientID: testClientID, AuthURL: idp.URL + "/authorize", TokenURL: idp.URL + "/token", UserinfoURL: idp.URL + "/userinfo"}
+	ctx := context.Background()
+
+	// The mock always issues an ID token, which a plain provider would not.
+	mux := idp.Config.Handler
+	idp.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "access"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	authURL, err := p.AuthCodeURL(ctx, testRedirectURI, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	claims, err := p.Exchange(ctx, testRedirectURI, idp.authorize(t, authURL), "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "42" || claims.Email != "user@example.com" || claims.EmailVerified {
+		t.Errorf("Exchange = %+v", claims)
+	}
+}
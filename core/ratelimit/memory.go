@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops the keys that have expired.
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// MemoryStore keeps the state in the process. Each process counts on its
+// own, so with several of them the limits apply per process.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	swept   time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}}
+}
+
+func (store *MemoryStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(State) State) error {
+	now := clock()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if now.Sub(store.swept) > sweepInterval {
+		for k, entry := range store.entries {
+			if now.After(entry.expires) {
+				delete(store.entries, k)
+			}
+		}
+		store.swept = now
+	}
+
+	var state State
+	if entry, ok := store.entries[key]; ok && !now.After(entry.expires) {
+		state = entry.state
+	}
+	store.entries[key] = memoryEntry{state: fn(state), expires: now.Add(ttl)}
+	return nil
+}
@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+)
+
+var ErrRateLimited = apperror.New(http.StatusTooManyRequests, "rate_limited", "too many requests, try again later")
+
+type Algorithm string
+
+const (
+	// TokenBucket allows bursts of up to Limit requests, refilled evenly
+	// over Period.
+	TokenBucket Algorithm = "token_bucket"
+	// SlidingWindow allows Limit requests in any Period, estimated from the
+	// counts of the current and the previous fixed window.
+	SlidingWindow Algorithm = "sliding_window"
+)
+
+// Policy limits the requests made under one key. Keys are scoped by Name,
+// so policies with different names keep separate counts.
+type Policy struct {
+	Name      string
+	Algorithm Algorithm
+	Limit     int
+	Period    time.Duration
+}
+
+// Result is the outcome of a request under a policy. Reset is how long
+// until the full limit is available again, and RetryAfter how long until
+// the next request is allowed when this one was not.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// State is what a store keeps per key. Its meaning depends on the
+// algorithm: the tokens left and the time of the last refill for a token
+// bucket, the counts of the current and previous window and the start of
+// the current one for a sliding window.
+type State struct {
+	Value float64
+	Prev  float64
+	Time  time.Time
+}
+
+// Store keeps the state of the limited keys. Update must apply fn
+// atomically, giving it the zero State for a key that is new or was last
+// updated more than ttl ago.
+type Store interface {
+	Update(ctx context.Context, key string, ttl time.Duration, fn func(State) State) error
+}
+
+// clock tells the time to the policies and stores. Tests replace it.
+var clock = time.Now
+
+// Default is the store built from the environment at startup.
+var Default Store = NewMemoryStore()
+
+// FromEnv builds the store named by RATE_LIMIT_STORE: memory (the default)
+// or sqlite, which keeps the counts in the SQLite file RATE_LIMIT_DB so
+// that every process on the host shares them.
+func FromEnv() (Store, error) {
+	switch backend := os.Getenv("RATE_LIMIT_STORE"); backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		path := os.Getenv("RATE_LIMIT_DB")
+		if path == "" {
+			path = "ratelimit.db"
+		}
+		return OpenSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", backend)
+	}
+}
+
+// Allow counts a request made under key against the policy.
+func (policy Policy) Allow(ctx context.Context, store Store, key string) (Result, error) {
+	if policy.Limit <= 0 || policy.Period <= 0 {
+		return Result{}, fmt.Errorf("rate limit %q needs a positive limit and period", policy.Name)
+	}
+
+	now := clock()
+	var result Result
+	var fn func(State) State
+	ttl := policy.Period
+
+	switch policy.Algorithm {
+	case TokenBucket:
+		fn = func(s State) State {
+			s, result = policy.tokenBucket(s, now)
+			return s
+		}
+	case SlidingWindow:
+		// The previous window still counts during the current one.
+		ttl = 2 * policy.Period
+		fn = func(s State) State {
+			s, result = policy.slidingWindow(s, now)
+			return s
+		}
+	default:
+		return Result{}, fmt.Errorf("rate limit %q has unknown algorithm %q", policy.Name, policy.Algorithm)
+	}
+
+	if err := store.Update(ctx, policy.Name+":"+key, ttl, fn); err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+func (policy Policy) tokenBucket(s State, now time.Time) (State, Result) {
+	limit := float64(policy.Limit)
+	rate := limit / policy.Period.Seconds()
+
+	tokens := limit
+	if !s.Time.IsZero() {
+		tokens = min(limit, s.Value+now.Sub(s.Time).Seconds()*rate)
+	}
+
+	result := Result{Limit: policy.Limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((limit - tokens) / rate)
+
+	return State{Value: tokens, Time: now}, result
+}
+
+func (policy Policy) slidingWindow(s State, now time.Time) (State, Result) {
+	limit := float64(policy.Limit)
+	start := now.Truncate(policy.Period)
+
+	switch {
+	case s.Time.Equal(start):
+	case s.Time.Add(policy.Period).Equal(start):
+		s = State{Prev: s.Value, Time: start}
+	default:
+		s = State{Time: start}
+	}
+
+	// The previous window is weighted by how much of it still falls within
+	// Period of now.
+	elapsed := now.Sub(start)
+	weight := 1 - elapsed.Seconds()/policy.Period.Seconds()
+	count := s.Prev*weight + s.Value
+
+	result := Result{Limit: policy.Limit}
+	if count+1 <= limit {
+		s.Value++
+		count++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = policy.slidingWait(s, elapsed)
+	}
+	result.Remaining = max(int(limit-count), 0)
+	// Both windows have left the sliding one by the end of the next.
+	result.Reset = 2*policy.Period - elapsed
+	if s.Value == 0 {
+		result.Reset = policy.Period - elapsed
+	}
+
+	return s, result
+}
+
+// slidingWait returns how long until the count of the sliding window drops
+// enough for one more request.
+func (policy Policy) slidingWait(s State, elapsed time.Duration) time.Duration {
+	period := policy.Period.Seconds()
+	room := float64(policy.Limit) - 1
+
+	// Within the current window only the weight of the previous one falls.
+	if s.Value <= room && s.Prev > 0 {
+		at := period * (1 - (room-s.Value)/s.Prev)
+		return seconds(at - elapsed.Seconds())
+	}
+	// Otherwise the current window becomes the previous one first.
+	at := period * (1 - room/s.Value)
+	return seconds(period - elapsed.Seconds() + at)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,239 @@
+package ratelimit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// start is the beginning of a window of every period used in the tests.
+var start = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// setClock stops the clock at start and returns a function that moves it.
+func setClock(t *testing.T) func(d time.Duration) {
+	now := start
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+// forEachStore runs test against a memory store and a SQLite store.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "ratelimit.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.DB.Close() })
+		test(t, store)
+	})
+}
+
+// allow counts a request and fails the test if the store does.
+func allow(t *testing.T, policy Policy, store Store, key string) Result {
+	t.Helper()
+	result, err := policy.Allow(context.Background(), store, key)
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	return result
+}
+
+func TestTokenBucket(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		advance := setClock(t)
+		policy := Policy{Name: "bucket", Algorithm: TokenBucket, Limit: 3, Period: 3 * time.Second}
+
+		// A full bucket allows a burst of Limit requests.
+		for i := range policy.Limit {
+			result := allow(t, policy, store, "a")
+			if !result.Allowed || result.Remaining != policy.Limit-1-i {
+				t.Fatalf("request %d = %+v", i+1, result)
+			}
+		}
+		if result := allow(t, policy, store, "a"); result.Allowed || result.RetryAfter != time.Second || result.Reset != 3*time.Second {
+			t.Errorf("request over the burst = %+v, want a retry after a second", result)
+		}
+		if result := allow(t, policy, store, "b"); !result.Allowed {
+			t.Errorf("request under another key = %+v", result)
+		}
+
+		// Tokens come back at Limit per Period.
+		advance(500 * time.Millisecond)
+		if result := allow(t, policy, store, "a"); result.Allowed || result.RetryAfter != 500*time.Millisecond {
+			t.Errorf("request half way through a refill = %+v", result)
+		}
+		advance(500 * time.Millisecond)
+		if result := allow(t, policy, store, "a"); !result.Allowed || result.Remaining != 0 {
+			t.Errorf("request after a refill = %+v", result)
+		}
+
+		// The bucket never holds more than Limit.
+		advance(time.Minute)
+		for i := range policy.Limit {
+			if result := allow(t, policy, store, "a"); !result.Allowed {
+				t.Fatalf("request %d after a long pause = %+v", i+1, result)
+			}
+		}
+		if result := allow(t, policy, store, "a"); result.Allowed {
+			t.Errorf("request over the burst after a long pause = %+v", result)
+		}
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		advance := setClock(t)
+		policy := Policy{Name: "window", Algorithm: SlidingWindow, Limit: 4, Period: time.Minute}
+
+		for i := range policy.Limit {
+			if result := allow(t, policy, store, "a"); !result.Allowed || result.Remaining != policy.Limit-1-i {
+				t.Fatalf("request %d = %+v", i+1, result)
+			}
+		}
+		// A quarter of the previous window must slide out before one more
+		// request fits: 15 seconds into the next window.
+		result := allow(t, policy, store, "a")
+		if result.Allowed || result.RetryAfter != 75*time.Second || result.Reset != 2*time.Minute {
+			t.Errorf("request over the limit = %+v, want a retry after 75s", result)
+		}
+
+		advance(74 * time.Second)
+		if result := allow(t, policy, store, "a"); result.Allowed || result.RetryAfter != time.Second {
+			t.Errorf("request a second early = %+v", result)
+		}
+		advance(time.Second)
+		if result := allow(t, policy, store, "a"); !result.Allowed || result.Remaining != 0 {
+			t.Errorf("request once the window slid = %+v", result)
+		}
+
+		// Three quarters into the next window, the one request of the
+		// window before still weighs a quarter.
+		advance(90 * time.Second)
+		for i := range policy.Limit - 1 {
+			if result := allow(t, policy, store, "a"); !result.Allowed {
+				t.Fatalf("request %d a window later = %+v", i+1, result)
+			}
+		}
+		if result := allow(t, policy, store, "a"); result.Allowed {
+			t.Errorf("request over the weighted limit = %+v", result)
+		}
+
+		// After two windows nothing counts.
+		advance(2 * time.Minute)
+		for i := range policy.Limit {
+			if result := allow(t, policy, store, "a"); !result.Allowed {
+				t.Fatalf("request %d two windows later = %+v", i+1, result)
+			}
+		}
+	})
+}
+
+func TestStoreExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		advance := setClock(t)
+		ctx := context.Background()
+		increment := func(s State) State { return State{Value: s.Value + 1, Time: clock()} }
+		value := func() float64 {
+			var got float64
+			if err := store.Update(ctx, "a", time.Minute, func(s State) State { got = s.Value; return s }); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			return got
+		}
+
+		for range 2 {
+			if err := store.Update(ctx, "a", time.Minute, increment); err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+		}
+		if got := value(); got != 2 {
+			t.Errorf("value = %v, want 2", got)
+		}
+
+		// Each update renews the ttl, so the key expires a ttl after the
+		// last one.
+		advance(time.Minute)
+		if got := value(); got != 2 {
+			t.Errorf("value at the ttl = %v, want 2", got)
+		}
+		advance(time.Minute + time.Nanosecond)
+		if got := value(); got != 0 {
+			t.Errorf("value after the ttl = %v, want a new key", got)
+		}
+	})
+}
+
+func TestStoreCleanup(t *testing.T) {
+	advance := setClock(t)
+	ctx := context.Background()
+	keep := func(s State) State { return s }
+
+	memory := NewMemoryStore()
+	memory.Update(ctx, "old", time.Second, keep)
+	advance(sweepInterval + time.Second)
+	memory.Update(ctx, "new", time.Second, keep)
+	if _, ok := memory.entries["old"]; ok || len(memory.entries) != 1 {
+		t.Errorf("memory store kept %d keys after a sweep, want only the new one", len(memory.entries))
+	}
+
+	sqlite, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "ratelimit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.DB.Close()
+	sqlite.Update(ctx, "old", time.Second, keep)
+	advance(2 * time.Second)
+	sqlite.Update(ctx, "new", time.Second, keep)
+	if err := sqlite.DeleteExpired(ctx); err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	var keys []string
+	if err := sqlite.DB.Select(&keys, "SELECT key FROM rate_limits"); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "new" {
+		t.Errorf("keys after DeleteExpired = %v, want only the new one", keys)
+	}
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	store := NewMemoryStore()
+	for _, policy := range []Policy{
+		{Name: "no-limit", Algorithm: TokenBucket, Period: time.Second},
+		{Name: "no-period", Algorithm: SlidingWindow, Limit: 1},
+		{Name: "unknown", Algorithm: "leaky_bucket", Limit: 1, Period: time.Second},
+	} {
+		if _, err := policy.Allow(context.Background(), store, "a"); err == nil {
+			t.Errorf("Allow with policy %q succeeded", policy.Name)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMIT_STORE", "")
+	if store, err := FromEnv(); err != nil {
+		t.Errorf("FromEnv by default: %v", err)
+	} else if _, ok := store.(*MemoryStore); !ok {
+		t.Errorf("FromEnv by default = %T, want a memory store", store)
+	}
+
+	t.Setenv("RATE_LIMIT_STORE", "sqlite")
+	t.Setenv("RATE_LIMIT_DB", filepath.Join(t.TempDir(), "ratelimit.db"))
+	if store, err := FromEnv(); err != nil {
+		t.Errorf("FromEnv with sqlite: %v", err)
+	} else {
+		store.(*SQLiteStore).DB.Close()
+	}
+
+	t.Setenv("RATE_LIMIT_STORE", "redis")
+	if _, err := FromEnv(); err == nil {
+		t.Error("FromEnv with an unknown store succeeded")
+	}
+}
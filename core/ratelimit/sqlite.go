@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS rate_limits (
+	key TEXT PRIMARY KEY,
+	value REAL NOT NULL,
+	prev REAL NOT NULL,
+	time INTEGER NOT NULL,
+	expires INTEGER NOT NULL
+)`
+
+// SQLiteStore keeps the state in a SQLite file of its own, shared by every
+// process that opens it. The sqlite3 driver is registered in main.
+type SQLiteStore struct {
+	DB *sqlx.DB
+}
+
+// OpenSQLiteStore opens the file at path, creating it and its table when
+// missing. Transactions take the write lock when they begin, so that
+// concurrent updates of a key wait for each other instead of failing.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sqlx.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{DB: db}, nil
+}
+
+func (store *SQLiteStore) Update(ctx context.Context, key string, ttl time.Duration, fn func(State) State) error {
+	now := clock()
+
+	tx, err := store.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var row struct {
+		Value   float64 `db:"value"`
+		Prev    float64 `db:"prev"`
+		Time    int64   `db:"time"`
+		Expires int64   `db:"expires"`
+	}
+	var state State
+	err = tx.GetContext(ctx, &row, "SELECT value, prev, time, expires FROM rate_limits WHERE key = ?", key)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case row.Expires >= now.UnixNano():
+		state = State{Value: row.Value, Prev: row.Prev, Time: time.Unix(0, row.Time)}
+	}
+
+	state = fn(state)
+
+	query := `INSERT INTO rate_limits (key, value, prev, time, expires) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, prev = excluded.prev, time = excluded.time, expires = excluded.expires`
+	if _, err := tx.ExecContext(ctx, query, key, state.Value, state.Prev, state.Time.UnixNano(), now.Add(ttl).UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteExpired removes the keys that Update would start over.
+func (store *SQLiteStore) DeleteExpired(ctx context.Context) error {
+	_, err := store.DB.ExecContext(ctx, "DELETE FROM rate_limits WHERE expires < ?", clock().UnixNano())
+	return err
+}
@@ -0,0 +1,333 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+)
+
+const MaxBodySize = 1 << 20
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Bind decodes the request body into dst and validates it. dst must be a
+// pointer to a struct whose fields carry json and validate tags.
+func Bind(w http.ResponseWriter, r *http.Request, dst any) error {
+	if err := Decode(w, r, dst); err != nil {
+		return err
+	}
+	return Struct(dst)
+}
+
+// Decode fills dst from a JSON, form-encoded or multipart body. Keys are
+// matched against the json tags of dst and unknown keys are rejected. Values
+// must have the field's type; booleans accept only true and false, either as
+// JSON booleans or as strings. Struct, slice and map fields can only be set
+// from JSON.
+func Decode(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBodySize)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	values := map[string]any{}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		var err error
+		if mediaType == "multipart/form-data" {
+			err = r.ParseMultipartForm(MaxBodySize)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			return apperror.New(http.StatusBadRequest, "invalid_form", "invalid form body").Wrap(err)
+		}
+		for key, v := range r.PostForm {
+			values[key] = v[len(v)-1]
+		}
+	case "", "application/json":
+		var raw map[string]json.RawMessage
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return apperror.New(http.StatusBadRequest, "empty_body", "empty request body")
+			}
+			return apperror.New(http.StatusBadRequest, "invalid_json", "invalid json body").Wrap(err)
+		}
+		if decoder.More() {
+			return apperror.New(http.StatusBadRequest, "invalid_json", "unexpected data after json body")
+		}
+		for key, v := range raw {
+			values[key] = v
+		}
+	default:
+		return apperror.New(http.StatusUnsupportedMediaType, "unsupported_media_type", "unsupported content type")
+	}
+
+	return assign(values, dst)
+}
+
+// Query fills dst from the URL query string, with the same rules as Decode,
+// and validates it.
+func Query(r *http.Request, dst any) error {
+	values := map[string]any{}
+	for key, v := range r.URL.Query() {
+		values[key] = v[len(v)-1]
+	}
+
+	if err := assign(values, dst); err != nil {
+		return err
+	}
+	return Struct(dst)
+}
+
+// assign sets the fields of dst from values, which hold either form strings
+// or raw JSON.
+func assign(values map[string]any, dst any) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	fields := map[string]string{}
+
+	known := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		known[fieldName(t.Field(i))] = i
+	}
+
+	for key, value := range values {
+		i, ok := known[key]
+		if !ok {
+			fields[key] = "unknown field"
+			continue
+		}
+		if err := set(v.Field(i), value); err != nil {
+			fields[key] = err.Error()
+		}
+	}
+
+	if len(fields) != 0 {
+		return fieldErrors(fields)
+	}
+	return nil
+}
+
+func set(field reflect.Value, value any) error {
+	if field.Kind() == reflect.Pointer {
+		if raw, ok := value.(json.RawMessage); ok && string(raw) == "null" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		ptr := reflect.New(field.Type().Elem())
+		if err := set(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+
+	text, isText := value.(string)
+	raw, _ := value.(json.RawMessage)
+	if !isText {
+		// JSON strings are unquoted so that they follow the same rules as
+		// form values; other JSON values are kept verbatim.
+		if err := json.Unmarshal(raw, &text); err != nil {
+			text = string(raw)
+		} else {
+			isText = true
+		}
+	}
+
+	if field.Type() == timeType {
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return errors.New("must be an RFC 3339 timestamp")
+		}
+		field.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		if !isText {
+			return errors.New("must be a string")
+		}
+		field.SetString(text)
+	case reflect.Bool:
+		switch text {
+		case "true":
+			field.SetBool(true)
+		case "false":
+			field.SetBool(false)
+		default:
+			return errors.New("must be true or false")
+		}
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		field.SetInt(n)
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		// Nested values are only available in JSON bodies and are decoded
+		// as plain JSON, without the unknown field check.
+		if raw == nil || json.Unmarshal(raw, field.Addr().Interface()) != nil {
+			return fmt.Errorf("must be a JSON %s", jsonKind(field.Kind()))
+		}
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// Struct runs the validate tag rules of every field of s, which must be a
+// pointer to a struct. Rules are comma separated:
+//
+//	required      the field is set and non-empty
+//	email         a bare email address
+//	min=N, max=N  string length in characters, or integer bounds
+//	maxbytes=N    string length in bytes, for limits such as bcrypt's
+//	oneof=a b c   one of the space separated values
+//	password      contains a letter and a digit or symbol
+//	eqfield=Name  equal to the field Name
+func Struct(s any) error {
+	v := reflect.ValueOf(s).Elem()
+	t := v.Type()
+	fields := map[string]string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		name := fieldName(t.Field(i))
+		for _, rule := range strings.Split(tag, ",") {
+			if msg := check(v, v.Field(i), rule); msg != "" {
+				fields[name] = msg
+				break
+			}
+		}
+	}
+
+	if len(fields) != 0 {
+		return fieldErrors(fields)
+	}
+	return nil
+}
+
+func check(parent, field reflect.Value, rule string) string {
+	name, param, _ := strings.Cut(rule, "=")
+
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			if name == "required" {
+				return "is required"
+			}
+			return ""
+		}
+		field = field.Elem()
+	}
+
+	s := ""
+	if field.Kind() == reflect.String {
+		s = field.String()
+	}
+
+	switch name {
+	case "required":
+		if field.IsZero() && field.Kind() != reflect.Bool {
+			return "is required"
+		}
+	case "email":
+		if s == "" {
+			return ""
+		}
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s || !strings.Contains(s[strings.LastIndex(s, "@"):], ".") {
+			return "must be a valid email address"
+		}
+	case "min":
+		n, _ := strconv.ParseInt(param, 10, 64)
+		if field.CanInt() && field.Int() < n {
+			return fmt.Sprintf("must be at least %d", n)
+		}
+		if field.Kind() == reflect.String && int64(utf8.RuneCountInString(s)) < n {
+			return fmt.Sprintf("must be at least %d characters", n)
+		}
+	case "max":
+		n, _ := strconv.ParseInt(param, 10, 64)
+		if field.CanInt() && field.Int() > n {
+			return fmt.Sprintf("must be at most %d", n)
+		}
+		if field.Kind() == reflect.String && int64(utf8.RuneCountInString(s)) > n {
+			return fmt.Sprintf("must be at most %d characters", n)
+		}
+	case "maxbytes":
+		n, _ := strconv.ParseInt(param, 10, 64)
+		if int64(len(s)) > n {
+			return fmt.Sprintf("must be at most %d bytes", n)
+		}
+	case "oneof":
+		if s != "" && !slices.Contains(strings.Fields(param), s) {
+			return "must be one of " + strings.Join(strings.Fields(param), ", ")
+		}
+	case "password":
+		var letter, other bool
+		for _, c := range s {
+			if unicode.IsLetter(c) {
+				letter = true
+			} else {
+				other = true
+			}
+		}
+		if !letter || !other {
+			return "must contain a letter and a digit or symbol"
+		}
+	case "eqfield":
+		if !reflect.DeepEqual(field.Interface(), parent.FieldByName(param).Interface()) {
+			return fmt.Sprintf("must match %s", fieldName(fieldByName(parent.Type(), param)))
+		}
+	}
+
+	return ""
+}
+
+func jsonKind(kind reflect.Kind) string {
+	if kind == reflect.Slice {
+		return "array"
+	}
+	return "object"
+}
+
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func fieldByName(t reflect.Type, name string) reflect.StructField {
+	f, _ := t.FieldByName(name)
+	return f
+}
+
+func fieldErrors(fields map[string]string) error {
+	err := apperror.ErrValidation
+	for name, msg := range fields {
+		err = err.WithField(name, msg)
+	}
+	return err
+}
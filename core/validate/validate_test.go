@@ -0,0 +1,193 @@
+package validate
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+)
+
+type signup struct {
+	Email    string    `json:"email" validate:"required,email"`
+	Password string    `json:"password" validate:"required,min=8,maxbytes=72,password"`
+	Confirm  string    `json:"confirm_password" validate:"eqfield=Password"`
+	Age      *int64    `json:"age" validate:"min=13"`
+	Admin    bool      `json:"admin"`
+	Role     string    `json:"role" validate:"oneof=staff admin"`
+	Since    time.Time `json:"since"`
+	Tags     []string  `json:"tags"`
+}
+
+func request(contentType, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func multipartRequest(values map[string]string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range values {
+		form.WriteField(key, value)
+	}
+	form.Close()
+	return request(form.FormDataContentType(), body.String())
+}
+
+// fields returns the per-field messages of a validation error.
+func fields(err error) map[string]string {
+	var e *apperror.Error
+	if !errors.As(err, &e) {
+		return nil
+	}
+	return e.Fields
+}
+
+func TestBind(t *testing.T) {
+	age := int64(30)
+	since := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := signup{Email: "a@example.com", Password: "password1", Confirm: "password1", Age: &age, Admin: true, Role: "staff", Since: since}
+
+	form := url.Values{"email": {"a@example.com"}, "password": {"password1"}, "confirm_password": {"password1"}, "age": {"30"}, "admin": {"true"}, "role": {"staff"}, "since": {"2025-01-02T03:04:05Z"}}
+	requests := map[string]*http.Request{
+		"json":        request("application/json", `{"email":"a@example.com","password":"password1","confirm_password":"password1","age":30,"admin":true,"role":"staff","since":"2025-01-02T03:04:05Z"}`),
+		"json quoted": request("", `{"email":"a@example.com","password":"password1","confirm_password":"password1","age":"30","admin":"true","role":"staff","since":"2025-01-02T03:04:05Z"}`),
+		"form":        request("application/x-www-form-urlencoded", form.Encode()),
+		"multipart":   multipartRequest(map[string]string{"email": "a@example.com", "password": "password1", "confirm_password": "password1", "age": "30", "admin": "true", "role": "staff", "since": "2025-01-02T03:04:05Z"}),
+	}
+	for name, r := range requests {
+		var got signup
+		if err := Bind(httptest.NewRecorder(), r, &got); err != nil {
+			t.Errorf("Bind of %s: %v %v", name, err, fields(err))
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Bind of %s = %+v, want %+v", name, got, want)
+		}
+	}
+
+	var got signup
+	r := request("application/json", `{"email":"a@example.com","password":"password1","confirm_password":"password1","age":null,"tags":["a","b"]}`)
+	if err := Bind(httptest.NewRecorder(), r, &got); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if got.Age != nil || !reflect.DeepEqual(got.Tags, []string{"a", "b"}) {
+		t.Errorf("Bind of null and an array = %+v", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     *http.Request
+		code  string
+		field string
+		msg   string
+	}{
+		{"unknown json field", request("application/json", `{"email":"a@example.com","staff":true}`), "validation_failed", "staff", "unknown field"},
+		{"unknown form field", request("application/x-www-form-urlencoded", "staff=true"), "validation_failed", "staff", "unknown field"},
+		{"unknown multipart field", multipartRequest(map[string]string{"staff": "true"}), "validation_failed", "staff", "unknown field"},
+		{"number for a string", request("application/json", `{"email":1}`), "validation_failed", "email", "must be a string"},
+		{"loose boolean", request("application/json", `{"admin":"yes"}`), "validation_failed", "admin", "must be true or false"},
+		{"loose form boolean", request("application/x-www-form-urlencoded", "admin=1"), "validation_failed", "admin", "must be true or false"},
+		{"fraction", request("application/json", `{"age":1.5}`), "validation_failed", "age", "must be an integer"},
+		{"bad time", request("application/json", `{"since":"yesterday"}`), "validation_failed", "since", "must be an RFC 3339 timestamp"},
+		{"array from a form", request("application/x-www-form-urlencoded", "tags=a"), "validation_failed", "tags", "must be a JSON array"},
+		{"object for an array", request("application/json", `{"tags":{}}`), "validation_failed", "tags", "must be a JSON array"},
+		{"invalid json", request("application/json", `{"email":`), "invalid_json", "", ""},
+		{"trailing json", request("application/json", `{} {}`), "invalid_json", "", ""},
+		{"json array", request("application/json", `[]`), "invalid_json", "", ""},
+		{"empty body", request("application/json", ``), "empty_body", "", ""},
+		{"too large", request("application/json", `{"email":"`+strings.Repeat("a", MaxBodySize)+`"}`), "invalid_json", "", ""},
+		{"unsupported type", request("text/plain", `email=a`), "unsupported_media_type", "", ""},
+	}
+	for _, tt := range tests {
+		var dst signup
+		err := Decode(httptest.NewRecorder(), tt.r, &dst)
+		e := apperror.From(err)
+		if err == nil || e.Code != tt.code || tt.field != "" && e.Fields[tt.field] != tt.msg {
+			t.Errorf("Decode of %s = %v %v, want %s %s %q", tt.name, err, fields(err), tt.code, tt.field, tt.msg)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	var got struct {
+		Limit int64  `json:"limit" validate:"min=1,max=200"`
+		Sort  string `json:"sort" validate:"oneof=email created"`
+		Desc  bool   `json:"desc"`
+	}
+	r := httptest.NewRequest(http.MethodGet, "/?limit=1&limit=50&sort=email&desc=true", nil)
+	if err := Query(r, &got); err != nil || got.Limit != 50 || got.Sort != "email" || !got.Desc {
+		t.Errorf("Query = %+v, %v, want the last value of each key", got, err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/?limit=500&sort=name&cursor=x", nil)
+	err := Query(r, &got)
+	want := map[string]string{"limit": "must be at most 200", "sort": "must be one of email, created", "cursor": "unknown field"}
+	if f := fields(err); len(f) != 1 || f["cursor"] != want["cursor"] {
+		t.Errorf("Query with an unknown key = %v, want only it reported before the rules run", f)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/?limit=500&sort=name", nil)
+	if f := fields(Query(r, &got)); f["limit"] != want["limit"] || f["sort"] != want["sort"] {
+		t.Errorf("Query out of range = %v, want %v", f, want)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	age := func(n int64) *int64 { return &n }
+	valid := signup{Email: "a@example.com", Password: "password1", Confirm: "password1"}
+
+	tests := []struct {
+		name   string
+		change func(s *signup)
+		field  string
+		msg    string
+	}{
+		{"valid", func(s *signup) {}, "", ""},
+		{"missing email", func(s *signup) { s.Email = "" }, "email", "is required"},
+		{"named email", func(s *signup) { s.Email = "A <a@example.com>" }, "email", "must be a valid email address"},
+		{"email without a dot", func(s *signup) { s.Email = "a@localhost" }, "email", "must be a valid email address"},
+		{"email without an at", func(s *signup) { s.Email = "example.com" }, "email", "must be a valid email address"},
+		{"short password", func(s *signup) { s.Password, s.Confirm = "pass1", "pass1" }, "password", "must be at least 8 characters"},
+		{"letters only", func(s *signup) { s.Password, s.Confirm = "password", "password" }, "password", "must contain a letter and a digit or symbol"},
+		{"digits only", func(s *signup) { s.Password, s.Confirm = "12345678", "12345678" }, "password", "must contain a letter and a digit or symbol"},
+		{"confirmation differs", func(s *signup) { s.Confirm = "password2" }, "confirm_password", "must match password"},
+		{"age under the minimum", func(s *signup) { s.Age = age(12) }, "age", "must be at least 13"},
+		{"age at the minimum", func(s *signup) { s.Age = age(13) }, "", ""},
+		{"unknown role", func(s *signup) { s.Role = "owner" }, "role", "must be one of staff, admin"},
+
+		// maxbytes counts bytes, so that passwords stay within bcrypt's 72,
+		// while min counts characters.
+		{"72 bytes of two-byte letters", func(s *signup) { s.Password = strings.Repeat("é", 35) + "1!"; s.Confirm = s.Password }, "", ""},
+		{"73 bytes", func(s *signup) { s.Password = strings.Repeat("é", 35) + "1!a"; s.Confirm = s.Password }, "password", "must be at most 72 bytes"},
+		{"72 bytes of four-byte symbols", func(s *signup) { s.Password = strings.Repeat("😀", 17) + "abcd"; s.Confirm = s.Password }, "", ""},
+		{"76 bytes of four-byte symbols", func(s *signup) { s.Password = strings.Repeat("😀", 18) + "abcd"; s.Confirm = s.Password }, "password", "must be at most 72 bytes"},
+		{"eight characters in more bytes", func(s *signup) { s.Password = "ééééééé1"; s.Confirm = s.Password }, "", ""},
+		{"seven characters in more bytes", func(s *signup) { s.Password = "éééééé1"; s.Confirm = s.Password }, "password", "must be at least 8 characters"},
+	}
+	for _, tt := range tests {
+		s := valid
+		tt.change(&s)
+		err := Struct(&s)
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("Struct of %s = %v %v", tt.name, err, fields(err))
+			}
+			continue
+		}
+		if !errors.Is(err, apperror.ErrValidation) || fields(err)[tt.field] != tt.msg {
+			t.Errorf("Struct of %s = %v, want %s %q", tt.name, fields(err), tt.field, tt.msg)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+// The key endpoints take a session or access token only, so that a leaked
+// key cannot be used to mint more keys. Keys are revoked along with the
+// sessions of their user when the password is reset or changed.
+var (
+	// UserAPIKeyCreateView creates an API key. The key is only shown in
+	// this response.
+	UserAPIKeyCreateView = View{
+		Route:       fmt.Sprintf("%s/api-key/create", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data APIKeyCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := data.Check(); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			userID := user.ID
+			if data.UserID != nil && *data.UserID != user.ID {
+				if !HasPermission(r.Context(), user, models.PermAPIKeysManage) {
+					WriteError(w, r, apperror.ErrForbidden)
+					return
+				}
+				if _, err := userRepo().Get(r.Context(), *data.UserID); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+				userID = *data.UserID
+			}
+
+			key, raw, err := apiKeyRepo().Create(r.Context(), models.APIKey{
+				UserID:  userID,
+				Name:    data.Name,
+				Scopes:  data.Scopes,
+				Expires: data.Expires,
+			})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"api_key": key, "key": raw})
+		}),
+	}
+
+	UserAPIKeyListView = View{
+		Route:       fmt.Sprintf("%s/api-key/list", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var query APIKeyListRequest
+			if err := validate.Query(r, &query); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			userID := user.ID
+			if query.UserID != 0 && query.UserID != user.ID {
+				if !HasPermission(r.Context(), user, models.PermAPIKeysManage) {
+					WriteError(w, r, apperror.ErrForbidden)
+					return
+				}
+				userID = query.UserID
+			}
+
+			keys, err := apiKeyRepo().ListUser(r.Context(), userID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"api_keys": keys})
+		}),
+	}
+
+	// UserAPIKeyRevokeView deletes a key of the current user, or any key
+	// for users with api_keys.manage.
+	UserAPIKeyRevokeView = View{
+		Route:       fmt.Sprintf("%s/api-key/revoke/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/api-key/revoke/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			userID := user.ID
+			if HasPermission(r.Context(), user, models.PermAPIKeysManage) {
+				key, err := apiKeyRepo().Get(r.Context(), id)
+				if err != nil {
+					WriteError(w, r, err)
+					return
+				}
+				userID = key.UserID
+			}
+
+			if err := apiKeyRepo().Delete(r.Context(), userID, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
@@ -0,0 +1,51 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+func TestAPIKeyViews(t *testing.T) {
+	ctx := context.Background()
+
+	admin, err := userRepo().Create(ctx, models.User{Email: testEmail("admin"), Password: "password1", Active: true, Verified: true, Admin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := userRepo().Create(ctx, models.User{Email: testEmail("target"), Password: "password1", Active: true, Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := apiKeyRepo().Create(ctx, models.APIKey{UserID: admin.ID, Name: "script", Scopes: models.SpaceList{models.ScopeUsersWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, route, body string
+		allowed             bool
+	}{
+		{http.MethodPut, "update-active", `{"active":true}`, true},
+		{http.MethodPut, "update-email", `{"email":"changed@example.com"}`, false},
+		{http.MethodPut, "update-password", `{"password":"password2"}`, false},
+		{http.MethodPut, "update-admin", `{"admin":true}`, false},
+		{http.MethodPut, "update-staff", `{"staff":true}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			r := newRequest(tt.method, fmt.Sprintf("/user/%s/%d", tt.route, target.ID), tt.body)
+			r.Header.Set("Authorization", "Bearer "+key)
+			w := serveRequest(r)
+
+			refused := w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), `"api_key_not_allowed"`)
+			if tt.allowed && w.Code != http.StatusOK || !tt.allowed && !refused {
+				t.Errorf("%s = %d %s", tt.route, w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package views
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const AuditRouteGroup = "/audit"
+
+var auditCSVHeader = []string{"id", "actor_id", "action", "target_id", "diff", "diff_hash", "ip", "user_agent", "request_id", "created", "prev_hash", "hash"}
+
+func optionalID(id *int64) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatInt(*id, 10)
+}
+
+var (
+	// AuditListView pages through the audit log, newest first.
+	AuditListView = View{
+		Route:       fmt.Sprintf("%s/list", AuditRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermAuditRead)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var query AuditListRequest
+			if err := validate.Query(r, &query); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			page, err := auditRepo().List(r.Context(), query.Options())
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, page)
+		}),
+	}
+
+	// AuditExportView downloads every matching event, oldest first, as
+	// JSON lines or as CSV. The hashes are included so that the export can
+	// be checked on its own.
+	AuditExportView = View{
+		Route:       fmt.Sprintf("%s/export", AuditRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermAuditRead)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var query AuditListRequest
+			if err := validate.Query(r, &query); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var write func(models.AuditEvent) error
+			var flush func() error
+			name := fmt.Sprintf("audit-%s", time.Now().UTC().Format("20060102T150405Z"))
+
+			if query.Format == "csv" {
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+				c := csv.NewWriter(w)
+				c.Write(auditCSVHeader)
+				write = func(e models.AuditEvent) error {
+					return c.Write([]string{
+						strconv.FormatInt(e.ID, 10), optionalID(e.ActorID), e.Action, optionalID(e.TargetID),
+						string(e.Diff), e.DiffHash, e.IP, e.UserAgent, e.RequestID,
+						e.Created.UTC().Format(time.RFC3339Nano), e.PrevHash, e.Hash,
+					})
+				}
+				flush = func() error {
+					c.Flush()
+					return c.Error()
+				}
+			} else {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.jsonl"`, name))
+				encoder := json.NewEncoder(w)
+				write = func(e models.AuditEvent) error { return encoder.Encode(e) }
+				flush = func() error { return nil }
+			}
+
+			// The status is sent with the first row, so a failure part way
+			// can only be logged and leaves the download truncated.
+			err := auditRepo().Each(r.Context(), query.Options(), write)
+			if err == nil {
+				err = flush()
+			}
+			if err != nil {
+				log.Printf("export audit log: %v", err)
+			}
+		}),
+	}
+)
@@ -0,0 +1,261 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/auth"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const SessionCookieName = "session"
+
+func SessionCookie(token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CurrentSession resolves the session cookie on the request, renewing the
+// session and refreshing the cookie when it is close to expiry.
+func CurrentSession(w http.ResponseWriter, r *http.Request) (models.Session, error) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	session, err := sessionRepo().Get(r.Context(), cookie.Value)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	session, renewed, err := sessionRepo().Renew(r.Context(), session)
+	if err != nil {
+		return models.Session{}, err
+	}
+	if renewed {
+		http.SetCookie(w, SessionCookie(cookie.Value, session.Expires))
+	}
+
+	return session, nil
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func writeTokens(w http.ResponseWriter, r *http.Request, user models.User, refresh string) {
+	access, _, err := auth.Keys.AccessToken(user.ID, user.Email)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(auth.AccessTokenDuration.Seconds()),
+		RefreshToken: refresh,
+	})
+}
+
+var (
+	UserLoginView = View{
+		Route:       fmt.Sprintf("%s/login", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data LoginRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := login(w, r, data.Email, data.Password, data.OTP)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := sessionRepo().DeleteExpired(r.Context()); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			session, token, err := sessionRepo().Create(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			http.SetCookie(w, SessionCookie(token, session.Expires))
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user, "session": session})
+		}),
+	}
+
+	UserLogoutView = View{
+		Route: fmt.Sprintf("%s/logout", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			if cookie, err := r.Cookie(SessionCookieName); err == nil {
+				if err := sessionRepo().Delete(r.Context(), cookie.Value); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+
+			ClearSessionCookie(w)
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// UserLogoutAllView signs the current user out everywhere, revoking
+	// their sessions and every token issued to them.
+	UserLogoutAllView = View{
+		Route:       fmt.Sprintf("%s/logout-all", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := signOutEverywhere(r.Context(), user.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			ClearSessionCookie(w)
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// UserTokenView exchanges credentials for an access and refresh token.
+	UserTokenView = View{
+		Route:       fmt.Sprintf("%s/token", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			if auth.Keys == nil {
+				WriteError(w, r, auth.ErrNoKeys)
+				return
+			}
+
+			var data LoginRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := login(w, r, data.Email, data.Password, data.OTP)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			_, refresh, err := refreshTokenRepo().Create(r.Context(), user.ID, "")
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			writeTokens(w, r, user, refresh)
+		}),
+	}
+
+	// UserTokenRefreshView rotates the refresh token and issues a new access
+	// token.
+	UserTokenRefreshView = View{
+		Route: fmt.Sprintf("%s/token/refresh", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			if auth.Keys == nil {
+				WriteError(w, r, auth.ErrNoKeys)
+				return
+			}
+
+			var data RefreshTokenRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			next, refresh, err := refreshTokenRepo().Rotate(r.Context(), data.RefreshToken)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), next.UserID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if !user.Active {
+				refreshTokenRepo().RevokeFamily(r.Context(), next.Family)
+				WriteError(w, r, models.ErrInactiveUser)
+				return
+			}
+
+			writeTokens(w, r, user, refresh)
+		}),
+	}
+
+	UserTokenRevokeView = View{
+		Route: fmt.Sprintf("%s/token/revoke", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data RefreshTokenRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := refreshTokenRepo().Revoke(r.Context(), data.RefreshToken); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
@@ -0,0 +1,128 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// sessionCookie is the cookie of a session token.
+func sessionCookie(token string) *http.Cookie {
+	return &http.Cookie{Name: SessionCookieName, Value: token}
+}
+
+func TestLoginLogout(t *testing.T) {
+	const ip = "203.0.113.30"
+	withLockout(t, Lockout, ip)
+	ctx := context.Background()
+
+	user, _ := signedIn(t, "login", false)
+	w := loginFrom(ip, user.Email, "password1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	session := cookie(w, SessionCookieName)
+	if session == nil || !session.HttpOnly || !session.Secure || session.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login set session cookie %+v, want it HttpOnly and Secure", session)
+	}
+	if w := serve(http.MethodGet, "/user/passkey/list", "", session); w.Code != http.StatusOK {
+		t.Errorf("request with the new session = %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(http.MethodPost, "/user/logout", "", session); w.Code != http.StatusOK {
+		t.Fatalf("logout = %d %s", w.Code, w.Body)
+	} else if c := w.Result().Cookies(); len(c) != 1 || c[0].Name != SessionCookieName || c[0].MaxAge >= 0 {
+		t.Errorf("logout set cookies %+v, want the session cookie cleared", c)
+	}
+	if w := serve(http.MethodGet, "/user/passkey/list", "", session); w.Code != http.StatusUnauthorized {
+		t.Errorf("request after logout = %d", w.Code)
+	}
+
+	unverified, err := userRepo().Create(ctx, models.User{Email: testEmail("login-unverified"), Password: "password1", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inactive, err := userRepo().Create(ctx, models.User{Email: testEmail("login-inactive"), Password: "password1", Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		email, password string
+		code            int
+		errCode         string
+	}{
+		{testEmail("login-other"), "wrong-password", http.StatusUnauthorized, "invalid_credentials"},
+		{unverified.Email, "password1", http.StatusForbidden, "unverified_email"},
+		{inactive.Email, "password1", http.StatusForbidden, "inactive_user"},
+	}
+	for _, tt := range tests {
+		w := loginFrom(ip, tt.email, tt.password)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.errCode) || cookie(w, SessionCookieName) != nil {
+			t.Errorf("login of %s = %d %s, want %d %s", tt.email, w.Code, w.Body, tt.code, tt.errCode)
+		}
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ctx := context.Background()
+	_, session := signedIn(t, "expiring", false)
+	expire := func(in time.Duration) {
+		t.Helper()
+		query := database.DB.Rebind("UPDATE sessions SET expires = ? WHERE token = ?")
+		if _, err := database.DB.ExecContext(ctx, query, time.Now().Add(in).UTC(), models.HashToken(session.Value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A session with more than half of its lifetime left is left alone.
+	w := serve(http.MethodGet, "/user/passkey/list", "", session)
+	if w.Code != http.StatusOK || cookie(w, SessionCookieName) != nil {
+		t.Errorf("request with a new session = %d, cookie %v", w.Code, cookie(w, SessionCookieName))
+	}
+
+	// One close to expiry slides forward.
+	expire(time.Hour)
+	w = serve(http.MethodGet, "/user/passkey/list", "", session)
+	renewed := cookie(w, SessionCookieName)
+	if w.Code != http.StatusOK || renewed == nil || renewed.Value != session.Value || time.Until(renewed.Expires) < models.SessionDuration-time.Minute {
+		t.Errorf("request with a session close to expiry = %d, cookie %+v, want it renewed", w.Code, renewed)
+	}
+
+	expire(-time.Second)
+	if w := serve(http.MethodGet, "/user/passkey/list", "", session); w.Code != http.StatusUnauthorized {
+		t.Errorf("request with an expired session = %d", w.Code)
+	}
+}
+
+func TestLogoutAllRevokesTokens(t *testing.T) {
+	ctx := context.Background()
+	user, session := signedIn(t, "logout-all", false)
+
+	_, other, err := sessionRepo().Create(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, refresh, err := refreshTokenRepo().Create(ctx, user.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(http.MethodPost, "/user/logout-all", "", session); w.Code != http.StatusOK {
+		t.Fatalf("logout-all = %d %s", w.Code, w.Body)
+	}
+
+	for _, token := range []string{session.Value, other} {
+		if w := serve(http.MethodGet, "/user/passkey/list", "", sessionCookie(token)); w.Code != http.StatusUnauthorized {
+			t.Errorf("request with a revoked session = %d", w.Code)
+		}
+	}
+	if _, _, err := refreshTokenRepo().Rotate(ctx, refresh); !errors.Is(err, models.ErrRefreshTokenReused) {
+		t.Errorf("Rotate after logout-all = %v, want the token revoked", err)
+	}
+}
@@ -0,0 +1,98 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+func TestUserDeleteRestorePurge(t *testing.T) {
+	ctx := context.Background()
+	_, admin := signedIn(t, "deleter-admin", true)
+	_, staff := signedIn(t, "deleter-staff", false, models.RoleStaff)
+	target, targetCookie := signedIn(t, "deleted", false)
+
+	deleteTarget := func(c *http.Cookie) *httptest.ResponseRecorder {
+		return serve(http.MethodDelete, fmt.Sprintf("/user/delete/%d", target.ID), "", c)
+	}
+	restoreTarget := func(c *http.Cookie) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, fmt.Sprintf("/user/restore/%d", target.ID), "", c)
+	}
+	listed := func(includeDeleted bool) bool {
+		query := url.Values{"email": {target.Email}, "include_deleted": {fmt.Sprint(includeDeleted)}}
+		return len(listUsers(t, query, admin).Users) == 1
+	}
+
+	if w := deleteTarget(staff); w.Code != http.StatusForbidden {
+		t.Errorf("delete without the permission = %d %s", w.Code, w.Body)
+	}
+	if w := deleteTarget(admin); w.Code != http.StatusOK {
+		t.Fatalf("delete = %d %s", w.Code, w.Body)
+	}
+	if listed(false) || !listed(true) {
+		t.Errorf("listed after delete = %v, with include_deleted = %v", listed(false), listed(true))
+	}
+	if w := serve(http.MethodGet, "/organization/list", "", targetCookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("session of the deleted user = %d %s", w.Code, w.Body)
+	}
+	if w := deleteTarget(admin); w.Code != http.StatusNotFound {
+		t.Errorf("delete twice = %d %s", w.Code, w.Body)
+	}
+
+	if w := restoreTarget(staff); w.Code != http.StatusForbidden {
+		t.Errorf("restore without the permission = %d %s", w.Code, w.Body)
+	}
+	if w := restoreTarget(admin); w.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s", w.Code, w.Body)
+	}
+	if !listed(false) {
+		t.Error("restored user not listed")
+	}
+	if w := restoreTarget(admin); w.Code != http.StatusNotFound {
+		t.Errorf("restore of a user not deleted = %d %s", w.Code, w.Body)
+	}
+
+	// Purging forgets the user and the diffs of its audit events, but
+	// leaves the chain intact.
+	if w := deleteTarget(admin); w.Code != http.StatusOK {
+		t.Fatalf("delete again = %d %s", w.Code, w.Body)
+	}
+	ids, err := userRepo().Purge(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	if !slices.Contains(ids, target.ID) {
+		t.Errorf("Purge = %v, want %d", ids, target.ID)
+	}
+	if listed(true) {
+		t.Error("purged user still listed with include_deleted")
+	}
+	if w := restoreTarget(admin); w.Code != http.StatusNotFound {
+		t.Errorf("restore after purge = %d %s", w.Code, w.Body)
+	}
+
+	page, err := auditRepo().List(ctx, models.AuditListOptions{TargetID: &target.ID})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var actions []string
+	for _, event := range page.Events {
+		actions = append(actions, event.Action)
+		if event.Action != "user.purge" && string(event.Diff) != `"[anonymized]"` {
+			t.Errorf("%s kept its diff %s", event.Action, event.Diff)
+		}
+	}
+	if len(actions) == 0 || actions[0] != "user.purge" || !slices.Contains(actions, "user.restore") {
+		t.Errorf("events = %v, want the restore and the purge last", actions)
+	}
+	if _, err := auditRepo().Verify(ctx); err != nil {
+		t.Errorf("Verify after Purge: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+func exportLink(token string) string {
+	return fmt.Sprintf("%s%s/export/download?token=%s", BaseURL(), UserRouteGroup, url.QueryEscape(token))
+}
+
+// buildExport builds the archive of a pending export and mails its link to
+// whoever asked for it. It runs after the response has been sent, so
+// failures are logged and leave the export failed.
+func buildExport(ctx context.Context, export models.DataExport, token string, recipient models.User) {
+	archive, err := models.BuildExport(ctx, database.DB, export.UserID)
+	if err == nil {
+		err = dataExportRepo().Complete(ctx, export.ID, archive)
+	}
+	if err != nil {
+		log.Printf("export data of user %d: %v", export.UserID, err)
+		if err := dataExportRepo().Fail(ctx, export.ID); err != nil {
+			log.Printf("fail export %d: %v", export.ID, err)
+		}
+		return
+	}
+
+	err = mail.Default.Send(ctx, mail.Message{
+		To:      recipient.Email,
+		Subject: "Your data export is ready",
+		Body:    fmt.Sprintf("The export of personal data you asked for is ready. Download it by opening the link below.\n\n%s\n\nThe link expires in %s.\n", exportLink(token), models.ExportDuration),
+	})
+	if err != nil {
+		log.Printf("send export link to %s: %v", recipient.Email, err)
+	}
+}
+
+var (
+	// UserExportCreateView starts an export of everything held about the
+	// user with the id in the path, as a ZIP of JSON files. The archive is
+	// built in the background; the download link in the response works
+	// once the export is ready, and is mailed to the requester then. Users
+	// with users.export can only export users they outrank.
+	UserExportCreateView = View{
+		Route:       fmt.Sprintf("%s/export/create/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(ExportRateLimit, ByUser), RequireSelfOr(fmt.Sprintf("%s/export/create/", UserRouteGroup), models.PermUsersExport)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/export/create/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			// Deleted users can still be exported until they are purged.
+			_, err = userRepo().Get(r.Context(), id)
+			if errors.Is(err, models.ErrNotFound) {
+				_, err = userRepo().GetDeleted(r.Context(), id)
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			requester, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), requester, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			export, token, err := dataExportRepo().Create(r.Context(), id, &requester.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			go buildExport(context.WithoutCancel(r.Context()), export, token, requester)
+
+			WriteJSON(w, http.StatusAccepted, map[string]any{"export": export, "download_url": exportLink(token)})
+		}),
+	}
+
+	// UserExportReadView reports the status of an export to its user, to
+	// whoever asked for it, and to users with users.export.
+	UserExportReadView = View{
+		Route:       fmt.Sprintf("%s/export/read/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/export/read/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			export, err := dataExportRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			requester := export.RequestedBy != nil && *export.RequestedBy == user.ID
+			if export.UserID != user.ID && !requester && !HasPermission(r.Context(), user, models.PermUsersExport) {
+				WriteError(w, r, models.ErrNotFound)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"export": export})
+		}),
+	}
+
+	// UserExportDownloadView sends the archive of a ready export. The token
+	// of the link is the only credential, like a password reset link.
+	UserExportDownloadView = View{
+		Route: fmt.Sprintf("%s/export/download", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var data TokenRequest
+			if err := validate.Query(r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			export, err := dataExportRepo().Download(r.Context(), data.Token)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/zip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.zip"`, export.UserID))
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+			w.Write(export.Archive)
+		}),
+	}
+)
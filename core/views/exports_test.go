@@ -0,0 +1,67 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+func TestUserExportCreateRequiresOutranking(t *testing.T) {
+	ctx := context.Background()
+	if _, err := roleRepo().Create(ctx, models.Role{Name: "exporter-test", Permissions: []string{models.PermUsersExport}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, exporter := signedIn(t, "exporter", false, "exporter-test")
+	admin, _ := signedIn(t, "exported-admin", true)
+	plain, _ := signedIn(t, "exported", false)
+
+	if w := serve(http.MethodPost, fmt.Sprintf("/user/export/create/%d", admin.ID), "", exporter); w.Code != http.StatusForbidden {
+		t.Errorf("export of an admin = %d %s", w.Code, w.Body)
+	}
+
+	w := serve(http.MethodPost, fmt.Sprintf("/user/export/create/%d", plain.ID), "", exporter)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("export of a plain user = %d %s", w.Code, w.Body)
+	}
+
+	// Wait for the archive to be built in the background, so that it does
+	// not outlive the test.
+	var created struct{ Export models.DataExport }
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		export, err := dataExportRepo().Get(ctx, created.Export.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if export.Status != models.ExportPending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("export still pending")
+		}
+	}
+}
+
+func TestUserExportCreateRateLimit(t *testing.T) {
+	if _, err := roleRepo().Create(context.Background(), models.Role{Name: "export-limited-test", Permissions: []string{models.PermUsersExport}}); err != nil {
+		t.Fatal(err)
+	}
+	_, session := signedIn(t, "export-limited", false, "export-limited-test")
+
+	for i := range ExportRateLimit.Limit {
+		if w := serve(http.MethodPost, "/user/export/create/999999", "", session); w.Code != http.StatusNotFound {
+			t.Fatalf("request %d = %d", i+1, w.Code)
+		}
+	}
+	if w := serve(http.MethodPost, "/user/export/create/999999", "", session); w.Code != http.StatusTooManyRequests {
+		t.Errorf("export over the limit = %d", w.Code)
+	}
+}
@@ -0,0 +1,381 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const InvitationRouteGroup = "/invitation"
+
+// checkInvite fails unless user may send or manage an invitation with the
+// platform role into the organization, either of which may be empty.
+// Platform roles need users.invite and pass checkGrant. Organizations need
+// users.invite or the rights to give organizationRole as one of their
+// admins.
+func checkInvite(ctx context.Context, user models.User, role string, organizationID *int64, organizationRole string) error {
+	inviter := HasPermission(ctx, user, models.PermUsersInvite)
+
+	if role != "" || organizationID == nil {
+		if !inviter {
+			return apperror.ErrForbidden
+		}
+		if role != "" {
+			if err := checkGrant(ctx, user, []string{role}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if organizationID == nil || inviter {
+		return nil
+	}
+
+	member, err := organizationRepo().Member(ctx, *organizationID, user.ID)
+	if err != nil {
+		return err
+	}
+	return checkMemberChange(member, models.Member{Role: organizationRole}, organizationRole)
+}
+
+// sendInvitation mails the link to accept the invitation with token.
+func sendInvitation(ctx context.Context, invitation models.Invitation, token string) error {
+	into := ""
+	if invitation.OrganizationID != nil {
+		organization, err := organizationRepo().Get(ctx, *invitation.OrganizationID)
+		if err != nil {
+			return err
+		}
+		into = fmt.Sprintf(" to join %s", organization.Name)
+	}
+
+	link := fmt.Sprintf("%s%s/accept?token=%s", BaseURL(), InvitationRouteGroup, url.QueryEscape(token))
+	return mail.Default.Send(ctx, mail.Message{
+		To:      invitation.Email,
+		Subject: "You have been invited",
+		Body:    fmt.Sprintf("You have been invited%s. Accept the invitation by opening the link below.\n\n%s\n\nThe link expires in %s.\n", into, link, models.InvitationDuration),
+	})
+}
+
+var (
+	InvitationCreateView = View{
+		Route:       fmt.Sprintf("%s/create", InvitationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data InvitationCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := data.Check(); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if data.OrganizationID != nil && data.OrganizationRole == "" {
+				data.OrganizationRole = models.OrgRoleMember
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkInvite(r.Context(), user, data.Role, data.OrganizationID, data.OrganizationRole); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if data.Role != "" {
+				roles, err := roleRepo().List(r.Context())
+				if err != nil {
+					WriteError(w, r, err)
+					return
+				}
+				if !slices.ContainsFunc(roles, func(role models.Role) bool { return role.Name == data.Role }) {
+					WriteError(w, r, apperror.ErrValidation.WithField("role", fmt.Sprintf("unknown role %q", data.Role)))
+					return
+				}
+			}
+			if data.OrganizationID != nil {
+				if _, err := organizationRepo().Get(r.Context(), *data.OrganizationID); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+
+			invitation, token, err := invitationRepo().Create(r.Context(), models.Invitation{
+				Email:            data.Email,
+				Role:             data.Role,
+				OrganizationID:   data.OrganizationID,
+				OrganizationRole: data.OrganizationRole,
+				InvitedBy:        &user.ID,
+			})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			// A failed send is logged, the invitation can be resent.
+			if err := sendInvitation(r.Context(), invitation, token); err != nil {
+				log.Printf("send invitation to %s: %v", invitation.Email, err)
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"invitation": invitation})
+		}),
+	}
+
+	// InvitationListView lists pending invitations, all of them or those
+	// into the organization given by organization_id.
+	InvitationListView = View{
+		Route:       fmt.Sprintf("%s/list", InvitationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var data InvitationListRequest
+			if err := validate.Query(r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkInvite(r.Context(), user, "", data.OrganizationID, models.OrgRoleMember); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			invitations, err := invitationRepo().ListPending(r.Context(), data.OrganizationID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"invitations": invitations})
+		}),
+	}
+
+	// InvitationResendView mails a pending invitation again with a new
+	// token and expiry.
+	InvitationResendView = View{
+		Route:       fmt.Sprintf("%s/resend/", InvitationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/resend/", InvitationRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			invitation, err := invitationRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkInvite(r.Context(), user, invitation.Role, invitation.OrganizationID, invitation.OrganizationRole); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			invitation, token, err := invitationRepo().Resend(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := sendInvitation(r.Context(), invitation, token); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"invitation": invitation})
+		}),
+	}
+
+	InvitationRevokeView = View{
+		Route:       fmt.Sprintf("%s/revoke/", InvitationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/revoke/", InvitationRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			invitation, err := invitationRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkInvite(r.Context(), user, invitation.Role, invitation.OrganizationID, invitation.OrganizationRole); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := invitationRepo().Delete(r.Context(), id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// InvitationAcceptView shows the invitation for a token (GET), so that
+	// a client can tell whether to ask for a password, and accepts it
+	// (POST). Accepting creates the account, verified and active, or links
+	// the existing account with the email, then grants the role and the
+	// organization membership of the invitation. A membership the user
+	// already has is only ever raised. Setting a password, which unverified
+	// accounts must, signs the account out everywhere.
+	InvitationAcceptView = View{
+		Route:       fmt.Sprintf("%s/accept", InvitationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				var data TokenRequest
+				if err := validate.Query(r, &data); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+
+				invitation, err := invitationRepo().GetByToken(r.Context(), data.Token)
+				if err != nil {
+					WriteError(w, r, err)
+					return
+				}
+
+				user, err := userRepo().GetByEmail(r.Context(), invitation.Email)
+				if err != nil && !errors.Is(err, models.ErrNotFound) {
+					WriteError(w, r, err)
+					return
+				}
+
+				WriteJSON(w, http.StatusOK, map[string]any{
+					"invitation":        invitation,
+					"account_exists":    err == nil,
+					"password_required": err != nil || !user.Verified,
+				})
+				return
+			}
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data InvitationAcceptRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			invitation, err := invitationRepo().GetByToken(r.Context(), data.Token)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().GetByEmail(r.Context(), invitation.Email)
+			exists := err == nil
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				WriteError(w, r, err)
+				return
+			}
+
+			// An unverified account may have been registered by someone
+			// other than the owner of the email, so it gets a new password
+			// like a new account. The password follows the sign-up rules,
+			// and is checked before the single-use invitation is spent.
+			if !exists || !user.Verified || data.Password != "" {
+				account := UserCreateRequest{Email: invitation.Email, Password: data.Password, ConfirmPassword: data.ConfirmPassword}
+				if err := validate.Struct(&account); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+
+			// The invitation is spent together with everything it grants,
+			// so that a failure leaves it to be accepted again.
+			response := map[string]any{}
+			signedOut := false
+			err = auditRepo().InTx(r.Context(), func(ctx context.Context) error {
+				invitation, err := invitationRepo().Accept(ctx, data.Token)
+				if err != nil {
+					return err
+				}
+
+				switch {
+				case !exists:
+					user, err = userRepo().Create(ctx, models.User{Email: invitation.Email, Password: data.Password, Active: true, Verified: true})
+				case !user.Verified:
+					user, err = userRepo().Verify(ctx, user.ID)
+				}
+				if err != nil {
+					return err
+				}
+
+				if exists && data.Password != "" {
+					if user, err = userRepo().UpdatePassword(ctx, user.ID, data.Password); err != nil {
+						return err
+					}
+					if err := signOutEverywhere(ctx, user.ID); err != nil {
+						return err
+					}
+					signedOut = true
+				}
+
+				if invitation.Role != "" {
+					if err := roleRepo().GrantUserRole(ctx, user.ID, invitation.Role); err != nil {
+						return err
+					}
+					if user, err = userRepo().Get(ctx, user.ID); err != nil {
+						return err
+					}
+				}
+				response["user"] = user
+
+				if invitation.OrganizationID != nil {
+					member, err := organizationRepo().Member(ctx, *invitation.OrganizationID, user.ID)
+					if err != nil || !models.OrgRoleAtLeast(member.Role, invitation.OrganizationRole) {
+						member, err = organizationRepo().AddMember(ctx, *invitation.OrganizationID, user.ID, invitation.OrganizationRole)
+					}
+					if err != nil {
+						return err
+					}
+					response["member"] = member
+				}
+				return nil
+			})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if signedOut {
+				ClearSessionCookie(w)
+			}
+			WriteJSON(w, http.StatusOK, response)
+		}),
+	}
+)
@@ -0,0 +1,76 @@
+package views
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+func TestInvitationAcceptUnverifiedUser(t *testing.T) {
+	ctx := context.Background()
+
+	squatted, err := userRepo().Create(ctx, models.User{Email: testEmail("squatted"), Password: "password1", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, session, err := sessionRepo().Create(ctx, squatted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := invitationRepo().Create(ctx, models.Invitation{Email: squatted.Email, Role: models.RoleStaff})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(http.MethodGet, "/invitation/accept?token="+url.QueryEscape(token), "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"password_required":true`) {
+		t.Errorf("show = %d %s, want password_required", w.Code, w.Body)
+	}
+
+	if w := serve(http.MethodPost, "/invitation/accept", `{"token":"`+token+`"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("accept without a password = %d %s", w.Code, w.Body)
+	}
+
+	w = serve(http.MethodPost, "/invitation/accept", `{"token":"`+token+`","password":"password2","confirm_password":"password2"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("accept = %d %s", w.Code, w.Body)
+	}
+
+	user, err := models.Authenticate(ctx, userRepo(), squatted.Email, "password2")
+	if err != nil || !user.Staff {
+		t.Errorf("Authenticate with the new password = %+v, %v", user, err)
+	}
+	if _, err := models.Authenticate(ctx, userRepo(), squatted.Email, "password1"); err == nil {
+		t.Error("the old password still signs in")
+	}
+	if _, err := sessionRepo().Get(ctx, session); err == nil {
+		t.Error("the old session is still valid")
+	}
+}
+
+func TestInvitationAcceptFailureKeepsInvitation(t *testing.T) {
+	ctx := context.Background()
+	email := testEmail("accept-fails")
+
+	// The role is checked when the invitation is sent; it went away since.
+	_, token, err := invitationRepo().Create(ctx, models.Invitation{Email: email, Role: "removed-role"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"token":"` + token + `","password":"password1","confirm_password":"password1"}`
+	if w := serve(http.MethodPost, "/invitation/accept", body); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("accept with an unknown role = %d %s", w.Code, w.Body)
+	}
+
+	if _, err := userRepo().GetByEmail(ctx, email); err == nil {
+		t.Error("a failed accept created the account")
+	}
+	if _, err := invitationRepo().GetByToken(ctx, token); err != nil {
+		t.Errorf("GetByToken after a failed accept = %v, want the invitation unspent", err)
+	}
+}
@@ -0,0 +1,97 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// listUsers lists users with the query and returns the page.
+func listUsers(t *testing.T, query url.Values, c *http.Cookie) models.UserPage {
+	t.Helper()
+	w := serve(http.MethodGet, "/user/list?"+query.Encode(), "", c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list %s = %d %s", query.Encode(), w.Code, w.Body)
+	}
+	var page models.UserPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func emails(users []models.User) []string {
+	var emails []string
+	for _, user := range users {
+		emails = append(emails, user.Email)
+	}
+	return emails
+}
+
+func TestUserList(t *testing.T) {
+	ctx := context.Background()
+	_, admin := signedIn(t, "lister-admin", true)
+
+	// The names share a marker that the email filter picks out, and sort
+	// in the order they are created.
+	var created []string
+	for i, name := range []string{"keyset-a", "keyset-b", "keyset-c", "keyset-d", "keyset-e"} {
+		user, err := userRepo().Create(ctx, models.User{Email: testEmail(name), Password: "password1", Active: i%2 == 0, Verified: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, user.Email)
+	}
+
+	// Keyset pages walk the whole listing without overlap.
+	var walked []string
+	query := url.Values{"email": {"keyset-"}, "limit": {"2"}, "sort": {"email"}, "total": {"true"}}
+	for range 3 {
+		page := listUsers(t, query, admin)
+		if page.Total == nil || *page.Total != 5 {
+			t.Errorf("total = %v, want 5", page.Total)
+		}
+		walked = append(walked, emails(page.Users)...)
+		if page.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	if !slices.Equal(walked, created) {
+		t.Errorf("keyset pages = %v, want %v", walked, created)
+	}
+
+	page := listUsers(t, url.Values{"email": {"keyset-"}, "sort": {"-email"}, "page": {"2"}, "limit": {"2"}}, admin)
+	if want := []string{created[2], created[1]}; !slices.Equal(emails(page.Users), want) || page.NextPage != 3 {
+		t.Errorf("second page by email descending = %v next %d, want %v next 3", emails(page.Users), page.NextPage, want)
+	}
+
+	page = listUsers(t, url.Values{"email": {"keyset-"}, "active": {"false"}, "sort": {"email"}}, admin)
+	if want := []string{created[1], created[3]}; !slices.Equal(emails(page.Users), want) {
+		t.Errorf("inactive users = %v, want %v", emails(page.Users), want)
+	}
+
+	for _, query := range []string{"sort=password", "limit=500", "page=1&cursor=abc", "active=maybe", "created_after=yesterday", "order=email"} {
+		if w := serve(http.MethodGet, "/user/list?"+query, "", admin); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("list with %s = %d, want 422", query, w.Code)
+		}
+	}
+
+	// Cursors only continue the listing they came from.
+	for _, cursor := range []string{"!!", query.Get("cursor")} {
+		w := serve(http.MethodGet, "/user/list?sort=created&cursor="+url.QueryEscape(cursor), "", admin)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("list with cursor %q = %d, want 422", cursor, w.Code)
+		}
+	}
+
+	_, plain := signedIn(t, "lister-plain", false)
+	if w := serve(http.MethodGet, "/user/list", "", plain); w.Code != http.StatusForbidden {
+		t.Errorf("list without users.read = %d", w.Code)
+	}
+}
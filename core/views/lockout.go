@@ -0,0 +1,173 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// Lockout limits failed sign-ins. It is set from the environment at
+// startup.
+var Lockout = models.DefaultLockoutPolicy
+
+// LockoutEvent is passed to OnLockout. Email is empty for the lockout of a
+// client IP, and may not belong to an account.
+type LockoutEvent struct {
+	Email    string
+	IP       string
+	Lockouts int
+	Until    time.Time
+}
+
+// OnLockout is called in the background when an email or a client IP is
+// locked out. The default logs the lockout and mails the owner of a locked
+// account. Applications replace it to alert elsewhere.
+var OnLockout = func(ctx context.Context, lockout LockoutEvent) {
+	if lockout.Email == "" {
+		log.Printf("sign-in from %s locked until %s", lockout.IP, lockout.Until.Format(time.RFC3339))
+		return
+	}
+	log.Printf("sign-in to %s locked until %s after failures from %s", lockout.Email, lockout.Until.Format(time.RFC3339), lockout.IP)
+
+	user, err := userRepo().GetByEmail(ctx, lockout.Email)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			log.Printf("notify lockout of %s: %v", lockout.Email, err)
+		}
+		return
+	}
+
+	err = mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Sign-in to your account was locked",
+		Body:    fmt.Sprintf("After repeated failed attempts, signing in to your account is locked until %s.\n\nIf this was not you, consider changing your password.\n", lockout.Until.Format(time.RFC1123)),
+	})
+	if err != nil {
+		log.Printf("notify lockout of %s: %v", user.Email, err)
+	}
+}
+
+// setRetryAfter tells the client when it may try again.
+func setRetryAfter(w http.ResponseWriter, until time.Time) {
+	seconds := math.Ceil(time.Until(until).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(seconds), 1)))
+}
+
+// login checks the password and second factor of a sign-in within the
+// limits of Lockout. While the email or the client IP is blocked the
+// sign-in fails with ErrTooManyAttempts before the password is checked.
+// Wrong passwords and codes count against both. Unknown emails are counted
+// like registered ones, so that the responses do not tell them apart.
+func login(w http.ResponseWriter, r *http.Request, email, password, otp string) (models.User, error) {
+	ctx := r.Context()
+	ip := clientIP(r)
+
+	if err := checkBlocked(w, r, email); err != nil {
+		return models.User{}, err
+	}
+
+	user, err := models.Authenticate(ctx, userRepo(), email, password)
+	if err == nil {
+		err = models.VerifySecondFactor(ctx, totpRepo(), recoveryCodeRepo(), user.ID, otp)
+	}
+	if errors.Is(err, models.ErrInvalidCredentials) || errors.Is(err, models.ErrInvalidOTP) {
+		failLogin(w, r, email, ip)
+		return models.User{}, err
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The failures of the IP are kept, or an attacker could clear them by
+	// signing in to an account of their own.
+	if err := loginAttemptRepo().Reset(ctx, models.EmailSubject(email)); err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// checkBlocked fails with ErrTooManyAttempts while the email or the client
+// IP is locked out.
+func checkBlocked(w http.ResponseWriter, r *http.Request, email string) error {
+	until, err := loginAttemptRepo().Blocked(r.Context(), models.EmailSubject(email), models.IPSubject(clientIP(r)))
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		setRetryAfter(w, until)
+		return models.ErrTooManyAttempts
+	}
+	return nil
+}
+
+// failLogin counts a failed sign-in. The sign-in has failed either way, so
+// errors are logged rather than returned.
+func failLogin(w http.ResponseWriter, r *http.Request, email, ip string) {
+	ctx := r.Context()
+
+	attempt, locked, err := loginAttemptRepo().Fail(ctx, models.EmailSubject(email), Lockout)
+	if err != nil {
+		log.Printf("count failed sign-in: %v", err)
+	} else {
+		if attempt.BlockedUntil != nil {
+			setRetryAfter(w, *attempt.BlockedUntil)
+		}
+		if locked {
+			go OnLockout(context.WithoutCancel(ctx), LockoutEvent{Email: email, IP: ip, Lockouts: attempt.Lockouts, Until: *attempt.BlockedUntil})
+		}
+	}
+
+	attempt, locked, err = loginAttemptRepo().Fail(ctx, models.IPSubject(ip), Lockout.ForIP())
+	if err != nil {
+		log.Printf("count failed sign-in: %v", err)
+	} else if locked {
+		go OnLockout(context.WithoutCancel(ctx), LockoutEvent{IP: ip, Lockouts: attempt.Lockouts, Until: *attempt.BlockedUntil})
+	}
+}
+
+var (
+	// UserUnlockView lifts the lockout of a user and forgets their failed
+	// sign-ins. Lockouts of client IPs expire on their own.
+	UserUnlockView = View{
+		Route:       fmt.Sprintf("%s/unlock/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersUpdate), AllowAPIKey(models.ScopeUsersWrite)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/unlock/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := loginAttemptRepo().Reset(r.Context(), models.EmailSubject(user.Email)); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
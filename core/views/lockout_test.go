@@ -0,0 +1,92 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// loginFrom signs in from the client ip.
+func loginFrom(ip, email, password string) *httptest.ResponseRecorder {
+	r := newRequest(http.MethodPost, "/user/login", fmt.Sprintf(`{"email":%q,"password":%q}`, email, password))
+	r.RemoteAddr = ip + ":1234"
+	return serveRequest(r)
+}
+
+// withLockout runs the test under the lockout policy, without notifying
+// anyone of the lockouts.
+func withLockout(t *testing.T, policy models.LockoutPolicy, ips ...string) {
+	lockout, onLockout := Lockout, OnLockout
+	Lockout, OnLockout = policy, func(context.Context, LockoutEvent) {}
+	t.Cleanup(func() {
+		Lockout, OnLockout = lockout, onLockout
+		for _, ip := range ips {
+			loginAttemptRepo().Reset(context.Background(), models.IPSubject(ip))
+		}
+	})
+}
+
+func TestLoginLockoutHidesAccounts(t *testing.T) {
+	const ip = "203.0.113.20"
+	withLockout(t, models.LockoutPolicy{Threshold: 5, Backoff: time.Minute, Duration: time.Hour, Window: time.Hour}, ip)
+
+	user, _ := signedIn(t, "registered", false)
+	unknown := testEmail("unknown")
+
+	var responses [2]*httptest.ResponseRecorder
+	var attempts [2]models.LoginAttempt
+	for i, email := range []string{user.Email, unknown} {
+		responses[i] = loginFrom(ip, email, "wrong-password")
+
+		// The backoff blocks even the right password.
+		if w := loginFrom(ip, email, "password1"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("sign-in to %s during the backoff = %d, Retry-After %q", email, w.Code, w.Header().Get("Retry-After"))
+		}
+
+		err := database.DB.GetContext(context.Background(), &attempts[i], database.DB.Rebind("SELECT failures, lockouts FROM login_attempts WHERE subject = ?"), models.EmailSubject(email))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registered, other := responses[0], responses[1]
+	if registered.Code != http.StatusUnauthorized || registered.Code != other.Code || registered.Body.String() != other.Body.String() ||
+		registered.Header().Get("Retry-After") != other.Header().Get("Retry-After") {
+		t.Errorf("wrong password for a registered email = %d %s %q, for an unknown one = %d %s %q",
+			registered.Code, registered.Body, registered.Header().Get("Retry-After"), other.Code, other.Body, other.Header().Get("Retry-After"))
+	}
+	if attempts[0] != attempts[1] || attempts[0].Failures != 1 {
+		t.Errorf("counters of a registered email = %+v, of an unknown one = %+v", attempts[0], attempts[1])
+	}
+}
+
+func TestUserUnlock(t *testing.T) {
+	const ip = "203.0.113.21"
+	withLockout(t, models.LockoutPolicy{Threshold: 2, Duration: time.Hour, Window: time.Hour}, ip)
+
+	user, _ := signedIn(t, "locked", false)
+	_, admin := signedIn(t, "unlocker", true)
+
+	if w := loginFrom(ip, user.Email, "wrong-password"); w.Code != http.StatusUnauthorized || w.Header().Get("Retry-After") != "" {
+		t.Errorf("first failure = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := loginFrom(ip, user.Email, "wrong-password"); w.Code != http.StatusUnauthorized || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("failure at the threshold = %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := loginFrom(ip, user.Email, "password1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("sign-in while locked out = %d", w.Code)
+	}
+
+	if w := serve(http.MethodPost, fmt.Sprintf("/user/unlock/%d", user.ID), "", admin); w.Code != http.StatusOK {
+		t.Fatalf("unlock = %d %s", w.Code, w.Body)
+	}
+	if w := loginFrom(ip, user.Email, "password1"); w.Code != http.StatusOK {
+		t.Errorf("sign-in after unlock = %d %s", w.Code, w.Body)
+	}
+}
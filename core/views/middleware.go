@@ -0,0 +1,330 @@
+package views
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/auth"
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+type contextKey string
+
+const (
+	userContextKey         contextKey = "user"
+	apiKeyContextKey       contextKey = "api_key"
+	organizationContextKey contextKey = "organization"
+)
+
+// CurrentUser returns the authenticated user placed in the request context
+// by one of the Require* middlewares.
+func CurrentUser(r *http.Request) (models.User, bool) {
+	user, ok := r.Context().Value(userContextKey).(models.User)
+	return user, ok
+}
+
+// WithUser puts user in the request context, as the current user and as the
+// actor of the changes the request makes.
+func WithUser(r *http.Request, user models.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+
+	meta := models.AuditMetaFrom(ctx)
+	meta.ActorID = &user.ID
+	return r.WithContext(models.WithAuditMeta(ctx, meta))
+}
+
+// RequestIDHeader carries the id of a request, taken from the client when
+// it sends a usable one and made up otherwise. It is echoed in the response
+// and recorded in the audit log.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID assigns the request its id and puts the AuditMeta of the
+// request in its context. It wraps the whole mux, see main.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := models.WithAuditMeta(r.Context(), models.AuditMeta{IP: clientIP(r), UserAgent: r.UserAgent(), RequestID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIP is the address the request came from. Forwarding headers are
+// not trusted, so behind a proxy this is the address of the proxy.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// CurrentRequestID returns the id RequestID gave the request.
+func CurrentRequestID(r *http.Request) string {
+	return models.AuditMetaFrom(r.Context()).RequestID
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// authenticate resolves the current user from the request, reusing one that
+// an outer middleware already placed in the context.
+func authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if _, ok := CurrentUser(r); ok {
+		return r, true
+	}
+
+	user, err := requestUser(w, r)
+	if errors.Is(err, models.ErrAPIKeyNotAllowed) {
+		WriteError(w, r, err)
+		return r, false
+	}
+	if err != nil {
+		WriteError(w, r, apperror.ErrUnauthorized)
+		return r, false
+	}
+
+	if !user.Active {
+		WriteError(w, r, models.ErrInactiveUser)
+		return r, false
+	}
+
+	return WithUser(r, user), true
+}
+
+// requestUser loads the user from a bearer access token when the request
+// carries one, and from the session cookie otherwise.
+func requestUser(w http.ResponseWriter, r *http.Request) (models.User, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		// API keys are only accepted by views that use AllowAPIKey, which
+		// puts the user in the context before this runs.
+		if strings.HasPrefix(token, models.APIKeyPrefix) {
+			return models.User{}, models.ErrAPIKeyNotAllowed
+		}
+
+		claims, err := auth.Keys.Verify(token)
+		if err != nil {
+			return models.User{}, err
+		}
+		if claims.Type != "access" {
+			return models.User{}, auth.ErrInvalidToken
+		}
+
+		id, err := strconv.ParseInt(claims.Subject, 10, 64)
+		if err != nil {
+			return models.User{}, auth.ErrInvalidToken
+		}
+
+		return userRepo().Get(r.Context(), id)
+	}
+
+	session, err := CurrentSession(w, r)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return userRepo().Get(r.Context(), session.UserID)
+}
+
+// require builds a middleware that authenticates the request and then
+// checks the user against allow.
+func require(allow func(user models.User, r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := authenticate(w, r)
+			if !ok {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if allow != nil && !allow(user, r) {
+				WriteError(w, r, apperror.ErrForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAuth rejects anonymous requests.
+func RequireAuth(next http.Handler) http.Handler {
+	return require(nil)(next)
+}
+
+// HasPermission reports whether one of the user's roles grants permission.
+// A failed lookup is logged and denies.
+func HasPermission(ctx context.Context, user models.User, permission string) bool {
+	ok, err := roleRepo().HasPermission(ctx, user.ID, permission)
+	if err != nil {
+		log.Printf("check permission %s of user %d: %v", permission, user.ID, err)
+		return false
+	}
+	return ok
+}
+
+// RequirePermission allows users holding permission.
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return require(func(user models.User, r *http.Request) bool {
+		return HasPermission(r.Context(), user, permission)
+	})
+}
+
+// RequireSelfOr allows users acting on their own id, which is read from the
+// path after route, and users holding permission.
+func RequireSelfOr(route, permission string) func(http.Handler) http.Handler {
+	return require(func(user models.User, r *http.Request) bool {
+		idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, route), "/")
+		if id, err := strconv.ParseInt(idStr, 10, 64); err == nil && id == user.ID {
+			return true
+		}
+		return HasPermission(r.Context(), user, permission)
+	})
+}
+
+// OrganizationHeader picks the organization a request acts in. Without it
+// the active organization of the session is used, so clients that have no
+// session must send it.
+const OrganizationHeader = "X-Organization-ID"
+
+type organizationContext struct {
+	organization models.Organization
+	member       models.Member
+}
+
+// CurrentOrganization returns the organization placed in the request
+// context by RequireOrganization, with the current user's membership.
+func CurrentOrganization(r *http.Request) (models.Organization, models.Member, bool) {
+	c, ok := r.Context().Value(organizationContextKey).(organizationContext)
+	return c.organization, c.member, ok
+}
+
+// RequireOrganization authenticates the request and resolves the
+// organization it acts in, which the user must be a member of with role or
+// a more privileged one. Views that keep per-organization data must scope
+// their queries to the id from CurrentOrganization and never to one taken
+// from the request body.
+func RequireOrganization(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r, ok := authenticate(w, r)
+			if !ok {
+				return
+			}
+			user, _ := CurrentUser(r)
+
+			id, err := requestOrganization(w, r, user)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			member, err := organizationRepo().Member(r.Context(), id, user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if !models.OrgRoleAtLeast(member.Role, role) {
+				WriteError(w, r, apperror.ErrForbidden)
+				return
+			}
+
+			organization, err := organizationRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			organization.Role = member.Role
+
+			c := organizationContext{organization: organization, member: member}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), organizationContextKey, c)))
+		})
+	}
+}
+
+// requestOrganization returns the id from OrganizationHeader, or else the
+// active organization of the user's session.
+func requestOrganization(w http.ResponseWriter, r *http.Request, user models.User) (int64, error) {
+	if header := r.Header.Get(OrganizationHeader); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil {
+			return 0, apperror.ErrValidation.WithField(OrganizationHeader, "must be an integer")
+		}
+		return id, nil
+	}
+
+	session, err := CurrentSession(w, r)
+	if err != nil || session.UserID != user.ID || session.OrganizationID == nil {
+		return 0, models.ErrNoOrganization
+	}
+	return *session.OrganizationID, nil
+}
+
+// CurrentAPIKey returns the API key the request was authenticated with, if
+// it was.
+func CurrentAPIKey(r *http.Request) (models.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(models.APIKey)
+	return key, ok
+}
+
+// AllowAPIKey lets the view be called with an API key that grants scope.
+// The key's user is put in the context for the Require* middlewares, so it
+// must come after them in View.Middlewares, which run last to first.
+// Requests without an API key are passed on untouched.
+func AllowAPIKey(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !strings.HasPrefix(token, models.APIKeyPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key, err := apiKeyRepo().Authenticate(r.Context(), token)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if !key.Scopes.Contains(scope) {
+				WriteError(w, r, models.ErrAPIKeyScope)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), key.UserID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if !user.Active {
+				WriteError(w, r, models.ErrInactiveUser)
+				return
+			}
+
+			r = WithUser(r, user)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+		})
+	}
+}
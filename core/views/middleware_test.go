@@ -0,0 +1,134 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// through runs a request with the cookie through middleware, to a handler
+// that writes the id of the current user.
+func through(middleware func(http.Handler) http.Handler, target string, c *http.Cookie) *httptest.ResponseRecorder {
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := CurrentUser(r)
+		fmt.Fprint(w, user.ID)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if c != nil {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// problemCode returns the code of a problem response.
+func problemCode(w *httptest.ResponseRecorder) string {
+	var problem Problem
+	json.Unmarshal(w.Body.Bytes(), &problem)
+	return problem.Code
+}
+
+func TestRequireAuth(t *testing.T) {
+	ctx := context.Background()
+	user, session := signedIn(t, "required", false)
+
+	w := through(RequireAuth, "/", session)
+	if w.Code != http.StatusOK || w.Body.String() != strconv.FormatInt(user.ID, 10) {
+		t.Errorf("signed in = %d %s, want the user in the context", w.Code, w.Body)
+	}
+
+	_, key, err := apiKeyRepo().Create(ctx, models.APIKey{UserID: user.ID, Name: "script", Scopes: models.SpaceList{models.ScopeUsersWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := func(token string) *httptest.ResponseRecorder {
+		handler := RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name string
+		w    *httptest.ResponseRecorder
+		code int
+		err  string
+	}{
+		{"anonymous", through(RequireAuth, "/", nil), http.StatusUnauthorized, "unauthorized"},
+		{"unknown session", through(RequireAuth, "/", sessionCookie("unknown")), http.StatusUnauthorized, "unauthorized"},
+		{"bad bearer token", bearer("not-a-token"), http.StatusUnauthorized, "unauthorized"},
+		{"API key without AllowAPIKey", bearer(key), models.ErrAPIKeyNotAllowed.Status, models.ErrAPIKeyNotAllowed.Code},
+	}
+	for _, tt := range tests {
+		if tt.w.Code != tt.code || problemCode(tt.w) != tt.err || tt.w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s = %d %s, want %d %s", tt.name, tt.w.Code, tt.w.Body, tt.code, tt.err)
+		}
+	}
+
+	if _, err := userRepo().UpdateActive(ctx, user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if w := through(RequireAuth, "/", session); w.Code != http.StatusForbidden || problemCode(w) != "inactive_user" {
+		t.Errorf("deactivated user = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	if _, err := roleRepo().Create(context.Background(), models.Role{Name: "lister-test", Permissions: []string{models.PermUsersRead}}); err != nil {
+		t.Fatal(err)
+	}
+	_, plain := signedIn(t, "unprivileged", false)
+	_, lister := signedIn(t, "lister", false, "lister-test")
+	_, admin := signedIn(t, "permitted-admin", true)
+
+	for _, tt := range []struct {
+		name    string
+		session *http.Cookie
+		code    int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"without the permission", plain, http.StatusForbidden},
+		{"with a role granting it", lister, http.StatusOK},
+		{"admin", admin, http.StatusOK},
+	} {
+		if w := through(RequirePermission(models.PermUsersRead), "/", tt.session); w.Code != tt.code {
+			t.Errorf("%s = %d %s, want %d", tt.name, w.Code, w.Body, tt.code)
+		}
+	}
+}
+
+func TestRequireSelfOr(t *testing.T) {
+	user, session := signedIn(t, "self", false)
+	other, _ := signedIn(t, "not-self", false)
+	_, admin := signedIn(t, "self-admin", true)
+	middleware := RequireSelfOr("/user/read/", models.PermUsersRead)
+
+	for _, tt := range []struct {
+		name    string
+		id      int64
+		session *http.Cookie
+		code    int
+	}{
+		{"own id", user.ID, session, http.StatusOK},
+		{"other id", other.ID, session, http.StatusForbidden},
+		{"other id with the permission", other.ID, admin, http.StatusOK},
+		{"anonymous", user.ID, nil, http.StatusUnauthorized},
+	} {
+		if w := through(middleware, fmt.Sprintf("/user/read/%d", tt.id), tt.session); w.Code != tt.code {
+			t.Errorf("%s = %d %s, want %d", tt.name, w.Code, w.Body, tt.code)
+		}
+	}
+	if w := through(middleware, "/user/read/x", session); w.Code != http.StatusForbidden {
+		t.Errorf("malformed id = %d, want it treated as another user's", w.Code)
+	}
+}
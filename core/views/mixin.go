@@ -2,44 +2,172 @@ package views
 
 import (
 	"encoding/json"
-	"errors"
-	"io"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/models"
 )
 
-func GetData(data *map[string]string, w http.ResponseWriter, r *http.Request) error {
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		if errors.Is(err, io.EOF) {
-			w.WriteHeader(http.StatusBadRequest)
-			return errors.New("empty request body")
-		}
-		if _, ok := err.(*json.SyntaxError); ok {
-			w.WriteHeader(http.StatusBadRequest)
-			return errors.New("invalid json syntax")
-		}
-		w.WriteHeader(http.StatusBadRequest)
-		return errors.New(strings.ToLower(err.Error()))
+func GetId(route string, r *http.Request) (int64, error) {
+	idStr := strings.TrimPrefix(r.URL.Path, route)
+	idStr = strings.TrimLeft(idStr, "/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, apperror.New(http.StatusNotFound, "invalid_id", "invalid id in path")
 	}
 
-	// Check if the decoded data is empty
-	if data == nil || len(*data) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		return errors.New("no data provided")
+	return int64(id), nil
+}
+
+// AllowMethod writes 405 and reports false when the request method differs.
+func AllowMethod(method string, w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		WriteError(w, r, apperror.ErrMethodNotAllowed)
+		return false
 	}
+	return true
+}
 
-	return nil
+func WriteJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
 }
 
-func GetId(route string, w http.ResponseWriter, r *http.Request) (int64, error) {
-	idStr := strings.TrimPrefix(r.URL.Path, route)
-	idStr = strings.TrimLeft(idStr, "/")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return 0, err
+// Problem is an RFC 7807 problem details body. Code and Errors are
+// extension members carrying the apperror code and per-field messages.
+type Problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Code     string            `json:"code"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// WriteError writes err as application/problem+json. Internal errors are
+// logged and their details are not sent to the client.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperror.From(err)
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
 	}
 
-	return int64(id), nil
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(e.Status),
+		Status:   e.Status,
+		Detail:   e.Message,
+		Instance: r.URL.Path,
+		Code:     e.Code,
+		Errors:   e.Fields,
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// The repositories are built on demand because database.DB is only assigned
+// once main has opened the database.
+// userRepo and roleRepo record the changes they make to users in the audit
+// log.
+func userRepo() models.UserRepository {
+	return models.NewAuditedUserRepository(models.NewUserRepository(database.DB), auditRepo())
+}
+
+func sessionRepo() models.SessionRepository {
+	return models.NewSessionRepository(database.DB)
+}
+
+func refreshTokenRepo() models.RefreshTokenRepository {
+	return models.NewRefreshTokenRepository(database.DB)
+}
+
+func verificationTokenRepo() models.VerificationTokenRepository {
+	return models.NewVerificationTokenRepository(database.DB)
+}
+
+func totpRepo() models.TOTPRepository {
+	return models.NewTOTPRepository(database.DB)
+}
+
+func recoveryCodeRepo() models.RecoveryCodeRepository {
+	return models.NewRecoveryCodeRepository(database.DB)
+}
+
+func passkeyRepo() models.PasskeyRepository {
+	return models.NewPasskeyRepository(database.DB)
+}
+
+func identityRepo() models.UserIdentityRepository {
+	return models.NewUserIdentityRepository(database.DB)
+}
+
+func oidcStateRepo() models.OIDCStateRepository {
+	return models.NewOIDCStateRepository(database.DB)
+}
+
+func oauthClientRepo() models.OAuthClientRepository {
+	return models.NewOAuthClientRepository(database.DB)
+}
+
+func oauthCodeRepo() models.OAuthCodeRepository {
+	return models.NewOAuthCodeRepository(database.DB)
+}
+
+func oauthTokenRepo() models.OAuthTokenRepository {
+	return models.NewOAuthTokenRepository(database.DB)
+}
+
+func oauthConsentRepo() models.OAuthConsentRepository {
+	return models.NewOAuthConsentRepository(database.DB)
+}
+
+func apiKeyRepo() models.APIKeyRepository {
+	return models.NewAPIKeyRepository(database.DB)
+}
+
+func roleRepo() models.RoleRepository {
+	return models.NewAuditedRoleRepository(models.NewRoleRepository(database.DB), auditRepo())
+}
+
+func permissionRepo() models.PermissionRepository {
+	return models.NewPermissionRepository(database.DB)
+}
+
+func organizationRepo() models.OrganizationRepository {
+	return models.NewOrganizationRepository(database.DB)
+}
+
+func invitationRepo() models.InvitationRepository {
+	return models.NewInvitationRepository(database.DB)
+}
+
+func auditRepo() models.AuditRepository {
+	return models.NewAuditRepository(database.DB)
+}
+
+func dataExportRepo() models.DataExportRepository {
+	return models.NewDataExportRepository(database.DB)
+}
+
+func loginAttemptRepo() models.LoginAttemptRepository {
+	return models.NewLoginAttemptRepository(database.DB)
+}
+
+// BaseURL is the public origin used in links sent to users, from BASE_URL.
+func BaseURL() string {
+	if base := os.Getenv("BASE_URL"); base != "" {
+		return strings.TrimRight(base, "/")
+	}
+	return fmt.Sprintf("http://localhost:%s", os.Getenv("PORT"))
 }
@@ -0,0 +1,914 @@
+package views
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/auth"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const OAuthRouteGroup = "/oauth"
+
+// oauthScopes are the scopes this server gives a meaning to. Clients may be
+// registered with other scopes for their own APIs.
+var oauthScopes = []string{"openid", "email"}
+
+var oauthGrantTypes = []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials}
+
+// These errors use the RFC 6749 error codes so that writeOAuthError can send
+// them as they are.
+var (
+	errOAuthRequest        = apperror.New(http.StatusBadRequest, "invalid_request", "the request is missing a parameter or is malformed")
+	errUnknownClient       = apperror.New(http.StatusBadRequest, "invalid_client", "unknown client or redirect uri")
+	errUnsupportedGrant    = apperror.New(http.StatusBadRequest, "unsupported_grant_type", "unsupported grant type")
+	errUnsupportedResponse = apperror.New(http.StatusBadRequest, "unsupported_response_type", "only the code response type is supported")
+	errUnauthorizedClient  = apperror.New(http.StatusBadRequest, "unauthorized_client", "the client may not use this grant type")
+	errInvalidScope        = apperror.New(http.StatusBadRequest, "invalid_scope", "the requested scope is not allowed for this client")
+	errPKCERequired        = apperror.New(http.StatusBadRequest, "invalid_request", "a S256 code_challenge is required")
+	errLoginRequired       = apperror.New(http.StatusBadRequest, "login_required", "the user is not signed in")
+	errConsentRequired     = apperror.New(http.StatusBadRequest, "consent_required", "the user has not allowed this client")
+	errAccessDenied        = apperror.New(http.StatusBadRequest, "access_denied", "the user denied the request")
+	errInvalidAccessToken  = apperror.New(http.StatusUnauthorized, "invalid_token", "invalid or expired access token")
+	errInsufficientScope   = apperror.New(http.StatusForbidden, "insufficient_scope", "the access token does not grant the openid scope")
+	errInvalidConsentToken = apperror.New(http.StatusForbidden, "invalid_consent", "invalid consent form, reload the page")
+)
+
+const (
+	oauthLoginFailed   = "Invalid email, password or two-factor code."
+	oauthLoginInactive = "This account is inactive or its email address is not verified."
+	oauthLoginBlocked  = "Too many failed attempts. Try again later."
+)
+
+// writeOAuthError writes err in the RFC 6749 error format that OAuth
+// clients expect. Server errors are written as problems like elsewhere.
+func writeOAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperror.From(err)
+	if e.Status >= http.StatusInternalServerError {
+		WriteError(w, r, err)
+		return
+	}
+
+	switch {
+	case e.Code == errInvalidAccessToken.Code:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	case e.Status == http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, e.Status, map[string]string{"error": e.Code, "error_description": e.Message})
+}
+
+// parseOAuthForm parses the query string and form body of the request.
+func parseOAuthForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, validate.MaxBodySize)
+	if err := r.ParseForm(); err != nil {
+		return errOAuthRequest.Wrap(err)
+	}
+	return nil
+}
+
+// authenticateClient reads the client credentials from HTTP basic auth or,
+// failing that, from the client_id and client_secret form values.
+func authenticateClient(r *http.Request) (models.OAuthClient, error) {
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		var err error
+		if id, err = url.QueryUnescape(id); err != nil {
+			return models.OAuthClient{}, models.ErrInvalidClient
+		}
+		if secret, err = url.QueryUnescape(secret); err != nil {
+			return models.OAuthClient{}, models.ErrInvalidClient
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if id == "" {
+		return models.OAuthClient{}, models.ErrInvalidClient
+	}
+
+	return oauthClientRepo().Authenticate(r.Context(), id, secret)
+}
+
+// requestedScope returns the space separated scope if allowed has all of
+// it, and allowed itself when no scope was asked for.
+func requestedScope(raw string, allowed models.SpaceList) (models.SpaceList, error) {
+	var scope models.SpaceList
+	for _, s := range strings.Fields(raw) {
+		if !slices.Contains(scope, s) {
+			scope = append(scope, s)
+		}
+	}
+	if len(scope) == 0 {
+		return allowed, nil
+	}
+	if !allowed.Contains(scope...) {
+		return nil, errInvalidScope
+	}
+	return scope, nil
+}
+
+// userClaims are the OpenID Connect claims about the user that scope
+// grants.
+func userClaims(user models.User, scope models.SpaceList) map[string]any {
+	claims := map[string]any{"sub": fmt.Sprint(user.ID)}
+	if scope.Contains("email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.Verified
+	}
+	return claims
+}
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// issueTokens creates an access token and, for users of clients allowed to
+// refresh, a refresh token.
+func issueTokens(ctx context.Context, client models.OAuthClient, userID *int64, scope models.SpaceList) (OAuthTokenResponse, error) {
+	_, access, err := oauthTokenRepo().Create(ctx, models.TokenKindAccess, client.ClientID, userID, scope, models.OAuthAccessDuration)
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	response := OAuthTokenResponse{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(models.OAuthAccessDuration.Seconds()),
+		Scope:       scope.String(),
+	}
+
+	if userID != nil && client.GrantTypes.Contains(models.GrantRefreshToken) {
+		_, response.RefreshToken, err = oauthTokenRepo().Create(ctx, models.TokenKindRefresh, client.ClientID, userID, scope, models.OAuthRefreshDuration)
+		if err != nil {
+			return OAuthTokenResponse{}, err
+		}
+	}
+
+	return response, nil
+}
+
+// verifyPKCE checks the code_verifier against the S256 challenge.
+func verifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(challenge)) == 1
+}
+
+func grantAuthorizationCode(r *http.Request, client models.OAuthClient) (OAuthTokenResponse, error) {
+	code, err := oauthCodeRepo().Consume(r.Context(), r.PostForm.Get("code"))
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+	if code.ClientID != client.ClientID || code.RedirectURI != r.PostForm.Get("redirect_uri") || !verifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		return OAuthTokenResponse{}, models.ErrInvalidGrant
+	}
+
+	user, err := userRepo().Get(r.Context(), code.UserID)
+	if errors.Is(err, models.ErrNotFound) || err == nil && !user.Active {
+		return OAuthTokenResponse{}, models.ErrInvalidGrant
+	}
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	// The ID token is signed first so that a missing signing key does not
+	// leave unused tokens behind.
+	var idToken string
+	if code.Scope.Contains("openid") {
+		now := time.Now()
+		claims := userClaims(user, code.Scope)
+		claims["iss"] = BaseURL()
+		claims["aud"] = client.ClientID
+		claims["iat"] = now.Unix()
+		claims["exp"] = now.Add(models.OAuthAccessDuration).Unix()
+		claims["auth_time"] = code.AuthTime.Unix()
+		if code.Nonce != "" {
+			claims["nonce"] = code.Nonce
+		}
+		if idToken, err = auth.Keys.SignPublic(claims); err != nil {
+			return OAuthTokenResponse{}, err
+		}
+	}
+
+	response, err := issueTokens(r.Context(), client, &user.ID, code.Scope)
+	response.IDToken = idToken
+	return response, err
+}
+
+// grantRefreshToken rotates the refresh token. Presenting a refresh token
+// that was already rotated revokes every token of the grant, since either
+// the client or an attacker holds a stolen copy.
+func grantRefreshToken(r *http.Request, client models.OAuthClient) (OAuthTokenResponse, error) {
+	token, err := oauthTokenRepo().Get(r.Context(), r.PostForm.Get("refresh_token"))
+	if errors.Is(err, models.ErrNotFound) {
+		return OAuthTokenResponse{}, models.ErrInvalidGrant
+	}
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+	if token.Kind != models.TokenKindRefresh || token.ClientID != client.ClientID || token.UserID == nil || time.Now().After(token.Expires) {
+		return OAuthTokenResponse{}, models.ErrInvalidGrant
+	}
+
+	rotated, err := oauthTokenRepo().Revoke(r.Context(), token.ID)
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+	if !rotated {
+		if err := oauthTokenRepo().RevokeGrant(r.Context(), client.ClientID, *token.UserID); err != nil {
+			return OAuthTokenResponse{}, err
+		}
+		return OAuthTokenResponse{}, models.ErrInvalidGrant
+	}
+
+	scope, err := requestedScope(r.PostForm.Get("scope"), token.Scope)
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	user, err := userRepo().Get(r.Context(), *token.UserID)
+	if errors.Is(err, models.ErrNotFound) || err == nil && !user.Active {
+		return OAuthTokenResponse{}, models.ErrInvalidGrant
+	}
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	return issueTokens(r.Context(), client, &user.ID, scope)
+}
+
+// grantClientCredentials issues a token that acts for the client itself.
+func grantClientCredentials(r *http.Request, client models.OAuthClient) (OAuthTokenResponse, error) {
+	if client.Public {
+		return OAuthTokenResponse{}, errUnauthorizedClient
+	}
+
+	scope, err := requestedScope(r.PostForm.Get("scope"), client.Scopes)
+	if err != nil {
+		return OAuthTokenResponse{}, err
+	}
+
+	return issueTokens(r.Context(), client, nil, scope)
+}
+
+// authorizeRequest holds the parameters of an authorization request, read
+// from the query string and, when the consent form is posted, the form.
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+func parseAuthorize(r *http.Request) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Prompt:              r.Form.Get("prompt"),
+	}
+}
+
+// Values are the parameters to carry through the consent form.
+func (req authorizeRequest) Values() map[string]string {
+	return map[string]string{
+		"response_type":         req.ResponseType,
+		"client_id":             req.ClientID,
+		"redirect_uri":          req.RedirectURI,
+		"scope":                 req.Scope,
+		"state":                 req.State,
+		"nonce":                 req.Nonce,
+		"code_challenge":        req.CodeChallenge,
+		"code_challenge_method": req.CodeChallengeMethod,
+	}
+}
+
+// client checks the client and redirect uri. Until both are known to be
+// good errors are shown to the user instead of being redirected.
+func (req *authorizeRequest) client(ctx context.Context) (models.OAuthClient, error) {
+	client, err := oauthClientRepo().Get(ctx, req.ClientID)
+	if errors.Is(err, models.ErrNotFound) {
+		return models.OAuthClient{}, errUnknownClient
+	}
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return models.OAuthClient{}, errUnknownClient
+	}
+
+	return client, nil
+}
+
+// scope checks the rest of the request, whose errors go back to the client.
+func (req authorizeRequest) scope(client models.OAuthClient) (models.SpaceList, error) {
+	if req.ResponseType != "code" {
+		return nil, errUnsupportedResponse
+	}
+	if !client.GrantTypes.Contains(models.GrantAuthorizationCode) {
+		return nil, errUnauthorizedClient
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return nil, errPKCERequired
+	}
+	return requestedScope(req.Scope, client.Scopes)
+}
+
+// redirect sends the browser back to the client with params and the state.
+func (req authorizeRequest) redirect(w http.ResponseWriter, r *http.Request, params url.Values) {
+	target, _ := url.Parse(req.RedirectURI)
+	query := target.Query()
+	for key, v := range params {
+		query[key] = v
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (req authorizeRequest) redirectError(w http.ResponseWriter, r *http.Request, err error) {
+	e := apperror.From(err)
+	if e.Status >= http.StatusInternalServerError {
+		WriteError(w, r, err)
+		return
+	}
+	req.redirect(w, r, url.Values{"error": {e.Code}, "error_description": {e.Message}})
+}
+
+// consentToken ties the consent form to the session that was shown it.
+func consentToken(r *http.Request) string {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return models.HashToken("consent:" + cookie.Value)
+}
+
+var oauthPages = template.Must(template.New("").Parse(`
+{{define "head"}}<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.}}</title>
+<style>body{font-family:system-ui,sans-serif;max-width:24rem;margin:4rem auto;padding:0 1rem}input,button{display:block;width:100%;margin:.5rem 0;padding:.5rem;box-sizing:border-box}.error{color:#b00}</style>
+</head><body>{{end}}
+
+{{define "login"}}{{template "head" "Sign in"}}
+<h1>Sign in</h1>
+{{with .Error}}<p class="error">{{.}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input name="email" type="email" placeholder="Email" value="{{.Email}}" autocomplete="username" required>
+<input name="password" type="password" placeholder="Password" autocomplete="current-password" required>
+<input name="otp" placeholder="Two-factor code, if enabled" autocomplete="one-time-code">
+<button type="submit">Sign in</button>
+</form>
+</body></html>{{end}}
+
+{{define "consent"}}{{template "head" "Allow access"}}
+<h1>{{.Client}} wants to access your account</h1>
+<p>Signed in as {{.Email}}. {{.Client}} is asking for:</p>
+<ul>{{range .Scope}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="{{.Action}}">
+{{range $name, $value := .Values}}<input type="hidden" name="{{$name}}" value="{{$value}}">{{end}}
+<input type="hidden" name="consent_token" value="{{.Token}}">
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body></html>{{end}}
+`))
+
+func renderOAuthPage(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	w.WriteHeader(status)
+	if err := oauthPages.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("render %s: %v", name, err)
+	}
+}
+
+var (
+	// OAuthDiscoveryView is the OpenID Connect discovery document.
+	OAuthDiscoveryView = View{
+		Route: "/.well-known/openid-configuration",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			base := BaseURL()
+			WriteJSON(w, http.StatusOK, map[string]any{
+				"issuer":                                base,
+				"authorization_endpoint":                base + OAuthRouteGroup + "/authorize",
+				"token_endpoint":                        base + OAuthRouteGroup + "/token",
+				"userinfo_endpoint":                     base + OAuthRouteGroup + "/userinfo",
+				"jwks_uri":                              base + OAuthRouteGroup + "/jwks",
+				"introspection_endpoint":                base + OAuthRouteGroup + "/introspect",
+				"revocation_endpoint":                   base + OAuthRouteGroup + "/revoke",
+				"response_types_supported":              []string{"code"},
+				"grant_types_supported":                 oauthGrantTypes,
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": auth.Keys.Algs(),
+				"scopes_supported":                      oauthScopes,
+				"claims_supported":                      []string{"sub", "email", "email_verified", "auth_time", "nonce"},
+				"code_challenge_methods_supported":      []string{"S256"},
+				"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+				"prompt_values_supported":               []string{"none", "consent"},
+			})
+		}),
+	}
+
+	// OAuthJWKSView publishes the public signing keys. Keys stay listed
+	// while they are in JWT_KEYS, so a new key can be added and published
+	// before it is made active, and an old one kept until its ID tokens
+	// have expired.
+	OAuthJWKSView = View{
+		Route: fmt.Sprintf("%s/jwks", OAuthRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			WriteJSON(w, http.StatusOK, auth.Keys.JWKS())
+		}),
+	}
+
+	// OAuthLoginView is the sign in page users are sent to by
+	// OAuthAuthorizeView. It starts a session and returns to next.
+	OAuthLoginView = View{
+		Route:       fmt.Sprintf("%s/login", OAuthRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page := map[string]any{"Action": r.URL.RequestURI()}
+			next := localRedirect(r.URL.Query().Get("next"))
+			if next == "" {
+				next = "/"
+			}
+
+			switch r.Method {
+			case http.MethodGet:
+				renderOAuthPage(w, http.StatusOK, "login", page)
+				return
+			case http.MethodPost:
+			default:
+				AllowMethod(http.MethodPost, w, r)
+				return
+			}
+
+			var data LoginRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				page["Error"] = oauthLoginFailed
+				renderOAuthPage(w, http.StatusUnprocessableEntity, "login", page)
+				return
+			}
+			page["Email"] = data.Email
+
+			user, err := login(w, r, data.Email, data.Password, data.OTP)
+			if err != nil {
+				e := apperror.From(err)
+				if e.Status >= http.StatusInternalServerError {
+					WriteError(w, r, err)
+					return
+				}
+				page["Error"] = oauthLoginFailed
+				switch e.Status {
+				case http.StatusForbidden:
+					page["Error"] = oauthLoginInactive
+				case http.StatusTooManyRequests:
+					page["Error"] = oauthLoginBlocked
+				}
+				renderOAuthPage(w, e.Status, "login", page)
+				return
+			}
+
+			session, token, err := sessionRepo().Create(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			http.SetCookie(w, SessionCookie(token, session.Expires))
+			http.Redirect(w, r, next, http.StatusFound)
+		}),
+	}
+
+	// OAuthAuthorizeView is the authorization endpoint. It signs the user
+	// in, asks for consent to scopes not allowed before and sends the
+	// browser back to the client with a code. Only the code flow with PKCE
+	// is supported.
+	OAuthAuthorizeView = View{
+		Route: fmt.Sprintf("%s/authorize", OAuthRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodPost {
+				AllowMethod(http.MethodGet, w, r)
+				return
+			}
+
+			if err := parseOAuthForm(w, r); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			req := parseAuthorize(r)
+			client, err := req.client(r.Context())
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			scope, err := req.scope(client)
+			if err != nil {
+				req.redirectError(w, r, err)
+				return
+			}
+
+			prompts := strings.Fields(req.Prompt)
+			session, err := CurrentSession(w, r)
+			var user models.User
+			if err == nil {
+				user, err = userRepo().Get(r.Context(), session.UserID)
+			}
+			if err != nil || !user.Active {
+				if r.Method == http.MethodPost || slices.Contains(prompts, "none") {
+					req.redirectError(w, r, errLoginRequired)
+					return
+				}
+				login := fmt.Sprintf("%s/login?next=%s", OAuthRouteGroup, url.QueryEscape(r.URL.RequestURI()))
+				http.Redirect(w, r, login, http.StatusFound)
+				return
+			}
+
+			if r.Method == http.MethodPost {
+				if subtle.ConstantTimeCompare([]byte(r.PostForm.Get("consent_token")), []byte(consentToken(r))) != 1 {
+					WriteError(w, r, errInvalidConsentToken)
+					return
+				}
+				if r.PostForm.Get("decision") != "allow" {
+					req.redirectError(w, r, errAccessDenied)
+					return
+				}
+				if err := oauthConsentRepo().Grant(r.Context(), user.ID, client.ClientID, scope); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			} else {
+				consent, err := oauthConsentRepo().Get(r.Context(), user.ID, client.ClientID)
+				if err != nil && !errors.Is(err, models.ErrNotFound) {
+					WriteError(w, r, err)
+					return
+				}
+				if err != nil || !consent.Scope.Contains(scope...) || slices.Contains(prompts, "consent") {
+					if slices.Contains(prompts, "none") {
+						req.redirectError(w, r, errConsentRequired)
+						return
+					}
+					renderOAuthPage(w, http.StatusOK, "consent", map[string]any{
+						"Action": fmt.Sprintf("%s/authorize", OAuthRouteGroup),
+						"Client": client.Name,
+						"Email":  user.Email,
+						"Scope":  scope,
+						"Values": req.Values(),
+						"Token":  consentToken(r),
+					})
+					return
+				}
+			}
+
+			code, err := oauthCodeRepo().Create(r.Context(), models.OAuthCode{
+				ClientID:      client.ClientID,
+				UserID:        user.ID,
+				RedirectURI:   req.RedirectURI,
+				Scope:         scope,
+				Nonce:         req.Nonce,
+				CodeChallenge: req.CodeChallenge,
+				AuthTime:      session.Created,
+			})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			req.redirect(w, r, url.Values{"code": {code}})
+		}),
+	}
+
+	// OAuthTokenView is the token endpoint for the authorization_code,
+	// refresh_token and client_credentials grants.
+	OAuthTokenView = View{
+		Route: fmt.Sprintf("%s/token", OAuthRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			if err := parseOAuthForm(w, r); err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			client, err := authenticateClient(r)
+			if err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			grant := r.PostForm.Get("grant_type")
+			if !slices.Contains(oauthGrantTypes, grant) {
+				writeOAuthError(w, r, errUnsupportedGrant)
+				return
+			}
+			if !client.GrantTypes.Contains(grant) {
+				writeOAuthError(w, r, errUnauthorizedClient)
+				return
+			}
+
+			var response OAuthTokenResponse
+			switch grant {
+			case models.GrantAuthorizationCode:
+				response, err = grantAuthorizationCode(r, client)
+			case models.GrantRefreshToken:
+				response, err = grantRefreshToken(r, client)
+			case models.GrantClientCredentials:
+				response, err = grantClientCredentials(r, client)
+			}
+			if err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			WriteJSON(w, http.StatusOK, response)
+		}),
+	}
+
+	// OAuthUserInfoView returns the claims about the user that the access
+	// token's scope grants.
+	OAuthUserInfoView = View{
+		Route: fmt.Sprintf("%s/userinfo", OAuthRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodPost {
+				AllowMethod(http.MethodGet, w, r)
+				return
+			}
+
+			raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				writeOAuthError(w, r, errInvalidAccessToken)
+				return
+			}
+
+			token, err := oauthTokenRepo().Get(r.Context(), raw)
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				WriteError(w, r, err)
+				return
+			}
+			if err != nil || token.Kind != models.TokenKindAccess || token.UserID == nil || !token.Active() {
+				writeOAuthError(w, r, errInvalidAccessToken)
+				return
+			}
+			if !token.Scope.Contains("openid") {
+				writeOAuthError(w, r, errInsufficientScope)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), *token.UserID)
+			if errors.Is(err, models.ErrNotFound) || err == nil && !user.Active {
+				writeOAuthError(w, r, errInvalidAccessToken)
+				return
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			WriteJSON(w, http.StatusOK, userClaims(user, token.Scope))
+		}),
+	}
+
+	// OAuthIntrospectView is RFC 7662 token introspection, for resource
+	// servers registered as confidential clients.
+	OAuthIntrospectView = View{
+		Route: fmt.Sprintf("%s/introspect", OAuthRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			if err := parseOAuthForm(w, r); err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			client, err := authenticateClient(r)
+			if err == nil && client.Public {
+				err = models.ErrInvalidClient
+			}
+			if err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			if r.PostForm.Get("token") == "" {
+				writeOAuthError(w, r, errOAuthRequest)
+				return
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			token, err := oauthTokenRepo().Get(r.Context(), r.PostForm.Get("token"))
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				WriteError(w, r, err)
+				return
+			}
+			if err != nil || !token.Active() {
+				WriteJSON(w, http.StatusOK, map[string]any{"active": false})
+				return
+			}
+
+			response := map[string]any{
+				"active":     true,
+				"scope":      token.Scope.String(),
+				"client_id":  token.ClientID,
+				"token_type": token.Kind + "_token",
+				"exp":        token.Expires.Unix(),
+				"iat":        token.Created.Unix(),
+				"iss":        BaseURL(),
+			}
+			if token.UserID != nil {
+				user, err := userRepo().Get(r.Context(), *token.UserID)
+				if err != nil || !user.Active {
+					WriteJSON(w, http.StatusOK, map[string]any{"active": false})
+					return
+				}
+				response["sub"] = fmt.Sprint(user.ID)
+				response["username"] = user.Email
+			}
+
+			WriteJSON(w, http.StatusOK, response)
+		}),
+	}
+
+	// OAuthRevokeView is RFC 7009 token revocation. Revoking a refresh
+	// token also revokes the access tokens of the grant. Unknown tokens are
+	// not an error.
+	OAuthRevokeView = View{
+		Route: fmt.Sprintf("%s/revoke", OAuthRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			if err := parseOAuthForm(w, r); err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			client, err := authenticateClient(r)
+			if err != nil {
+				writeOAuthError(w, r, err)
+				return
+			}
+
+			if r.PostForm.Get("token") == "" {
+				writeOAuthError(w, r, errOAuthRequest)
+				return
+			}
+
+			token, err := oauthTokenRepo().Get(r.Context(), r.PostForm.Get("token"))
+			if err == nil && token.ClientID == client.ClientID {
+				if token.Kind == models.TokenKindRefresh && token.UserID != nil {
+					err = oauthTokenRepo().RevokeGrant(r.Context(), client.ClientID, *token.UserID)
+				} else {
+					_, err = oauthTokenRepo().Revoke(r.Context(), token.ID)
+				}
+			}
+			if err != nil && !errors.Is(err, models.ErrNotFound) {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// OAuthClientCreateView registers a client. The secret is only shown in
+	// this response.
+	OAuthClientCreateView = View{
+		Route:       fmt.Sprintf("%s/clients/create", OAuthRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermOAuthClients)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data OAuthClientCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := data.Check(); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			client, secret, err := oauthClientRepo().Create(r.Context(), models.OAuthClient{
+				Name:         data.Name,
+				RedirectURIs: data.RedirectURIs,
+				GrantTypes:   data.GrantTypes,
+				Scopes:       data.Scopes,
+				Public:       data.Public,
+			})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			response := map[string]any{"client": client}
+			if secret != "" {
+				response["client_secret"] = secret
+			}
+			WriteJSON(w, http.StatusOK, response)
+		}),
+	}
+
+	OAuthClientListView = View{
+		Route:       fmt.Sprintf("%s/clients/list", OAuthRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermOAuthClients)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			clients, err := oauthClientRepo().List(r.Context())
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"clients": clients})
+		}),
+	}
+
+	// OAuthClientDeleteView removes a client with its codes, tokens and
+	// consents.
+	OAuthClientDeleteView = View{
+		Route:       fmt.Sprintf("%s/clients/delete/", OAuthRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermOAuthClients)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/clients/delete/", OAuthRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := oauthClientRepo().Delete(r.Context(), id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
@@ -0,0 +1,336 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/oidc"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const (
+	oidcStateCookieName = "oidc_state"
+	oidcOTPCookieName   = "oidc_otp"
+)
+
+var errOIDCDenied = apperror.New(http.StatusBadRequest, "oidc_denied", "sign in with the provider failed")
+
+// oidcProvider returns the provider named by the last path segment.
+func oidcProvider(route string, r *http.Request) (*oidc.Provider, error) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, route), "/")
+	provider, ok := oidc.Providers[name]
+	if !ok {
+		return nil, oidc.ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+func oidcRedirectURI(provider *oidc.Provider) string {
+	return fmt.Sprintf("%s%s/oidc/callback/%s", BaseURL(), UserRouteGroup, provider.Name)
+}
+
+// localRedirect keeps only same-site paths, so the redirect parameter cannot
+// send users to another site after signing in. Browsers drop control
+// characters and read backslashes as slashes in a Location, so a target
+// with either is refused rather than trusted to parse the same way.
+func localRedirect(target string) string {
+	if strings.ContainsFunc(target, func(r rune) bool { return unicode.IsControl(r) || r == '\\' }) {
+		return ""
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return ""
+	}
+	return target
+}
+
+// startOIDC sends the browser to the provider. The state is also put in a
+// cookie so that the callback only completes in the browser that started
+// the sign in.
+func startOIDC(w http.ResponseWriter, r *http.Request, route string, linkUserID *int64) {
+	if !AllowMethod(http.MethodGet, w, r) {
+		return
+	}
+
+	provider, err := oidcProvider(route, r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	s, state, err := oidcStateRepo().Create(r.Context(), provider.Name, localRedirect(r.URL.Query().Get("redirect")), linkUserID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	target, err := provider.AuthCodeURL(r.Context(), oidcRedirectURI(provider), state, s.Nonce, s.Verifier)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     fmt.Sprintf("%s/oidc/callback/", UserRouteGroup),
+		MaxAge:   int(models.OIDCStateDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// signIn starts a session for a user who signed in through a provider.
+func signIn(w http.ResponseWriter, r *http.Request, user models.User, redirect string) {
+	session, token, err := sessionRepo().Create(r.Context(), user.ID)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, SessionCookie(token, session.Expires))
+	if redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]any{"user": user, "session": session})
+}
+
+// requireOIDCOTP holds the sign in of a user with two-factor authentication
+// until UserOIDCOTPView is given a code. The pending sign in is kept in a
+// cookie, so that it only completes in the same browser.
+func requireOIDCOTP(w http.ResponseWriter, r *http.Request, user models.User) {
+	_, token, err := verificationTokenRepo().Create(r.Context(), user.ID, models.PurposeOIDCOTP, models.OIDCOTPTokenDuration)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcOTPCookieName,
+		Value:    token,
+		Path:     fmt.Sprintf("%s/oidc/otp", UserRouteGroup),
+		MaxAge:   int(models.OIDCOTPTokenDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	WriteError(w, r, models.ErrOTPRequired)
+}
+
+var (
+	// UserOIDCLoginView starts signing in with an external provider,
+	// /user/oidc/login/<provider>?redirect=/path.
+	UserOIDCLoginView = View{
+		Route: fmt.Sprintf("%s/oidc/login/", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			startOIDC(w, r, fmt.Sprintf("%s/oidc/login/", UserRouteGroup), nil)
+		}),
+	}
+
+	// UserOIDCLinkView starts linking an external identity to the signed in
+	// user.
+	UserOIDCLinkView = View{
+		Route:       fmt.Sprintf("%s/oidc/link/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := CurrentUser(r)
+			startOIDC(w, r, fmt.Sprintf("%s/oidc/link/", UserRouteGroup), &user.ID)
+		}),
+	}
+
+	// UserOIDCCallbackView is the redirect URI registered with providers. It
+	// finishes the sign in and starts a session, or answers otp_required for
+	// users with two-factor authentication, see UserOIDCOTPView.
+	UserOIDCCallbackView = View{
+		Route: fmt.Sprintf("%s/oidc/callback/", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			provider, err := oidcProvider(fmt.Sprintf("%s/oidc/callback/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			query := r.URL.Query()
+			if e := query.Get("error"); e != "" {
+				WriteError(w, r, errOIDCDenied.Wrap(fmt.Errorf("%s: %s", e, query.Get("error_description"))))
+				return
+			}
+
+			state := query.Get("state")
+			cookie, err := r.Cookie(oidcStateCookieName)
+			if err != nil || state == "" || cookie.Value != state {
+				WriteError(w, r, models.ErrInvalidVerificationToken)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: oidcStateCookieName, Path: fmt.Sprintf("%s/oidc/callback/", UserRouteGroup), MaxAge: -1})
+
+			s, err := oidcStateRepo().Consume(r.Context(), state, provider.Name)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			claims, err := provider.Exchange(r.Context(), oidcRedirectURI(provider), query.Get("code"), s.Verifier, s.Nonce)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			identity := models.UserIdentity{Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+			user, claimed, err := models.SignInWithIdentity(r.Context(), userRepo(), identityRepo(), identity, claims.EmailVerified, s.UserID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if claimed {
+				if err := signOutEverywhere(r.Context(), user.ID); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+			if !user.Active {
+				WriteError(w, r, models.ErrInactiveUser)
+				return
+			}
+
+			// Users with two-factor authentication give a code as after a
+			// password. Linking happens in a session that already did.
+			if s.UserID == nil {
+				err := models.VerifySecondFactor(r.Context(), totpRepo(), recoveryCodeRepo(), user.ID, "")
+				if errors.Is(err, models.ErrOTPRequired) {
+					requireOIDCOTP(w, r, user)
+					return
+				}
+				if err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+
+			signIn(w, r, user, s.Redirect)
+		}),
+	}
+
+	// UserOIDCOTPView completes a sign in through a provider that answered
+	// otp_required, with a TOTP or recovery code. Wrong codes count towards
+	// the lockout of the user like those given with a password.
+	UserOIDCOTPView = View{
+		Route:       fmt.Sprintf("%s/oidc/otp", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data OTPRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			cookie, err := r.Cookie(oidcOTPCookieName)
+			if err != nil {
+				WriteError(w, r, models.ErrInvalidVerificationToken)
+				return
+			}
+			pending, err := verificationTokenRepo().Get(r.Context(), cookie.Value, models.PurposeOIDCOTP)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), pending.UserID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if !user.Active {
+				WriteError(w, r, models.ErrInactiveUser)
+				return
+			}
+
+			if err := checkBlocked(w, r, user.Email); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			err = models.VerifySecondFactor(r.Context(), totpRepo(), recoveryCodeRepo(), user.ID, data.Code)
+			if errors.Is(err, models.ErrInvalidOTP) {
+				failLogin(w, r, user.Email, clientIP(r))
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			// Only the request that uses the token signs in.
+			if _, err := verificationTokenRepo().Consume(r.Context(), cookie.Value, models.PurposeOIDCOTP); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: oidcOTPCookieName, Path: fmt.Sprintf("%s/oidc/otp", UserRouteGroup), MaxAge: -1})
+			if err := loginAttemptRepo().Reset(r.Context(), models.EmailSubject(user.Email)); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			signIn(w, r, user, "")
+		}),
+	}
+
+	UserOIDCIdentitiesView = View{
+		Route:       fmt.Sprintf("%s/oidc/identities", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			identities, err := identityRepo().ListUser(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"identities": identities})
+		}),
+	}
+
+	UserOIDCUnlinkView = View{
+		Route:       fmt.Sprintf("%s/oidc/unlink/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/oidc/unlink/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := identityRepo().Delete(r.Context(), user.ID, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
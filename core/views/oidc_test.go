@@ -0,0 +1,246 @@
+package views
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/oidc"
+	"github.com/immanuel-254/potential-go/core/totp"
+)
+
+// mockProvider registers an identity provider that signs in whoever its
+// email is set to, with an ID token signed by an ES256 key.
+type mockProvider struct {
+	*httptest.Server
+	key   *ecdsa.PrivateKey
+	email string
+	nonce string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "kid": "k1",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": p.idToken()})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	oidc.Providers = map[string]*oidc.Provider{"mock": {
+		Name:     "mock",
+		Issuer:   p.URL,
+		ClientID: "client",
+		Scopes:   []string{"openid", "email"},
+		AuthURL:  p.URL + "/authorize",
+		TokenURL: p.URL + "/token",
+		JWKSURL:  p.URL + "/jwks",
+	}}
+	t.Cleanup(func() { oidc.Providers = map[string]*oidc.Provider{} })
+	return p
+}
+
+func (p *mockProvider) idToken() string {
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+	payload, _ := json.Marshal(map[string]any{
+		"iss": p.URL, "aud": "client", "sub": p.email, "email": p.email, "email_verified": true,
+		"exp": time.Now().Add(time.Hour).Unix(), "nonce": p.nonce,
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// signIn goes through the provider as email and returns the response of
+// the callback.
+func (p *mockProvider) signIn(t *testing.T, email string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := serve(http.MethodGet, "/user/oidc/login/mock", "")
+	if w.Code != http.StatusFound {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	target, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := cookie(w, oidcStateCookieName)
+	if state == nil || state.Value != target.Query().Get("state") {
+		t.Fatalf("login set state cookie %v for %s", state, target)
+	}
+
+	p.email, p.nonce = email, target.Query().Get("nonce")
+	return serve(http.MethodGet, "/user/oidc/callback/mock?code=code&state="+url.QueryEscape(state.Value), "", state)
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	p := newMockProvider(t)
+	email := testEmail("oidc")
+
+	w := p.signIn(t, email)
+	if w.Code != http.StatusOK || cookie(w, SessionCookieName) == nil {
+		t.Fatalf("callback = %d %s", w.Code, w.Body)
+	}
+	if _, err := userRepo().GetByEmail(context.Background(), email); err != nil {
+		t.Errorf("GetByEmail: %v", err)
+	}
+}
+
+func TestOIDCSignInClaimsUnverifiedUser(t *testing.T) {
+	p := newMockProvider(t)
+	ctx := context.Background()
+
+	user, err := userRepo().Create(ctx, models.User{Email: testEmail("squatted"), Password: "password1", Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, session, err := sessionRepo().Create(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := p.signIn(t, user.Email); w.Code != http.StatusOK {
+		t.Fatalf("callback = %d %s", w.Code, w.Body)
+	}
+	if _, err := models.Authenticate(ctx, userRepo(), user.Email, "password1"); err == nil {
+		t.Error("the password of the claimed user still signs in")
+	}
+	if _, err := sessionRepo().Get(ctx, session); err == nil {
+		t.Error("the session of the claimed user is still valid")
+	}
+}
+
+func TestOIDCSignInKeepsDeactivatedUserOff(t *testing.T) {
+	p := newMockProvider(t)
+
+	user, err := userRepo().Create(context.Background(), models.User{Email: testEmail("deactivated"), Password: "password1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := p.signIn(t, user.Email)
+	if w.Code != http.StatusForbidden || cookie(w, SessionCookieName) != nil {
+		t.Fatalf("callback = %d %s, want inactive_user", w.Code, w.Body)
+	}
+	if got, err := userRepo().Get(context.Background(), user.ID); err != nil || got.Active {
+		t.Errorf("Get = active %t, %v, want the user still inactive", got.Active, err)
+	}
+}
+
+func TestOIDCSignInOTP(t *testing.T) {
+	p := newMockProvider(t)
+	ctx := context.Background()
+
+	user, err := userRepo().Create(ctx, models.User{Email: testEmail("otp"), Password: "password1", Active: true, Verified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrolled, err := totpRepo().Enroll(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := totp.Counter(time.Now())
+	if err := totpRepo().Confirm(ctx, user.ID, now-1); err != nil {
+		t.Fatal(err)
+	}
+
+	w := p.signIn(t, user.Email)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), `"otp_required"`) {
+		t.Fatalf("callback = %d %s, want otp_required", w.Code, w.Body)
+	}
+	if cookie(w, SessionCookieName) != nil {
+		t.Fatal("callback started a session before the second factor")
+	}
+	pending := cookie(w, oidcOTPCookieName)
+	if pending == nil {
+		t.Fatal("callback set no otp cookie")
+	}
+
+	if w := serve(http.MethodPost, "/user/oidc/otp", `{"code":"000000"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("otp without the cookie = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, "/user/oidc/otp", `{"code":"not a code"}`, pending); w.Code != http.StatusUnauthorized {
+		t.Errorf("otp with a wrong code = %d %s", w.Code, w.Body)
+	}
+
+	// The wrong code counts against the user like a wrong password.
+	code, err := totp.Code(enrolled.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(http.MethodPost, "/user/oidc/otp", `{"code":"`+code+`"}`, pending); w.Code != http.StatusTooManyRequests {
+		t.Errorf("otp during the backoff = %d %s", w.Code, w.Body)
+	}
+	if err := loginAttemptRepo().Reset(ctx, models.EmailSubject(user.Email)); err != nil {
+		t.Fatal(err)
+	}
+
+	w = serve(http.MethodPost, "/user/oidc/otp", `{"code":"`+code+`"}`, pending)
+	if w.Code != http.StatusOK || cookie(w, SessionCookieName) == nil {
+		t.Fatalf("otp = %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(http.MethodPost, "/user/oidc/otp", `{"code":"`+code+`"}`, pending); w.Code != http.StatusBadRequest {
+		t.Errorf("otp reused = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		target, want string
+	}{
+		{"/", "/"},
+		{"/account?tab=security#top", "/account?tab=security#top"},
+		{"/a//b", "/a//b"},
+		{"", ""},
+		{"account", ""},
+		{"https://evil.example/", ""},
+		{"//evil.example", ""},
+		{"///evil.example", ""},
+		{"/\\evil.example", ""},
+		{"/\\/evil.example", ""},
+		{"/\t/evil.example", ""},
+		{"/\r\n/evil.example", ""},
+		{"/\n/evil.example", ""},
+		{"/\x00/evil.example", ""},
+		{"/\x7f/evil.example", ""},
+		{"/\u0085/evil.example", ""},
+		{"javascript:alert(1)", ""},
+	}
+	for _, tt := range tests {
+		if got := localRedirect(tt.target); got != tt.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
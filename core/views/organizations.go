@@ -0,0 +1,254 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const OrganizationRouteGroup = "/organization"
+
+// checkMemberChange fails unless actor may change or remove the membership
+// of target. Admins manage admins and members; only owners manage owners.
+func checkMemberChange(actor, target models.Member, role string) error {
+	if !models.OrgRoleAtLeast(actor.Role, models.OrgRoleAdmin) {
+		return apperror.ErrForbidden
+	}
+	if actor.Role != models.OrgRoleOwner && (target.Role == models.OrgRoleOwner || role == models.OrgRoleOwner) {
+		return apperror.ErrForbidden.WithField("role", "only owners can manage owners")
+	}
+	return nil
+}
+
+var (
+	// OrganizationCreateView creates an organization owned by the current
+	// user.
+	OrganizationCreateView = View{
+		Route:       fmt.Sprintf("%s/create", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data OrganizationCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := data.Check(); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			organization, err := organizationRepo().Create(r.Context(), models.Organization{Name: data.Name, Slug: data.Slug}, user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"organization": organization})
+		}),
+	}
+
+	// OrganizationListView lists the organizations of the current user.
+	OrganizationListView = View{
+		Route:       fmt.Sprintf("%s/list", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			organizations, err := organizationRepo().ListUser(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"organizations": organizations})
+		}),
+	}
+
+	// OrganizationSwitchView makes an organization of the current user the
+	// active one of their session. Clients without a session send
+	// OrganizationHeader instead.
+	OrganizationSwitchView = View{
+		Route:       fmt.Sprintf("%s/switch", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data OrganizationSwitchRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			session, err := CurrentSession(w, r)
+			if err != nil || session.UserID != user.ID {
+				WriteError(w, r, apperror.New(http.StatusBadRequest, "no_session", fmt.Sprintf("switching needs a session, send %s instead", OrganizationHeader)))
+				return
+			}
+
+			member, err := organizationRepo().Member(r.Context(), data.OrganizationID, user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			organization, err := organizationRepo().Get(r.Context(), data.OrganizationID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			organization.Role = member.Role
+
+			if err := sessionRepo().SetOrganization(r.Context(), session.ID, &organization.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"organization": organization})
+		}),
+	}
+
+	OrganizationCurrentView = View{
+		Route:       fmt.Sprintf("%s/current", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireOrganization(models.OrgRoleMember)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			organization, _, _ := CurrentOrganization(r)
+			WriteJSON(w, http.StatusOK, map[string]any{"organization": organization})
+		}),
+	}
+
+	// OrganizationDeleteView deletes the active organization.
+	OrganizationDeleteView = View{
+		Route:       fmt.Sprintf("%s/delete", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireOrganization(models.OrgRoleOwner)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			organization, _, _ := CurrentOrganization(r)
+			if err := organizationRepo().Delete(r.Context(), organization.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	OrganizationMemberListView = View{
+		Route:       fmt.Sprintf("%s/member/list", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireOrganization(models.OrgRoleMember)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			organization, _, _ := CurrentOrganization(r)
+			members, err := organizationRepo().Members(r.Context(), organization.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"members": members})
+		}),
+	}
+
+	// OrganizationMemberUpdateRoleView changes the role of a member of the
+	// active organization, identified by user id.
+	OrganizationMemberUpdateRoleView = View{
+		Route:       fmt.Sprintf("%s/member/update-role/", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireOrganization(models.OrgRoleAdmin)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPut, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/member/update-role/", OrganizationRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var data MemberRoleRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			organization, actor, _ := CurrentOrganization(r)
+			target, err := organizationRepo().Member(r.Context(), organization.ID, id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := checkMemberChange(actor, target, data.Role); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			member, err := organizationRepo().UpdateMember(r.Context(), organization.ID, id, data.Role)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"member": member})
+		}),
+	}
+
+	// OrganizationMemberRemoveView removes a member of the active
+	// organization. Members can always remove themselves.
+	OrganizationMemberRemoveView = View{
+		Route:       fmt.Sprintf("%s/member/remove/", OrganizationRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireOrganization(models.OrgRoleMember)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/member/remove/", OrganizationRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			organization, actor, _ := CurrentOrganization(r)
+			if id != actor.UserID {
+				target, err := organizationRepo().Member(r.Context(), organization.ID, id)
+				if err != nil {
+					WriteError(w, r, err)
+					return
+				}
+				if err := checkMemberChange(actor, target, target.Role); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+
+			if err := organizationRepo().RemoveMember(r.Context(), organization.ID, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
@@ -0,0 +1,128 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// inOrganization runs a request in the organization id, named by
+// OrganizationHeader.
+func inOrganization(id int64, method, target, body string, c *http.Cookie) *httptest.ResponseRecorder {
+	r := newRequest(method, target, body)
+	r.Header.Set(OrganizationHeader, strconv.FormatInt(id, 10))
+	r.AddCookie(c)
+	return serveRequest(r)
+}
+
+func TestOrganizationMembers(t *testing.T) {
+	ctx := context.Background()
+	owner, ownerCookie := signedIn(t, "org-owner", false)
+	admin, adminCookie := signedIn(t, "org-admin", false)
+	member, memberCookie := signedIn(t, "org-member", false)
+	leaver, leaverCookie := signedIn(t, "org-leaver", false)
+	_, outsiderCookie := signedIn(t, "org-outsider", false)
+
+	if w := serve(http.MethodPost, "/organization/create", `{"name":"Acme","slug":"-acme"}`, ownerCookie); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("create with a bad slug = %d %s", w.Code, w.Body)
+	}
+	slug := fmt.Sprintf("acme-%d", time.Now().UnixNano())
+	w := serve(http.MethodPost, "/organization/create", `{"name":"Acme","slug":"`+slug+`"}`, ownerCookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create = %d %s", w.Code, w.Body)
+	}
+	var created struct {
+		Organization models.Organization `json:"organization"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	id := created.Organization.ID
+
+	for user, role := range map[int64]string{admin.ID: models.OrgRoleAdmin, member.ID: models.OrgRoleMember, leaver.ID: models.OrgRoleMember} {
+		if _, err := organizationRepo().AddMember(ctx, id, user, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The session's active organization stands in for the header.
+	if w := serve(http.MethodGet, "/organization/current", "", ownerCookie); problemCode(w) != "no_organization" {
+		t.Errorf("current before switching = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, "/organization/switch", fmt.Sprintf(`{"organization_id":%d}`, id), ownerCookie); w.Code != http.StatusOK {
+		t.Errorf("switch = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodGet, "/organization/current", "", ownerCookie); w.Code != http.StatusOK {
+		t.Errorf("current after switching = %d %s", w.Code, w.Body)
+	}
+
+	// Outsiders can neither switch to the organization nor name it.
+	if w := serve(http.MethodPost, "/organization/switch", fmt.Sprintf(`{"organization_id":%d}`, id), outsiderCookie); problemCode(w) != "not_member" {
+		t.Errorf("switch of an outsider = %d %s", w.Code, w.Body)
+	}
+	if w := inOrganization(id, http.MethodGet, "/organization/member/list", "", outsiderCookie); problemCode(w) != "not_member" {
+		t.Errorf("member list of an outsider = %d %s", w.Code, w.Body)
+	}
+	if w := inOrganization(id, http.MethodGet, "/organization/member/list", "", memberCookie); w.Code != http.StatusOK {
+		t.Errorf("member list of a member = %d %s", w.Code, w.Body)
+	}
+
+	updateRole := func(c *http.Cookie, userID int64, role string) *httptest.ResponseRecorder {
+		return inOrganization(id, http.MethodPut, fmt.Sprintf("/organization/member/update-role/%d", userID), `{"role":"`+role+`"}`, c)
+	}
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		target int64
+		role   string
+		want   int
+	}{
+		{"member changes a member", memberCookie, leaver.ID, models.OrgRoleAdmin, http.StatusForbidden},
+		{"admin demotes the owner", adminCookie, owner.ID, models.OrgRoleMember, http.StatusForbidden},
+		{"admin promotes to owner", adminCookie, member.ID, models.OrgRoleOwner, http.StatusForbidden},
+		{"unknown role", adminCookie, member.ID, "root", http.StatusUnprocessableEntity},
+		{"admin promotes to admin", adminCookie, member.ID, models.OrgRoleAdmin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := updateRole(tt.cookie, tt.target, tt.role); w.Code != tt.want {
+			t.Errorf("%s = %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+	}
+	if got, err := organizationRepo().Member(ctx, id, owner.ID); err != nil || got.Role != models.OrgRoleOwner {
+		t.Errorf("owner after the refused changes = %+v, %v", got, err)
+	}
+
+	// The last owner can neither step down nor leave.
+	if w := updateRole(ownerCookie, owner.ID, models.OrgRoleAdmin); problemCode(w) != "last_owner" {
+		t.Errorf("last owner steps down = %d %s", w.Code, w.Body)
+	}
+	remove := func(c *http.Cookie, userID int64) *httptest.ResponseRecorder {
+		return inOrganization(id, http.MethodDelete, fmt.Sprintf("/organization/member/remove/%d", userID), "", c)
+	}
+	if w := remove(ownerCookie, owner.ID); problemCode(w) != "last_owner" {
+		t.Errorf("last owner leaves = %d %s", w.Code, w.Body)
+	}
+
+	if w := remove(adminCookie, owner.ID); w.Code != http.StatusForbidden {
+		t.Errorf("admin removes the owner = %d %s", w.Code, w.Body)
+	}
+	if w := remove(leaverCookie, leaver.ID); w.Code != http.StatusOK {
+		t.Errorf("member leaves = %d %s", w.Code, w.Body)
+	}
+	if w := updateRole(ownerCookie, admin.ID, models.OrgRoleOwner); w.Code != http.StatusOK {
+		t.Errorf("owner promotes to owner = %d %s", w.Code, w.Body)
+	}
+	if w := remove(ownerCookie, owner.ID); w.Code != http.StatusOK {
+		t.Errorf("owner leaves beside another owner = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodGet, "/organization/current", "", ownerCookie); problemCode(w) != "no_organization" {
+		t.Errorf("current after leaving = %d %s", w.Code, w.Body)
+	}
+}
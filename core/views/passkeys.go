@@ -0,0 +1,358 @@
+package views
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+	"github.com/immanuel-254/potential-go/core/webauthn"
+)
+
+// b64url decodes the base64url fields of a WebAuthn response, with or
+// without padding.
+func b64url(field, s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, apperror.ErrValidation.WithField(field, "must be base64url")
+	}
+	return b, nil
+}
+
+// userHandle is the opaque user id given to authenticators.
+func userHandle(user models.User) []byte {
+	return []byte(strconv.FormatInt(user.ID, 10))
+}
+
+type credentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+func credentialDescriptors(passkeys []models.Passkey) []credentialDescriptor {
+	descriptors := []credentialDescriptor{}
+	for _, passkey := range passkeys {
+		d := credentialDescriptor{Type: "public-key", ID: base64.RawURLEncoding.EncodeToString(passkey.CredentialID)}
+		if passkey.Transports != "" {
+			d.Transports = strings.Split(passkey.Transports, ",")
+		}
+		descriptors = append(descriptors, d)
+	}
+	return descriptors
+}
+
+// consumeChallenge looks up and uses up the ceremony challenge echoed in the
+// client data.
+func consumeChallenge(r *http.Request, clientDataJSON []byte, purpose string) (string, models.VerificationToken, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return "", models.VerificationToken{}, err
+	}
+
+	token, err := verificationTokenRepo().Consume(r.Context(), challenge, purpose)
+	return challenge, token, err
+}
+
+var (
+	// UserPasskeyRegisterBeginView returns the options for
+	// navigator.credentials.create to add a passkey to the current user.
+	UserPasskeyRegisterBeginView = View{
+		Route:       fmt.Sprintf("%s/passkey/register/begin", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			passkeys, err := passkeyRepo().ListUser(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			_, challenge, err := verificationTokenRepo().Create(r.Context(), user.ID, models.PurposePasskeyRegister, models.PasskeyChallengeDuration)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			params := []map[string]any{}
+			for _, alg := range webauthn.Algorithms {
+				params = append(params, map[string]any{"type": "public-key", "alg": alg})
+			}
+
+			rp := webauthn.Default
+			WriteJSON(w, http.StatusOK, map[string]any{"publicKey": map[string]any{
+				"rp": map[string]any{"id": rp.ID, "name": rp.Name},
+				"user": map[string]any{
+					"id":          base64.RawURLEncoding.EncodeToString(userHandle(user)),
+					"name":        user.Email,
+					"displayName": user.Email,
+				},
+				"challenge":          challenge,
+				"pubKeyCredParams":   params,
+				"timeout":            models.PasskeyChallengeDuration.Milliseconds(),
+				"excludeCredentials": credentialDescriptors(passkeys),
+				// Sign in lists no credentials, so only discoverable ones
+				// can be used there.
+				"authenticatorSelection": map[string]any{
+					"residentKey":        "required",
+					"requireResidentKey": true,
+					"userVerification":   "preferred",
+				},
+				"attestation": "direct",
+			}})
+		}),
+	}
+
+	UserPasskeyRegisterFinishView = View{
+		Route:       fmt.Sprintf("%s/passkey/register/finish", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data PasskeyRegisterRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			clientDataJSON, err := b64url("response.clientDataJSON", data.Response.ClientDataJSON)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			attestationObject, err := b64url("response.attestationObject", data.Response.AttestationObject)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			challenge, token, err := consumeChallenge(r, clientDataJSON, models.PurposePasskeyRegister)
+			if err == nil && token.UserID != user.ID {
+				err = models.ErrInvalidVerificationToken
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			credential, err := webauthn.Default.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			passkey, err := passkeyRepo().Create(r.Context(), models.Passkey{
+				CredentialID: credential.ID,
+				UserID:       user.ID,
+				PublicKey:    credential.PublicKey,
+				SignCount:    int64(credential.SignCount),
+				Transports:   strings.Join(data.Response.Transports, ","),
+				AAGUID:       hex.EncodeToString(credential.AAGUID),
+				Attestation:  credential.Format,
+				Name:         data.Name,
+			})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"passkey": passkey})
+		}),
+	}
+
+	// UserPasskeyLoginBeginView returns the options for
+	// navigator.credentials.get. The allowed credentials are left empty for
+	// the authenticator to offer its discoverable ones, and unknown emails get
+	// a challenge too, so the response does not reveal which accounts exist.
+	UserPasskeyLoginBeginView = View{
+		Route:       fmt.Sprintf("%s/passkey/login/begin", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data EmailRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var challenge string
+			user, err := userRepo().GetByEmail(r.Context(), data.Email)
+			switch {
+			case err == nil:
+				if _, challenge, err = verificationTokenRepo().Create(r.Context(), user.ID, models.PurposePasskeyLogin, models.PasskeyChallengeDuration); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			case errors.Is(err, models.ErrNotFound):
+				if challenge, _, err = models.NewToken(); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			default:
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"publicKey": map[string]any{
+				"challenge":        challenge,
+				"rpId":             webauthn.Default.ID,
+				"timeout":          models.PasskeyChallengeDuration.Milliseconds(),
+				"allowCredentials": []credentialDescriptor{},
+				"userVerification": "preferred",
+			}})
+		}),
+	}
+
+	// UserPasskeyLoginFinishView verifies the assertion and starts a session,
+	// like UserLoginView does for a password.
+	UserPasskeyLoginFinishView = View{
+		Route:       fmt.Sprintf("%s/passkey/login/finish", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data PasskeyLoginRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			fields := map[string]string{
+				"id":                         data.ID,
+				"response.clientDataJSON":    data.Response.ClientDataJSON,
+				"response.authenticatorData": data.Response.AuthenticatorData,
+				"response.signature":         data.Response.Signature,
+				"response.userHandle":        data.Response.UserHandle,
+			}
+			raw := map[string][]byte{}
+			for field, value := range fields {
+				b, err := b64url(field, value)
+				if err != nil {
+					WriteError(w, r, err)
+					return
+				}
+				raw[field] = b
+			}
+
+			challenge, token, err := consumeChallenge(r, raw["response.clientDataJSON"], models.PurposePasskeyLogin)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			passkey, err := passkeyRepo().GetByCredentialID(r.Context(), raw["id"])
+			if errors.Is(err, models.ErrNotFound) || (err == nil && passkey.UserID != token.UserID) {
+				err = webauthn.ErrVerification.Wrap(errors.New("unknown credential"))
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), passkey.UserID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if handle := raw["response.userHandle"]; len(handle) != 0 && !bytes.Equal(handle, userHandle(user)) {
+				WriteError(w, r, webauthn.ErrVerification.Wrap(errors.New("user handle mismatch")))
+				return
+			}
+
+			signCount, err := webauthn.Default.VerifyAssertion(challenge, webauthn.Credential{
+				ID:        passkey.CredentialID,
+				PublicKey: passkey.PublicKey,
+				SignCount: uint32(passkey.SignCount),
+			}, raw["response.clientDataJSON"], raw["response.authenticatorData"], raw["response.signature"])
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := passkeyRepo().Use(r.Context(), passkey.ID, int64(signCount)); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if !user.Verified {
+				WriteError(w, r, models.ErrUnverifiedEmail)
+				return
+			}
+			if !user.Active {
+				WriteError(w, r, models.ErrInactiveUser)
+				return
+			}
+
+			session, sessionToken, err := sessionRepo().Create(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			http.SetCookie(w, SessionCookie(sessionToken, session.Expires))
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user, "session": session})
+		}),
+	}
+
+	UserPasskeyListView = View{
+		Route:       fmt.Sprintf("%s/passkey/list", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			passkeys, err := passkeyRepo().ListUser(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"passkeys": passkeys})
+		}),
+	}
+
+	UserPasskeyDeleteView = View{
+		Route:       fmt.Sprintf("%s/passkey/delete/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/passkey/delete/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := passkeyRepo().Delete(r.Context(), user.ID, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+)
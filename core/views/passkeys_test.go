@@ -0,0 +1,70 @@
+package views
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+func TestPasskeyLoginBeginHidesAccounts(t *testing.T) {
+	user, _ := signedIn(t, "passkey", false)
+	_, err := passkeyRepo().Create(context.Background(), models.Passkey{
+		CredentialID: []byte("credential-" + user.Email),
+		UserID:       user.ID,
+		PublicKey:    []byte("key"),
+		Transports:   "usb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// options returns the options for the email, without the challenge that
+	// differs on every call.
+	options := func(email string) map[string]any {
+		t.Helper()
+		r := newRequest(http.MethodPost, "/user/passkey/login/begin", `{"email":"`+email+`"}`)
+		r.RemoteAddr = "203.0.113.10:1234"
+		w := serveRequest(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("begin for %s = %d %s", email, w.Code, w.Body)
+		}
+
+		var body struct {
+			PublicKey map[string]any `json:"publicKey"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if challenge, _ := body.PublicKey["challenge"].(string); challenge == "" {
+			t.Errorf("begin for %s has no challenge", email)
+		}
+		delete(body.PublicKey, "challenge")
+		return body.PublicKey
+	}
+
+	registered, _ := json.Marshal(options(user.Email))
+	unknown, _ := json.Marshal(options(testEmail("unknown")))
+	if string(registered) != string(unknown) {
+		t.Errorf("begin for a registered email = %s, for an unknown one = %s", registered, unknown)
+	}
+}
+
+func TestPasskeyLoginRateLimit(t *testing.T) {
+	const ip = "203.0.113.11"
+
+	body := `{"email":"` + testEmail("unknown") + `"}`
+	for i := range PasswordRateLimit.Limit {
+		if code := serveFrom(ip, http.MethodPost, "/user/passkey/login/begin", body); code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+	if code := serveFrom(ip, http.MethodPost, "/user/passkey/login/begin", body); code != http.StatusTooManyRequests {
+		t.Errorf("begin over the limit = %d", code)
+	}
+	if code := serveFrom(ip, http.MethodPost, "/user/passkey/login/finish", `{}`); code != http.StatusTooManyRequests {
+		t.Errorf("finish over the limit = %d", code)
+	}
+}
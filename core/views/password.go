@@ -0,0 +1,175 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+// sendPasswordReset replaces any outstanding reset token of the user with a
+// new one and mails its link.
+func sendPasswordReset(ctx context.Context, user models.User) error {
+	if err := verificationTokenRepo().DeleteUser(ctx, user.ID, models.PurposeResetPassword); err != nil {
+		return err
+	}
+
+	_, token, err := verificationTokenRepo().Create(ctx, user.ID, models.PurposeResetPassword, models.ResetPasswordTokenDuration)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s%s/password/reset?token=%s", BaseURL(), UserRouteGroup, url.QueryEscape(token))
+	return mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Choose a new password by opening the link below.\n\n%s\n\nThe link expires in %s. If you did not ask for a reset you can ignore this email.\n", link, models.ResetPasswordTokenDuration),
+	})
+}
+
+var (
+	// UserPasswordForgotView mails a reset link. Like resend-verification it
+	// answers 202 for any address and does the work in the background.
+	UserPasswordForgotView = View{
+		Route:       fmt.Sprintf("%s/password/forgot", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(EmailRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data EmailRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			go func(ctx context.Context) {
+				user, err := userRepo().GetByEmail(ctx, data.Email)
+				if err != nil {
+					return
+				}
+				if err := sendPasswordReset(ctx, user); err != nil {
+					log.Printf("send password reset to %s: %v", user.Email, err)
+				}
+			}(context.WithoutCancel(r.Context()))
+
+			w.WriteHeader(http.StatusAccepted)
+		}),
+	}
+
+	// UserPasswordResetView sets a new password with a reset token and signs
+	// the user out everywhere. Access tokens already issued stay valid until
+	// they expire.
+	UserPasswordResetView = View{
+		Route:       fmt.Sprintf("%s/password/reset", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data ResetPasswordRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			reset, err := verificationTokenRepo().Consume(r.Context(), data.Token, models.PurposeResetPassword)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().UpdatePassword(r.Context(), reset.UserID, data.Password)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := signOutEverywhere(r.Context(), user.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			// The reset link proves who the owner is, so their lockout ends.
+			if err := loginAttemptRepo().Reset(r.Context(), models.EmailSubject(user.Email)); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			ClearSessionCookie(w)
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
+		}),
+	}
+
+	// UserPasswordChangeView lets the signed in user pick a new password
+	// after confirming the current one. Every other session and refresh
+	// token is revoked and the caller gets a fresh session.
+	UserPasswordChangeView = View{
+		Route:       fmt.Sprintf("%s/password/change", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByUser), RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data ChangePasswordRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if !models.CheckPassword(user.Password, data.CurrentPassword) {
+				WriteError(w, r, apperror.ErrValidation.WithField("current_password", "is incorrect"))
+				return
+			}
+
+			user, err := userRepo().UpdatePassword(r.Context(), user.ID, data.Password)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := signOutEverywhere(r.Context(), user.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			session, token, err := sessionRepo().Create(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			http.SetCookie(w, SessionCookie(token, session.Expires))
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user, "session": session})
+		}),
+	}
+)
+
+// signOutEverywhere ends every session, refresh token, OAuth token, API key
+// and outstanding reset token of the user. Scripts need new keys after the
+// password of their user changes.
+func signOutEverywhere(ctx context.Context, userID int64) error {
+	if err := sessionRepo().DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := refreshTokenRepo().RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := oauthTokenRepo().RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := apiKeyRepo().DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+	return verificationTokenRepo().DeleteUser(ctx, userID, models.PurposeResetPassword)
+}
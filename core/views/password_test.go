@@ -0,0 +1,127 @@
+package views
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// recordMailer passes the messages sent on to a channel.
+type recordMailer chan mail.Message
+
+func (m recordMailer) Send(ctx context.Context, msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+// recordMail makes the views send mail to the returned channel for the
+// rest of the test.
+func recordMail(t *testing.T) recordMailer {
+	m := make(recordMailer, 10)
+	mail.Default = m
+	t.Cleanup(func() { mail.Default = discardMailer{} })
+	return m
+}
+
+// to returns the next message sent to the address. Mail sent in the
+// background by earlier tests is skipped.
+func (m recordMailer) to(t *testing.T, address string) mail.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-m:
+			if msg.To == address {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no mail sent to %s", address)
+		}
+	}
+}
+
+// resetToken returns the token of the reset link in a message.
+func resetToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+	_, link, ok := strings.Cut(msg.Body, "/password/reset?")
+	if !ok {
+		t.Fatalf("no reset link in %q", msg.Body)
+	}
+	query, err := url.ParseQuery(strings.Fields(link)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query.Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	const ip = "203.0.113.40"
+	ctx := context.Background()
+	mails := recordMail(t)
+	user, session := signedIn(t, "forgetful", false)
+
+	// Unknown and registered emails get the same answer.
+	unknown := serveFrom(ip, http.MethodPost, "/user/password/forgot", `{"email":"`+testEmail("nobody")+`"}`)
+	registered := serveFrom(ip, http.MethodPost, "/user/password/forgot", `{"email":"`+user.Email+`"}`)
+	if unknown != http.StatusAccepted || registered != http.StatusAccepted {
+		t.Fatalf("forgot = %d for an unknown email, %d for a registered one", unknown, registered)
+	}
+
+	token := resetToken(t, mails.to(t, user.Email))
+
+	// Only the hash of the token is stored.
+	var stored int
+	query := database.DB.Rebind("SELECT COUNT(*) FROM verification_tokens WHERE user_id = ? AND token = ?")
+	if err := database.DB.GetContext(ctx, &stored, query, user.ID, token); err != nil || stored != 0 {
+		t.Errorf("token stored in plaintext: %d rows, %v", stored, err)
+	}
+
+	reset := func(token, password string) *http.Response {
+		body := `{"token":"` + token + `","password":"` + password + `","confirm_password":"` + password + `"}`
+		r := newRequest(http.MethodPost, "/user/password/reset", body)
+		r.RemoteAddr = ip + ":1234"
+		return serveRequest(r).Result()
+	}
+
+	// A weak password is refused without spending the token.
+	if resp := reset(token, "password"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("reset to a weak password = %d", resp.StatusCode)
+	}
+
+	resp := reset(token, "new-password1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reset = %d", resp.StatusCode)
+	}
+	if _, err := models.Authenticate(ctx, userRepo(), user.Email, "new-password1"); err != nil {
+		t.Errorf("Authenticate with the new password: %v", err)
+	}
+	if _, err := sessionRepo().Get(ctx, session.Value); err == nil {
+		t.Error("the session from before the reset is still valid")
+	}
+
+	if resp := reset(token, "other-password1"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("second reset with the token = %d", resp.StatusCode)
+	}
+
+	_, expired, err := verificationTokenRepo().Create(ctx, user.ID, models.PurposeResetPassword, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := reset(expired, "other-password1"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reset with an expired token = %d", resp.StatusCode)
+	}
+	_, verify, err := verificationTokenRepo().Create(ctx, user.ID, models.PurposeVerifyEmail, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := reset(verify, "other-password1"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reset with an email verification token = %d", resp.StatusCode)
+	}
+}
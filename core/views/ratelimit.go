@@ -0,0 +1,92 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/ratelimit"
+)
+
+var (
+	// GlobalRateLimit is applied to every request by main, per client IP.
+	GlobalRateLimit = ratelimit.Policy{Name: "global", Algorithm: ratelimit.SlidingWindow, Limit: 600, Period: time.Minute}
+
+	// PasswordRateLimit guards the views that hash a password on every
+	// call, per client IP. A few attempts in a row are fine, a steady
+	// stream is not.
+	PasswordRateLimit = ratelimit.Policy{Name: "password", Algorithm: ratelimit.TokenBucket, Limit: 10, Period: time.Minute}
+
+	// EmailRateLimit guards the views that mail any address they are given,
+	// per client IP, so that they cannot be used to flood an inbox.
+	EmailRateLimit = ratelimit.Policy{Name: "email", Algorithm: ratelimit.SlidingWindow, Limit: 10, Period: time.Hour}
+
+	// SignupRateLimit guards the creation of accounts, per client IP.
+	SignupRateLimit = ratelimit.Policy{Name: "signup", Algorithm: ratelimit.SlidingWindow, Limit: 5, Period: time.Hour}
+
+	// ExportRateLimit guards the starting of data exports, which read
+	// everything held about a user and mail a link, per current user.
+	ExportRateLimit = ratelimit.Policy{Name: "export", Algorithm: ratelimit.SlidingWindow, Limit: 5, Period: time.Hour}
+)
+
+// RateLimitKey picks the key a request is counted under.
+type RateLimitKey func(r *http.Request) string
+
+// ByIP counts requests per client IP.
+func ByIP(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// ByUser counts requests per current user, and anonymous ones per client
+// IP. The user is only known once a Require* middleware has run, so
+// RateLimit must come before it in View.Middlewares.
+func ByUser(r *http.Request) string {
+	if user, ok := CurrentUser(r); ok {
+		return fmt.Sprintf("user:%d", user.ID)
+	}
+	return ByIP(r)
+}
+
+// ByAPIKey counts requests per API key, and others as ByUser does. The key
+// is only known once AllowAPIKey has run.
+func ByAPIKey(r *http.Request) string {
+	if key, ok := CurrentAPIKey(r); ok {
+		return fmt.Sprintf("api_key:%d", key.ID)
+	}
+	return ByUser(r)
+}
+
+// RateLimit limits the requests counted under key by policy, keeping the
+// counts in ratelimit.Default. Views sharing a policy share its counts.
+// The limit is reported in the RateLimit-* headers, and requests over it
+// fail with 429 and Retry-After. When the store fails the request is let
+// through, so that an outage of the store does not take the views down.
+func RateLimit(policy ratelimit.Policy, key RateLimitKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := policy.Allow(r.Context(), ratelimit.Default, key(r))
+			if err != nil {
+				log.Printf("rate limit %s: %v", policy.Name, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Period.Seconds())))
+
+			if !result.Allowed {
+				setRetryAfter(w, time.Now().Add(result.RetryAfter))
+				WriteError(w, r, ratelimit.ErrRateLimited)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package views
+
+import (
+	"net/http"
+	"testing"
+)
+
+// serveFrom runs a request through the views from the client ip, so that
+// it is counted apart from the requests of other tests.
+func serveFrom(ip, method, target, body string) int {
+	r := newRequest(method, target, body)
+	r.RemoteAddr = ip + ":1234"
+	return serveRequest(r).Code
+}
+
+func TestEmailRateLimit(t *testing.T) {
+	const ip = "203.0.113.1"
+
+	for i := range EmailRateLimit.Limit {
+		body := `{"email":"` + testEmail("limited") + `"}`
+		if code := serveFrom(ip, http.MethodPost, "/user/resend-verification", body); code != http.StatusAccepted {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+
+	// The views that send mail share their counts.
+	body := `{"email":"` + testEmail("limited") + `"}`
+	if code := serveFrom(ip, http.MethodPost, "/user/password/forgot", body); code != http.StatusTooManyRequests {
+		t.Errorf("forgot over the limit = %d", code)
+	}
+	if code := serveFrom(ip, http.MethodPost, "/user/resend-verification", body); code != http.StatusTooManyRequests {
+		t.Errorf("resend-verification over the limit = %d", code)
+	}
+	if code := serveFrom("203.0.113.2", http.MethodPost, "/user/password/forgot", body); code != http.StatusAccepted {
+		t.Errorf("forgot from another client = %d", code)
+	}
+}
+
+func TestOAuthLoginRateLimit(t *testing.T) {
+	const ip = "203.0.113.3"
+
+	for i := range PasswordRateLimit.Limit {
+		if code := serveFrom(ip, http.MethodGet, "/oauth/login", ""); code != http.StatusOK {
+			t.Fatalf("request %d = %d", i+1, code)
+		}
+	}
+	if code := serveFrom(ip, http.MethodPost, "/oauth/login", "email=a%40example.com&password=password1"); code != http.StatusTooManyRequests {
+		t.Errorf("login over the limit = %d", code)
+	}
+}
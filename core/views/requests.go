@@ -0,0 +1,344 @@
+package views
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// Request bodies accepted by the views. They are filled and checked by
+// validate.Bind, see core/validate for the tag rules.
+
+type UserCreateRequest struct {
+	Email           string `json:"email" validate:"required,email,max=254"`
+	Password        string `json:"password" validate:"required,min=8,maxbytes=72,password"`
+	ConfirmPassword string `json:"confirm_password" validate:"required,eqfield=Password"`
+}
+
+// LoginRequest carries OTP, a TOTP or recovery code, for users with
+// two-factor authentication enabled.
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	OTP      string `json:"otp" validate:"max=32"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+type PasswordRequest struct {
+	Password string `json:"password" validate:"required,min=8,maxbytes=72,password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	Password        string `json:"password" validate:"required,min=8,maxbytes=72,password"`
+	ConfirmPassword string `json:"confirm_password" validate:"required,eqfield=Password"`
+}
+
+type ResetPasswordRequest struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required,min=8,maxbytes=72,password"`
+	ConfirmPassword string `json:"confirm_password" validate:"required,eqfield=Password"`
+}
+
+type OTPRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TOTPDisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,max=32"`
+}
+
+// PasskeyRegisterRequest and PasskeyLoginRequest are the credentials
+// returned by navigator.credentials, in the PublicKeyCredential.toJSON()
+// form with binary fields base64url encoded.
+type PasskeyRegisterRequest struct {
+	ID                      string                     `json:"id" validate:"required"`
+	RawID                   string                     `json:"rawId"`
+	Type                    string                     `json:"type" validate:"required,oneof=public-key"`
+	Response                PasskeyAttestationResponse `json:"response" validate:"required"`
+	AuthenticatorAttachment string                     `json:"authenticatorAttachment"`
+	ClientExtensionResults  map[string]any             `json:"clientExtensionResults"`
+	Name                    string                     `json:"name" validate:"max=255"`
+}
+
+type PasskeyAttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+}
+
+type PasskeyLoginRequest struct {
+	ID                      string                   `json:"id" validate:"required"`
+	RawID                   string                   `json:"rawId"`
+	Type                    string                   `json:"type" validate:"required,oneof=public-key"`
+	Response                PasskeyAssertionResponse `json:"response" validate:"required"`
+	AuthenticatorAttachment string                   `json:"authenticatorAttachment"`
+	ClientExtensionResults  map[string]any           `json:"clientExtensionResults"`
+}
+
+type PasskeyAssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+type ActiveRequest struct {
+	Active *bool `json:"active" validate:"required"`
+}
+
+type StaffRequest struct {
+	Staff *bool `json:"staff" validate:"required"`
+}
+
+type AdminRequest struct {
+	Admin *bool `json:"admin" validate:"required"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// UserListRequest is read from the query string of /user/list. Sort names a
+// field, prefixed with - for descending order. Deleted users are only
+// listed with include_deleted.
+type UserListRequest struct {
+	Limit          int        `json:"limit" validate:"min=0,max=200"`
+	Cursor         string     `json:"cursor"`
+	Page           int        `json:"page" validate:"min=0"`
+	Active         *bool      `json:"active"`
+	Staff          *bool      `json:"staff"`
+	Admin          *bool      `json:"admin"`
+	Email          string     `json:"email" validate:"max=254"`
+	CreatedAfter   *time.Time `json:"created_after"`
+	CreatedBefore  *time.Time `json:"created_before"`
+	UpdatedAfter   *time.Time `json:"updated_after"`
+	UpdatedBefore  *time.Time `json:"updated_before"`
+	Sort           string     `json:"sort" validate:"oneof=id -id email -email created -created updated -updated"`
+	Total          bool       `json:"total"`
+	IncludeDeleted bool       `json:"include_deleted"`
+}
+
+func (req UserListRequest) Options() models.UserListOptions {
+	sort, desc := strings.CutPrefix(req.Sort, "-")
+	return models.UserListOptions{
+		Limit:         req.Limit,
+		Cursor:        req.Cursor,
+		Page:          req.Page,
+		Active:        req.Active,
+		Staff:         req.Staff,
+		Admin:         req.Admin,
+		Email:         req.Email,
+		CreatedAfter:  req.CreatedAfter,
+		CreatedBefore: req.CreatedBefore,
+		UpdatedAfter:  req.UpdatedAfter,
+		UpdatedBefore: req.UpdatedBefore,
+		Sort:          sort,
+		Desc:          desc,
+		Total:         req.Total,
+
+		IncludeDeleted: req.IncludeDeleted,
+	}
+}
+
+type TokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// OAuthClientCreateRequest registers an OAuth client. Public clients get no
+// secret and cannot use the client_credentials grant.
+type OAuthClientCreateRequest struct {
+	Name         string   `json:"name" validate:"required,max=100"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types" validate:"required"`
+	Scopes       []string `json:"scopes"`
+	Public       bool     `json:"public"`
+}
+
+// Check validates what the tag rules cannot: the grant types, and redirect
+// uris, which must be absolute without a fragment and are compared exactly.
+func (req OAuthClientCreateRequest) Check() error {
+	for _, grant := range req.GrantTypes {
+		if !slices.Contains(oauthGrantTypes, grant) {
+			return apperror.ErrValidation.WithField("grant_types", "must be authorization_code, refresh_token or client_credentials")
+		}
+	}
+	if req.Public && slices.Contains(req.GrantTypes, models.GrantClientCredentials) {
+		return apperror.ErrValidation.WithField("grant_types", "client_credentials needs a confidential client")
+	}
+	if slices.Contains(req.GrantTypes, models.GrantRefreshToken) && !slices.Contains(req.GrantTypes, models.GrantAuthorizationCode) {
+		return apperror.ErrValidation.WithField("grant_types", "refresh_token needs authorization_code")
+	}
+
+	if slices.Contains(req.GrantTypes, models.GrantAuthorizationCode) && len(req.RedirectURIs) == 0 {
+		return apperror.ErrValidation.WithField("redirect_uris", "is required for authorization_code")
+	}
+	for _, uri := range req.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return apperror.ErrValidation.WithField("redirect_uris", "must be absolute urls without a fragment")
+		}
+	}
+
+	for _, scope := range req.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n\"\\") {
+			return apperror.ErrValidation.WithField("scopes", "must be single words")
+		}
+	}
+	return nil
+}
+
+// APIKeyCreateRequest creates an API key. Users with api_keys.manage can set
+// UserID to create a key for another user.
+type APIKeyCreateRequest struct {
+	Name    string     `json:"name" validate:"required,max=100"`
+	Scopes  []string   `json:"scopes" validate:"required"`
+	Expires *time.Time `json:"expires"`
+	UserID  *int64     `json:"user_id"`
+}
+
+func (req APIKeyCreateRequest) Check() error {
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return apperror.ErrValidation.WithField("scopes", "must be "+strings.Join(models.APIKeyScopes, " or "))
+		}
+	}
+	if req.Expires != nil && !req.Expires.After(time.Now()) {
+		return apperror.ErrValidation.WithField("expires", "must be in the future")
+	}
+	return nil
+}
+
+// APIKeyListRequest is read from the query string of the key list. Only
+// users with api_keys.manage can list the keys of another user.
+type APIKeyListRequest struct {
+	UserID int64 `json:"user_id" validate:"min=0"`
+}
+
+type RoleCreateRequest struct {
+	Name        string   `json:"name" validate:"required,max=100"`
+	Description string   `json:"description" validate:"max=1024"`
+	Permissions []string `json:"permissions"`
+}
+
+func (req RoleCreateRequest) Check() error {
+	return checkName("name", req.Name)
+}
+
+type RoleUpdateRequest struct {
+	Description string   `json:"description" validate:"max=1024"`
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+type PermissionCreateRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=1024"`
+}
+
+func (req PermissionCreateRequest) Check() error {
+	return checkName("name", req.Name)
+}
+
+type RolesRequest struct {
+	Roles []string `json:"roles" validate:"required"`
+}
+
+// checkName accepts role and permission names made of lowercase letters,
+// digits and . _ - :, such as users.read.
+func checkName(field, name string) error {
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.ContainsRune("._-:", c)) {
+			return apperror.ErrValidation.WithField(field, "may only contain lowercase letters, digits and . _ - :")
+		}
+	}
+	return nil
+}
+
+type OrganizationCreateRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	Slug string `json:"slug" validate:"required,min=2,max=100"`
+}
+
+// Check accepts slugs made of lowercase letters, digits and inner hyphens,
+// such as acme-inc.
+func (req OrganizationCreateRequest) Check() error {
+	for _, c := range req.Slug {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+			return apperror.ErrValidation.WithField("slug", "may only contain lowercase letters, digits and -")
+		}
+	}
+	if strings.HasPrefix(req.Slug, "-") || strings.HasSuffix(req.Slug, "-") {
+		return apperror.ErrValidation.WithField("slug", "must not start or end with -")
+	}
+	return nil
+}
+
+type OrganizationSwitchRequest struct {
+	OrganizationID int64 `json:"organization_id" validate:"required"`
+}
+
+type MemberRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner admin member"`
+}
+
+// InvitationCreateRequest invites Email with the platform role Role, and
+// into the organization with OrganizationRole, member by default, when
+// OrganizationID is set. Both are optional.
+type InvitationCreateRequest struct {
+	Email            string `json:"email" validate:"required,email,max=254"`
+	Role             string `json:"role" validate:"max=100"`
+	OrganizationID   *int64 `json:"organization_id"`
+	OrganizationRole string `json:"organization_role" validate:"oneof=owner admin member"`
+}
+
+func (req InvitationCreateRequest) Check() error {
+	if req.OrganizationRole != "" && req.OrganizationID == nil {
+		return apperror.ErrValidation.WithField("organization_role", "needs organization_id")
+	}
+	return nil
+}
+
+type InvitationListRequest struct {
+	OrganizationID *int64 `json:"organization_id"`
+}
+
+// InvitationAcceptRequest sets the password of the account. It is required
+// when the invitation creates the account or links an unverified one, and
+// optional when it links a verified one.
+type InvitationAcceptRequest struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirm_password"`
+}
+
+// AuditListRequest filters the audit log. Format only applies to exports.
+type AuditListRequest struct {
+	ActorID  *int64     `json:"actor_id"`
+	TargetID *int64     `json:"target_id"`
+	Action   string     `json:"action" validate:"max=100"`
+	After    *time.Time `json:"after"`
+	Before   *time.Time `json:"before"`
+	Cursor   int64      `json:"cursor" validate:"min=0"`
+	Limit    int        `json:"limit" validate:"min=0,max=200"`
+	Format   string     `json:"format" validate:"oneof=json csv"`
+}
+
+func (req AuditListRequest) Options() models.AuditListOptions {
+	return models.AuditListOptions{
+		ActorID:  req.ActorID,
+		TargetID: req.TargetID,
+		Action:   req.Action,
+		After:    req.After,
+		Before:   req.Before,
+		Cursor:   req.Cursor,
+		Limit:    req.Limit,
+	}
+}
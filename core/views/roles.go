@@ -0,0 +1,392 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+const RoleRouteGroup = "/role"
+
+// checkHold fails unless user holds every one of permissions, so that
+// roles.manage cannot be used to put permissions in a role one does not
+// have. Admins hold every permission.
+func checkHold(ctx context.Context, user models.User, permissions []string) error {
+	if user.Admin {
+		return nil
+	}
+
+	held, err := roleRepo().UserPermissions(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		if !slices.Contains(held, p) {
+			return apperror.ErrForbidden.WithField("permissions", fmt.Sprintf("you do not hold %q", p))
+		}
+	}
+	return nil
+}
+
+// checkGrant fails unless user may give or take the roles: only admins can
+// grant admin, and others only roles whose permissions they hold.
+func checkGrant(ctx context.Context, user models.User, roles []string) error {
+	if user.Admin {
+		return nil
+	}
+	if slices.Contains(roles, models.RoleAdmin) {
+		return apperror.ErrForbidden.WithField("roles", "only admins can grant admin")
+	}
+
+	permissions, err := roleRepo().Permissions(ctx, roles)
+	if err != nil {
+		return err
+	}
+	return checkHold(ctx, user, permissions)
+}
+
+// checkManage fails unless user may change the email, password, status or
+// second factor of the user id, or delete or restore them: themselves, or a
+// user holding no permission they lack, so that users.update and
+// users.delete cannot be used against a more privileged account. Only
+// admins manage admins.
+func checkManage(ctx context.Context, user models.User, id int64) error {
+	if user.Admin || user.ID == id {
+		return nil
+	}
+
+	target, err := userRepo().Get(ctx, id)
+	if errors.Is(err, models.ErrNotFound) {
+		target, err = userRepo().GetDeleted(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	if target.Admin {
+		return apperror.ErrForbidden.WithField("user", "only admins can manage admins")
+	}
+
+	permissions, err := roleRepo().UserPermissions(ctx, id)
+	if err != nil {
+		return err
+	}
+	return checkHold(ctx, user, permissions)
+}
+
+var (
+	RoleCreateView = View{
+		Route:       fmt.Sprintf("%s/create", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data RoleCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := data.Check(); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkHold(r.Context(), user, data.Permissions); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			role, err := roleRepo().Create(r.Context(), models.Role{Name: data.Name, Description: data.Description, Permissions: data.Permissions})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"role": role})
+		}),
+	}
+
+	RoleListView = View{
+		Route:       fmt.Sprintf("%s/list", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			roles, err := roleRepo().List(r.Context())
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"roles": roles})
+		}),
+	}
+
+	RoleReadView = View{
+		Route:       fmt.Sprintf("%s/read/", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/read/", RoleRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			role, err := roleRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"role": role})
+		}),
+	}
+
+	// RoleUpdateView sets the description and permissions of a role. The
+	// users of the role gain and lose permissions at once.
+	RoleUpdateView = View{
+		Route:       fmt.Sprintf("%s/update/", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPut, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/update/", RoleRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var data RoleUpdateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkHold(r.Context(), user, data.Permissions); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			role, err := roleRepo().Update(r.Context(), id, data.Description, data.Permissions)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"role": role})
+		}),
+	}
+
+	RoleDeleteView = View{
+		Route:       fmt.Sprintf("%s/delete/", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/delete/", RoleRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := roleRepo().Delete(r.Context(), id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	RolePermissionListView = View{
+		Route:       fmt.Sprintf("%s/permission/list", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			permissions, err := permissionRepo().List(r.Context())
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"permissions": permissions})
+		}),
+	}
+
+	// RolePermissionCreateView adds a permission for other applications to
+	// check. Only admins hold it until it is put in a role.
+	RolePermissionCreateView = View{
+		Route:       fmt.Sprintf("%s/permission/create", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data PermissionCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := data.Check(); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			permission, err := permissionRepo().Create(r.Context(), models.Permission{Name: data.Name, Description: data.Description})
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"permission": permission})
+		}),
+	}
+
+	RolePermissionDeleteView = View{
+		Route:       fmt.Sprintf("%s/permission/delete/", RoleRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermRolesManage)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/permission/delete/", RoleRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := permissionRepo().Delete(r.Context(), id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// UserRolesView lists the roles of a user and the permissions they
+	// add up to.
+	UserRolesView = View{
+		Route:       fmt.Sprintf("%s/roles/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireSelfOr(fmt.Sprintf("%s/roles/", UserRouteGroup), models.PermUsersRead), AllowAPIKey(models.ScopeUsersRead)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/roles/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if _, err := userRepo().Get(r.Context(), id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			roles, err := roleRepo().UserRoles(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			permissions, err := roleRepo().UserPermissions(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"roles": roles, "permissions": permissions})
+		}),
+	}
+
+	// UserUpdateRolesView replaces the roles of a user. Roles added or
+	// removed are checked with checkGrant.
+	UserUpdateRolesView = View{
+		Route:       fmt.Sprintf("%s/update-roles/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersRoles)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPut, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/update-roles/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var data RolesRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			current, err := roleRepo().UserRoles(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var changed []string
+			for _, name := range data.Roles {
+				if !slices.ContainsFunc(current, func(role models.Role) bool { return role.Name == name }) {
+					changed = append(changed, name)
+				}
+			}
+			for _, role := range current {
+				if !slices.Contains(data.Roles, role.Name) {
+					changed = append(changed, role.Name)
+				}
+			}
+
+			actor, _ := CurrentUser(r)
+			if err := checkGrant(r.Context(), actor, changed); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := roleRepo().SetUserRoles(r.Context(), id, data.Roles); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			roles, err := roleRepo().UserRoles(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user, "roles": roles})
+		}),
+	}
+)
@@ -0,0 +1,241 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/totp"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+// totpIssuer names the service in authenticator apps, from TOTP_ISSUER.
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "potential-go"
+}
+
+var (
+	// UserTOTPEnrollView starts two-factor setup. The secret and otpauth URI
+	// are shown to the user, 2FA is only on after UserTOTPConfirmView.
+	UserTOTPEnrollView = View{
+		Route:       fmt.Sprintf("%s/2fa/totp/enroll", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			t, err := totpRepo().Enroll(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			WriteJSON(w, http.StatusOK, map[string]any{
+				"secret": t.Secret,
+				"uri":    totp.URI(totpIssuer(), user.Email, t.Secret),
+			})
+		}),
+	}
+
+	// UserTOTPConfirmView turns on 2FA with a first code from the app and
+	// returns the recovery codes, which are not shown again.
+	UserTOTPConfirmView = View{
+		Route:       fmt.Sprintf("%s/2fa/totp/confirm", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data OTPRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			t, err := totpRepo().Get(r.Context(), user.ID)
+			if errors.Is(err, models.ErrNotFound) || (err == nil && t.Confirmed != nil) {
+				WriteError(w, r, models.ErrTOTPNotEnrolled)
+				return
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			counter, ok := totp.Validate(t.Secret, data.Code, time.Now())
+			if !ok {
+				WriteError(w, r, models.ErrInvalidOTP)
+				return
+			}
+
+			if err := totpRepo().Confirm(r.Context(), user.ID, counter); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			codes, err := recoveryCodeRepo().Replace(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			WriteJSON(w, http.StatusOK, map[string]any{"recovery_codes": codes})
+		}),
+	}
+
+	// UserTOTPDisableView turns off 2FA. It asks for the password and a
+	// current code so that an unattended session cannot do it. Wrong ones
+	// count towards the lockout of the user like a failed sign-in.
+	UserTOTPDisableView = View{
+		Route:       fmt.Sprintf("%s/2fa/totp/disable", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByUser), RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data TOTPDisableRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			if err := checkBlocked(w, r, user.Email); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if !models.CheckPassword(user.Password, data.Password) {
+				failLogin(w, r, user.Email, clientIP(r))
+				WriteError(w, r, apperror.ErrValidation.WithField("password", "is incorrect"))
+				return
+			}
+			if err := verifySecondFactor(w, r, user, data.Code); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := resetTwoFactor(r, user.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// UserRecoveryCodesView replaces the recovery codes of a user with 2FA
+	// enabled, after checking a current code. Wrong codes count towards the
+	// lockout of the user like a failed sign-in.
+	UserRecoveryCodesView = View{
+		Route:       fmt.Sprintf("%s/2fa/recovery-codes", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(PasswordRateLimit, ByUser), RequireAuth},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data OTPRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, _ := CurrentUser(r)
+			t, err := totpRepo().Get(r.Context(), user.ID)
+			if errors.Is(err, models.ErrNotFound) || (err == nil && t.Confirmed == nil) {
+				WriteError(w, r, models.ErrTOTPNotEnrolled)
+				return
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := checkBlocked(w, r, user.Email); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+			if err := verifySecondFactor(w, r, user, data.Code); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			codes, err := recoveryCodeRepo().Replace(r.Context(), user.ID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			WriteJSON(w, http.StatusOK, map[string]any{"recovery_codes": codes})
+		}),
+	}
+
+	// UserTwoFactorResetView lets staff turn off 2FA for a user who lost
+	// both their authenticator and recovery codes.
+	UserTwoFactorResetView = View{
+		Route:       fmt.Sprintf("%s/2fa/reset/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersUpdate)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/2fa/reset/", UserRouteGroup), r)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Get(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := resetTwoFactor(r, user.ID); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
+		}),
+	}
+)
+
+// verifySecondFactor checks a code of the signed in user, counting a wrong
+// one as a failed sign-in.
+func verifySecondFactor(w http.ResponseWriter, r *http.Request, user models.User, code string) error {
+	err := models.VerifySecondFactor(r.Context(), totpRepo(), recoveryCodeRepo(), user.ID, code)
+	if errors.Is(err, models.ErrInvalidOTP) {
+		failLogin(w, r, user.Email, clientIP(r))
+	}
+	return err
+}
+
+func resetTwoFactor(r *http.Request, userID int64) error {
+	if err := totpRepo().Delete(r.Context(), userID); err != nil {
+		return err
+	}
+	return recoveryCodeRepo().DeleteUser(r.Context(), userID)
+}
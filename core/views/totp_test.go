@@ -0,0 +1,102 @@
+package views
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/totp"
+)
+
+// enrolled signs in a user with 2FA turned on and returns the current code
+// of their authenticator.
+func enrolled(t *testing.T, name string) (models.User, *http.Cookie, string) {
+	t.Helper()
+	ctx := context.Background()
+
+	user, session := signedIn(t, name, false)
+	enrollment, err := totpRepo().Enroll(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := totp.Counter(time.Now())
+	if err := totpRepo().Confirm(ctx, user.ID, now-1); err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.Code(enrollment.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, session, code
+}
+
+func TestTOTPDisableCountsFailures(t *testing.T) {
+	ctx := context.Background()
+	user, session, code := enrolled(t, "disable")
+	t.Cleanup(func() { loginAttemptRepo().Reset(ctx, models.IPSubject("192.0.2.1")) })
+
+	if w := serve(http.MethodPost, "/user/2fa/totp/disable", `{"password":"wrong","code":"`+code+`"}`, session); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("disable with a wrong password = %d %s", w.Code, w.Body)
+	}
+	// The wrong password blocks the next attempt for the backoff delay.
+	w := serve(http.MethodPost, "/user/2fa/totp/disable", `{"password":"password1","code":"`+code+`"}`, session)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("disable during the backoff = %d %s", w.Code, w.Body)
+	}
+	if err := loginAttemptRepo().Reset(ctx, models.EmailSubject(user.Email)); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(http.MethodPost, "/user/2fa/totp/disable", `{"password":"password1","code":"000000"}`, session); w.Code != http.StatusUnauthorized {
+		t.Fatalf("disable with a wrong code = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, "/user/2fa/totp/disable", `{"password":"password1","code":"`+code+`"}`, session); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("disable after a wrong code = %d %s", w.Code, w.Body)
+	}
+	if err := loginAttemptRepo().Reset(ctx, models.EmailSubject(user.Email)); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(http.MethodPost, "/user/2fa/totp/disable", `{"password":"password1","code":"`+code+`"}`, session); w.Code != http.StatusOK {
+		t.Fatalf("disable = %d %s", w.Code, w.Body)
+	}
+	if _, err := totpRepo().Get(ctx, user.ID); err == nil {
+		t.Error("2FA is still on after disable")
+	}
+}
+
+func TestRecoveryCodesCountsFailures(t *testing.T) {
+	ctx := context.Background()
+	user, session, code := enrolled(t, "recovery")
+	t.Cleanup(func() { loginAttemptRepo().Reset(ctx, models.IPSubject("192.0.2.1")) })
+
+	if w := serve(http.MethodPost, "/user/2fa/recovery-codes", `{"code":"000000"}`, session); w.Code != http.StatusUnauthorized {
+		t.Fatalf("recovery codes with a wrong code = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, "/user/2fa/recovery-codes", `{"code":"`+code+`"}`, session); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("recovery codes during the backoff = %d %s", w.Code, w.Body)
+	}
+	if err := loginAttemptRepo().Reset(ctx, models.EmailSubject(user.Email)); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(http.MethodPost, "/user/2fa/recovery-codes", `{"code":"`+code+`"}`, session); w.Code != http.StatusOK {
+		t.Fatalf("recovery codes = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestTwoFactorViewsRateLimit(t *testing.T) {
+	// Without 2FA the views fail before any code is checked, so only the
+	// rate limit of the user stops them.
+	_, session := signedIn(t, "limited", false)
+
+	for i := range PasswordRateLimit.Limit {
+		if w := serve(http.MethodPost, "/user/2fa/recovery-codes", `{"code":"000000"}`, session); w.Code != http.StatusBadRequest {
+			t.Fatalf("request %d = %d %s", i+1, w.Code, w.Body)
+		}
+	}
+	if w := serve(http.MethodPost, "/user/2fa/totp/disable", `{"password":"password1","code":"000000"}`, session); w.Code != http.StatusTooManyRequests || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("disable over the limit = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,125 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/immanuel-254/potential-go/core/models"
+)
+
+// signedIn creates an active, verified user with roles and returns it with
+// its session cookie.
+func signedIn(t *testing.T, name string, admin bool, roles ...string) (models.User, *http.Cookie) {
+	t.Helper()
+	ctx := context.Background()
+
+	user, err := userRepo().Create(ctx, models.User{Email: testEmail(name), Password: "password1", Active: true, Verified: true, Admin: admin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range roles {
+		if err := roleRepo().GrantUserRole(ctx, user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session, token, err := sessionRepo().Create(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, SessionCookie(token, session.Expires)
+}
+
+func TestUserUpdateRequiresOutranking(t *testing.T) {
+	ctx := context.Background()
+	for name, permissions := range map[string][]string{
+		"support-test": {models.PermUsersUpdate},
+		"auditor-test": {models.PermUsersUpdate, models.PermUsersDelete},
+	} {
+		if _, err := roleRepo().Create(ctx, models.Role{Name: name, Permissions: permissions}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, support := signedIn(t, "support", false, "support-test")
+	admin, _ := signedIn(t, "admin", true)
+	peer, _ := signedIn(t, "peer", false, "support-test")
+	auditor, _ := signedIn(t, "auditor", false, "auditor-test")
+	plain, _ := signedIn(t, "plain", false)
+
+	tests := []struct {
+		method, route, body string
+	}{
+		{http.MethodPut, "update-email", `{"email":"%s"}`},
+		{http.MethodPut, "update-password", `{"password":"password2"}`},
+		{http.MethodPut, "update-active", `{"active":true}`},
+		{http.MethodPost, "unlock", ``},
+		{http.MethodPost, "2fa/reset", ``},
+	}
+	for _, tt := range tests {
+		for _, target := range []struct {
+			user    models.User
+			allowed bool
+		}{{admin, false}, {auditor, false}, {peer, true}, {plain, true}} {
+			body := tt.body
+			if tt.route == "update-email" {
+				body = fmt.Sprintf(body, testEmail("changed"))
+			}
+
+			w := serve(tt.method, fmt.Sprintf("/user/%s/%d", tt.route, target.user.ID), body, support)
+			if target.allowed && w.Code != http.StatusOK || !target.allowed && w.Code != http.StatusForbidden {
+				t.Errorf("%s of %s = %d %s", tt.route, target.user.Email, w.Code, w.Body)
+			}
+		}
+	}
+}
+
+func TestUserDeleteRequiresOutranking(t *testing.T) {
+	ctx := context.Background()
+	for name, permissions := range map[string][]string{
+		"deleter-test":  {models.PermUsersDelete},
+		"deleter2-test": {models.PermUsersDelete, models.PermUsersUpdate},
+	} {
+		if _, err := roleRepo().Create(ctx, models.Role{Name: name, Permissions: permissions}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, deleter := signedIn(t, "deleter", false, "deleter-test")
+	admin, _ := signedIn(t, "admin", true)
+	stronger, _ := signedIn(t, "stronger", false, "deleter2-test")
+	plain, plainSession := signedIn(t, "plain", false)
+
+	for _, target := range []models.User{admin, stronger} {
+		if w := serve(http.MethodDelete, fmt.Sprintf("/user/delete/%d", target.ID), "", deleter); w.Code != http.StatusForbidden {
+			t.Errorf("delete of %s = %d %s", target.Email, w.Code, w.Body)
+		}
+		if _, err := userRepo().Get(ctx, target.ID); err != nil {
+			t.Errorf("Get %s after a refused delete: %v", target.Email, err)
+		}
+	}
+
+	if w := serve(http.MethodDelete, fmt.Sprintf("/user/delete/%d", plain.ID), "", deleter); w.Code != http.StatusOK {
+		t.Fatalf("delete of a plain user = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodGet, "/user/passkey/list", "", plainSession); w.Code != http.StatusUnauthorized {
+		t.Errorf("session of the deleted user = %d", w.Code)
+	}
+	if w := serve(http.MethodPost, fmt.Sprintf("/user/restore/%d", plain.ID), "", deleter); w.Code != http.StatusOK {
+		t.Errorf("restore of a plain user = %d %s", w.Code, w.Body)
+	}
+
+	// A deleted admin is only restored by an admin.
+	_, adminSession := signedIn(t, "admin", true)
+	if err := userRepo().Delete(ctx, admin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(http.MethodPost, fmt.Sprintf("/user/restore/%d", admin.ID), "", deleter); w.Code != http.StatusForbidden {
+		t.Errorf("restore of an admin = %d %s", w.Code, w.Body)
+	}
+	if w := serve(http.MethodPost, fmt.Sprintf("/user/restore/%d", admin.ID), "", adminSession); w.Code != http.StatusOK {
+		t.Errorf("restore of an admin by an admin = %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,166 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
+)
+
+// sendVerification replaces any outstanding verification token of the user
+// with a new one and mails its link.
+func sendVerification(ctx context.Context, user models.User) error {
+	if err := verificationTokenRepo().DeleteUser(ctx, user.ID, models.PurposeVerifyEmail); err != nil {
+		return err
+	}
+
+	_, token, err := verificationTokenRepo().Create(ctx, user.ID, models.PurposeVerifyEmail, models.VerifyEmailTokenDuration)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s%s/verify-email?token=%s", BaseURL(), UserRouteGroup, url.QueryEscape(token))
+	return mail.Default.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Confirm your email address by opening the link below.\n\n%s\n\nThe link expires in %s.\n", link, models.VerifyEmailTokenDuration),
+	})
+}
+
+// sendEmailChange mails a link confirming the pending email of the user to
+// that address. Links sent for earlier changes stop working.
+func sendEmailChange(ctx context.Context, user models.User) error {
+	if err := verificationTokenRepo().DeleteUser(ctx, user.ID, models.PurposeChangeEmail); err != nil {
+		return err
+	}
+
+	_, token, err := verificationTokenRepo().Create(ctx, user.ID, models.PurposeChangeEmail, models.ChangeEmailTokenDuration)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s%s/confirm-email?token=%s", BaseURL(), UserRouteGroup, url.QueryEscape(token))
+	return mail.Default.Send(ctx, mail.Message{
+		To:      *user.PendingEmail,
+		Subject: "Confirm your new email address",
+		Body:    fmt.Sprintf("Confirm that this is the new email address of your account by opening the link below.\n\n%s\n\nThe link expires in %s.\n", link, models.ChangeEmailTokenDuration),
+	})
+}
+
+var (
+	// UserVerifyEmailView consumes a verification token, either from the
+	// link in the email (GET) or posted by a client (POST).
+	UserVerifyEmailView = View{
+		Route: fmt.Sprintf("%s/verify-email", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var data TokenRequest
+			var err error
+			switch r.Method {
+			case http.MethodGet:
+				err = validate.Query(r, &data)
+			default:
+				if !AllowMethod(http.MethodPost, w, r) {
+					return
+				}
+				err = validate.Bind(w, r, &data)
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			verification, err := verificationTokenRepo().Consume(r.Context(), data.Token, models.PurposeVerifyEmail)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Verify(r.Context(), verification.UserID)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
+		}),
+	}
+
+	// UserConfirmEmailView consumes the token mailed for a change of email,
+	// from the link (GET) or posted by a client (POST), and switches the
+	// user to the new address.
+	UserConfirmEmailView = View{
+		Route: fmt.Sprintf("%s/confirm-email", UserRouteGroup),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var data TokenRequest
+			var err error
+			switch r.Method {
+			case http.MethodGet:
+				err = validate.Query(r, &data)
+			default:
+				if !AllowMethod(http.MethodPost, w, r) {
+					return
+				}
+				err = validate.Bind(w, r, &data)
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			verification, err := verificationTokenRepo().Consume(r.Context(), data.Token, models.PurposeChangeEmail)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			// The change was cancelled since the link was sent.
+			user, err := userRepo().ConfirmEmail(r.Context(), verification.UserID)
+			if errors.Is(err, models.ErrNotFound) {
+				err = models.ErrInvalidVerificationToken
+			}
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
+		}),
+	}
+
+	// UserResendVerificationView answers 202 whether or not the email
+	// belongs to an unverified account, so it cannot be used to probe for
+	// registered addresses. The lookup and mail run in the background.
+	UserResendVerificationView = View{
+		Route:       fmt.Sprintf("%s/resend-verification", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(EmailRateLimit, ByIP)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data EmailRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			go func(ctx context.Context) {
+				user, err := userRepo().GetByEmail(ctx, data.Email)
+				if err != nil || user.Verified {
+					return
+				}
+				if err := sendVerification(ctx, user); err != nil {
+					log.Printf("resend verification to %s: %v", user.Email, err)
+				}
+			}(context.WithoutCancel(r.Context()))
+
+			w.WriteHeader(http.StatusAccepted)
+		}),
+	}
+)
@@ -2,10 +2,12 @@ package views
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
-	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/apperror"
 	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/validate"
 )
 
 const UserRouteGroup = "/user"
@@ -18,232 +20,479 @@ type View struct {
 
 var (
 	UserCreateView = View{
-		Route: fmt.Sprintf("%s/create", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/create", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RateLimit(SignupRateLimit, ByIP)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var data map[string]string
-			err := GetData(&data, w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			var data UserCreateRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.Email, user.Password = data["email"], data["password"]
-			err = user.UserCreate(database.DB, data["confirm_password"], w, r)
+			user, err := userRepo().Create(r.Context(), models.User{Email: data.Email, Password: data.Password, Active: true})
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			// The account cannot sign in until the emailed link is opened.
+			// A failed send is logged, the user can ask for a new link.
+			if err := sendVerification(r.Context(), user); err != nil {
+				log.Printf("send verification to %s: %v", user.Email, err)
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserReadView = View{
-		Route: fmt.Sprintf("%s/read/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/read/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireSelfOr(fmt.Sprintf("%s/read/", UserRouteGroup), models.PermUsersRead), AllowAPIKey(models.ScopeUsersRead)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/read/", UserRouteGroup), w, r)
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/read/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			err = user.UserRead(database.DB, w, r)
+			user, err := userRepo().Get(r.Context(), id)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserReadEmailView = View{
-		Route: fmt.Sprintf("%s/read-email", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/read-email", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersRead), AllowAPIKey(models.ScopeUsersRead)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var data map[string]string
-			err := GetData(&data, w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var data EmailRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.Email = data["email"]
-			err = user.UserReadByEmail(database.DB, w, r)
+			user, err := userRepo().GetByEmail(r.Context(), data.Email)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserListView = View{
-		Route: fmt.Sprintf("%s/list", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/list", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersRead), AllowAPIKey(models.ScopeUsersRead)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var user models.User
-			err := user.UserList(database.DB, w, r)
+			if !AllowMethod(http.MethodGet, w, r) {
+				return
+			}
+
+			var query UserListRequest
+			if err := validate.Query(r, &query); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if query.Page > 0 && query.Cursor != "" {
+				WriteError(w, r, apperror.ErrValidation.WithField("cursor", "cannot be combined with page"))
+				return
+			}
+
+			page, err := userRepo().List(r.Context(), query.Options())
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, page)
 		}),
 	}
 
+	// UserUpdateEmailView starts changing a user's email. The current one
+	// stays in use until the link mailed to the new address is opened, see
+	// UserConfirmEmailView.
 	UserUpdateEmailView = View{
-		Route: fmt.Sprintf("%s/update-email/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/update-email/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequireSelfOr(fmt.Sprintf("%s/update-email/", UserRouteGroup), models.PermUsersUpdate)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/update-email/", UserRouteGroup), w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodPut, w, r) {
 				return
 			}
 
-			var data map[string]string
-			err = GetData(&data, w, r)
+			id, err := GetId(fmt.Sprintf("%s/update-email/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var data EmailRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			user.Email = data["email"]
-			err = user.UserUpdateEmail(database.DB, w, r)
+			user, err := userRepo().UpdateEmail(r.Context(), id, data.Email)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			if user.PendingEmail != nil {
+				if err := sendEmailChange(r.Context(), user); err != nil {
+					WriteError(w, r, err)
+					return
+				}
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
+	// UserUpdatePasswordView lets users with users.update set the password
+	// of another user, which signs that user out everywhere. Users change
+	// their own password with UserPasswordChangeView.
 	UserUpdatePasswordView = View{
-		Route: fmt.Sprintf("%s/update-password/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/update-password/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersUpdate)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/update-password/", UserRouteGroup), w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodPut, w, r) {
 				return
 			}
 
-			var data map[string]string
-			err = GetData(&data, w, r)
+			id, err := GetId(fmt.Sprintf("%s/update-password/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			user.Password = data["password"]
-			err = user.UserUpdatePassword(database.DB, w, r)
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			var data PasswordRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().UpdatePassword(r.Context(), id, data.Password)
 			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := signOutEverywhere(r.Context(), user.ID); err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserUpdateActiveView = View{
-		Route: fmt.Sprintf("%s/update-active/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/update-active/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersUpdate), AllowAPIKey(models.ScopeUsersWrite)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/update-active/", UserRouteGroup), w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodPut, w, r) {
 				return
 			}
 
-			var data map[string]string
-			err = GetData(&data, w, r)
+			id, err := GetId(fmt.Sprintf("%s/update-active/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			if data["active"] == "true" {
-				user.Active = true
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
 			}
-			if data["active"] == "false" {
-				user.Active = false
+
+			var data ActiveRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
 			}
-			err = user.UserUpdateActive(database.DB, w, r)
+
+			user, err := userRepo().UpdateActive(r.Context(), id, *data.Active)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
+	// UserUpdateAdminView grants or revokes the built-in admin role. It and
+	// UserUpdateStaffView remain for clients of the old flags, roles are
+	// otherwise set with UserUpdateRolesView.
 	UserUpdateAdminView = View{
-		Route: fmt.Sprintf("%s/update-admin/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/update-admin/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersRoles)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/update-admin/", UserRouteGroup), w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodPut, w, r) {
 				return
 			}
 
-			var data map[string]string
-			err = GetData(&data, w, r)
+			id, err := GetId(fmt.Sprintf("%s/update-admin/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			if data["admin"] == "true" {
-				user.Admin = true
+			var data AdminRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
 			}
-			if data["admin"] == "false" {
-				user.Admin = false
+
+			actor, _ := CurrentUser(r)
+			if err := checkGrant(r.Context(), actor, []string{models.RoleAdmin}); err != nil {
+				WriteError(w, r, err)
+				return
 			}
-			err = user.UserUpdateAdmin(database.DB, w, r)
+
+			user, err := userRepo().UpdateAdmin(r.Context(), id, *data.Admin)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserUpdateStaffView = View{
-		Route: fmt.Sprintf("%s/update-staff/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/update-staff/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersRoles)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/update-staff/", UserRouteGroup), w, r)
-			if err != nil {
+			if !AllowMethod(http.MethodPut, w, r) {
 				return
 			}
 
-			var data map[string]string
-			err = GetData(&data, w, r)
+			id, err := GetId(fmt.Sprintf("%s/update-staff/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			if data["staff"] == "true" {
-				user.Staff = true
+			var data StaffRequest
+			if err := validate.Bind(w, r, &data); err != nil {
+				WriteError(w, r, err)
+				return
 			}
-			if data["staff"] == "false" {
-				user.Staff = false
+
+			actor, _ := CurrentUser(r)
+			if err := checkGrant(r.Context(), actor, []string{models.RoleStaff}); err != nil {
+				WriteError(w, r, err)
+				return
 			}
-			err = user.UserUpdateStaff(database.DB, w, r)
+
+			user, err := userRepo().UpdateStaff(r.Context(), id, *data.Staff)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserDeleteView = View{
-		Route: fmt.Sprintf("%s/delete/", UserRouteGroup),
+		Route:       fmt.Sprintf("%s/delete/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersDelete), AllowAPIKey(models.ScopeUsersWrite)},
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			id, err := GetId(fmt.Sprintf("%s/delete/", UserRouteGroup), w, r)
+			if !AllowMethod(http.MethodDelete, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/delete/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			if err := userRepo().Delete(r.Context(), id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			// A restored user signs in again.
+			if err := signOutEverywhere(r.Context(), id); err != nil {
+				WriteError(w, r, err)
 				return
 			}
 
-			var user models.User
-			user.ID = int64(id)
-			err = user.UserDelete(database.DB, w, r)
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	// UserRestoreView brings back a deleted user who has not been purged
+	// yet.
+	UserRestoreView = View{
+		Route:       fmt.Sprintf("%s/restore/", UserRouteGroup),
+		Middlewares: []func(http.Handler) http.Handler{RequirePermission(models.PermUsersDelete), AllowAPIKey(models.ScopeUsersWrite)},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AllowMethod(http.MethodPost, w, r) {
+				return
+			}
+
+			id, err := GetId(fmt.Sprintf("%s/restore/", UserRouteGroup), r)
 			if err != nil {
+				WriteError(w, r, err)
 				return
 			}
+
+			actor, _ := CurrentUser(r)
+			if err := checkManage(r.Context(), actor, id); err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			user, err := userRepo().Restore(r.Context(), id)
+			if err != nil {
+				WriteError(w, r, err)
+				return
+			}
+
+			WriteJSON(w, http.StatusOK, map[string]any{"user": user})
 		}),
 	}
 
 	UserViews = []View{
+		UserAPIKeyCreateView,
+		UserAPIKeyListView,
+		UserAPIKeyRevokeView,
+		UserConfirmEmailView,
 		UserCreateView,
 		UserDeleteView,
+		UserExportCreateView,
+		UserExportDownloadView,
+		UserExportReadView,
 		UserListView,
+		UserLoginView,
+		UserLogoutAllView,
+		UserLogoutView,
+		UserOIDCCallbackView,
+		UserOIDCIdentitiesView,
+		UserOIDCLinkView,
+		UserOIDCLoginView,
+		UserOIDCOTPView,
+		UserOIDCUnlinkView,
+		UserPasskeyDeleteView,
+		UserPasskeyListView,
+		UserPasskeyLoginBeginView,
+		UserPasskeyLoginFinishView,
+		UserPasskeyRegisterBeginView,
+		UserPasskeyRegisterFinishView,
+		UserPasswordChangeView,
+		UserPasswordForgotView,
+		UserPasswordResetView,
 		UserReadEmailView,
 		UserReadView,
+		UserRecoveryCodesView,
+		UserResendVerificationView,
+		UserRestoreView,
+		UserRolesView,
+		UserTokenRefreshView,
+		UserTokenRevokeView,
+		UserTokenView,
+		UserTOTPConfirmView,
+		UserTOTPDisableView,
+		UserTOTPEnrollView,
+		UserTwoFactorResetView,
+		UserUnlockView,
 		UserUpdateActiveView,
 		UserUpdateAdminView,
 		UserUpdateEmailView,
 		UserUpdatePasswordView,
+		UserUpdateRolesView,
 		UserUpdateStaffView,
+		UserVerifyEmailView,
+	}
+
+	OAuthViews = []View{
+		OAuthAuthorizeView,
+		OAuthClientCreateView,
+		OAuthClientDeleteView,
+		OAuthClientListView,
+		OAuthDiscoveryView,
+		OAuthIntrospectView,
+		OAuthJWKSView,
+		OAuthLoginView,
+		OAuthRevokeView,
+		OAuthTokenView,
+		OAuthUserInfoView,
+	}
+
+	AuditViews = []View{
+		AuditExportView,
+		AuditListView,
+	}
+
+	InvitationViews = []View{
+		InvitationAcceptView,
+		InvitationCreateView,
+		InvitationListView,
+		InvitationResendView,
+		InvitationRevokeView,
+	}
+
+	OrganizationViews = []View{
+		OrganizationCreateView,
+		OrganizationCurrentView,
+		OrganizationDeleteView,
+		OrganizationListView,
+		OrganizationMemberListView,
+		OrganizationMemberRemoveView,
+		OrganizationMemberUpdateRoleView,
+		OrganizationSwitchView,
+	}
+
+	RoleViews = []View{
+		RoleCreateView,
+		RoleDeleteView,
+		RoleListView,
+		RolePermissionCreateView,
+		RolePermissionDeleteView,
+		RolePermissionListView,
+		RoleReadView,
+		RoleUpdateView,
 	}
 )
 
@@ -0,0 +1,102 @@
+package views
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/hasher"
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/webauthn"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The views run against a SQLite database shared by every test, so tests
+// use addresses of their own.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "views")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	database.DB, err = database.Open("sqlite3", filepath.Join(dir, "db.sqlite"))
+	if err != nil {
+		panic(err)
+	}
+	goose.SetLogger(goose.NopLogger())
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		panic(err)
+	}
+	if err := goose.Up(database.DB.DB, database.MigrationsDir("../migrations", "sqlite3")); err != nil {
+		panic(err)
+	}
+
+	hasher.Default = hasher.Bcrypt{Cost: bcrypt.MinCost}
+	mail.Default = discardMailer{}
+	webauthn.Default = &webauthn.RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+	code := m.Run()
+	database.DB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type discardMailer struct{}
+
+func (discardMailer) Send(ctx context.Context, msg mail.Message) error { return nil }
+
+var emailCounter atomic.Int64
+
+func testEmail(name string) string {
+	return fmt.Sprintf("%s-%d-%d@example.com", name, time.Now().UnixNano(), emailCounter.Add(1))
+}
+
+// serve runs a request through the views, sending cookies as a browser
+// would to the request path.
+func serve(method, target, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := newRequest(method, target, body)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	return serveRequest(r)
+}
+
+func newRequest(method, target, body string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+func serveRequest(r *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	for _, views := range [][]View{UserViews, OAuthViews, AuditViews, InvitationViews, OrganizationViews, RoleViews} {
+		Routes(mux, views)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+// cookie returns the cookie named name set by a response.
+func cookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name && c.MaxAge >= 0 {
+			return c
+		}
+	}
+	return nil
+}
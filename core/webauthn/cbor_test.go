@@ -0,0 +1,176 @@
+package webauthn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR encodes the values decodeCBOR produces, for building test
+// input. Map keys are written in sorted order so output is stable.
+func encodeCBOR(v any) []byte {
+	var b bytes.Buffer
+	writeCBOR(&b, v)
+	return b.Bytes()
+}
+
+func writeHead(b *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		b.WriteByte(major<<5 | byte(arg))
+	case arg <= 0xff:
+		b.Write([]byte{major<<5 | 24, byte(arg)})
+	case arg <= 0xffff:
+		b.Write([]byte{major<<5 | 25, byte(arg >> 8), byte(arg)})
+	case arg <= 0xffffffff:
+		b.Write([]byte{major<<5 | 26, byte(arg >> 24), byte(arg >> 16), byte(arg >> 8), byte(arg)})
+	default:
+		b.WriteByte(major<<5 | 27)
+		for i := 7; i >= 0; i-- {
+			b.WriteByte(byte(arg >> (8 * i)))
+		}
+	}
+}
+
+func writeCBOR(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case int:
+		writeCBOR(b, int64(v))
+	case int64:
+		if v < 0 {
+			writeHead(b, 1, uint64(-1-v))
+		} else {
+			writeHead(b, 0, uint64(v))
+		}
+	case []byte:
+		writeHead(b, 2, uint64(len(v)))
+		b.Write(v)
+	case string:
+		writeHead(b, 3, uint64(len(v)))
+		b.WriteString(v)
+	case []any:
+		writeHead(b, 4, uint64(len(v)))
+		for _, item := range v {
+			writeCBOR(b, item)
+		}
+	case map[any]any:
+		keys := make([]any, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		writeHead(b, 5, uint64(len(v)))
+		for _, k := range keys {
+			writeCBOR(b, k)
+			writeCBOR(b, v[k])
+		}
+	case bool:
+		if v {
+			b.WriteByte(0xf5)
+		} else {
+			b.WriteByte(0xf4)
+		}
+	case nil:
+		b.WriteByte(0xf6)
+	default:
+		panic(fmt.Sprintf("encodeCBOR: unsupported type %T", v))
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want any
+	}{
+		{"small int", []byte{0x17}, int64(23)},
+		{"uint8", []byte{0x18, 0x64}, int64(100)},
+		{"uint16", []byte{0x19, 0x03, 0xe8}, int64(1000)},
+		{"uint64", []byte{0x1b, 0, 0, 0, 0xe8, 0xd4, 0xa5, 0x10, 0}, int64(1000000000000)},
+		{"negative", []byte{0x20}, int64(-1)},
+		{"negative uint16", []byte{0x39, 0x01, 0x00}, int64(-257)},
+		{"bytes", []byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{"text", []byte{0x63, 'f', 'o', 'o'}, "foo"},
+		{"array", []byte{0x82, 0x01, 0x61, 'a'}, []any{int64(1), "a"}},
+		{"map", []byte{0xa2, 0x01, 0x02, 0x61, 'k', 0xf5}, map[any]any{int64(1): int64(2), "k": true}},
+		{"tag", []byte{0xc2, 0x41, 0xff}, []byte{0xff}},
+		{"false", []byte{0xf4}, false},
+		{"null", []byte{0xf6}, nil},
+		{"undefined", []byte{0xf7}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, n, err := decodeCBOR(tt.in)
+			if err != nil {
+				t.Fatalf("decodeCBOR: %v", err)
+			}
+			if n != len(tt.in) {
+				t.Errorf("read %d bytes, want %d", n, len(tt.in))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeCBOR = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORTrailingData(t *testing.T) {
+	got, n, err := decodeCBOR([]byte{0x01, 0xff, 0xff})
+	if err != nil || got != int64(1) || n != 1 {
+		t.Errorf("decodeCBOR = %v, %d, %v, want 1, 1, nil", got, n, err)
+	}
+}
+
+func TestDecodeCBORRoundTrip(t *testing.T) {
+	v := map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": bytes.Repeat([]byte{7}, 300),
+		int64(-3):  []any{int64(-70000), int64(1) << 40, "x", nil},
+	}
+	got, _, err := decodeCBOR(encodeCBOR(v))
+	if err != nil {
+		t.Fatalf("decodeCBOR: %v", err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("decodeCBOR = %#v, want %#v", got, v)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	deep := bytes.Repeat([]byte{0x81}, maxCBORDepth+2)
+	deep = append(deep, 0x00)
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", nil},
+		{"truncated argument", []byte{0x19, 0x01}},
+		{"truncated bytes", []byte{0x45, 1, 2}},
+		{"truncated text", []byte{0x62, 'a'}},
+		{"truncated array", []byte{0x83, 0x01, 0x02}},
+		{"truncated map", []byte{0xa1, 0x01}},
+		{"huge byte length", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array length", []byte{0x9b, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{"huge map length", []byte{0xba, 0xff, 0xff, 0xff, 0xff}},
+		{"integer overflow", []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"negative overflow", []byte{0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x01, 0xff}},
+		{"reserved additional info", []byte{0x1c}},
+		{"float", []byte{0xf9, 0x3c, 0x00}},
+		{"bytes map key", []byte{0xa1, 0x41, 0x01, 0x01}},
+		{"duplicate map key", []byte{0xa2, 0x01, 0x01, 0x01, 0x02}},
+		{"nested too deeply", deep},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.in); !errors.Is(err, errCBOR) {
+				t.Errorf("decodeCBOR = %v, want errCBOR", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers offered in pubKeyCredParams, in order of
+// preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var Algorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+var errUnsupportedKey = errors.New("unsupported credential public key")
+
+// coseKey is a parsed COSE_Key (RFC 9052) holding one of the supported
+// public keys.
+type coseKey struct {
+	Alg int64
+	Key crypto.PublicKey
+}
+
+// parseCOSEKey parses the CBOR encoded credential public key.
+func parseCOSEKey(b []byte) (coseKey, error) {
+	v, n, err := decodeCBOR(b)
+	if err != nil {
+		return coseKey{}, err
+	}
+	if n != len(b) {
+		return coseKey{}, fmt.Errorf("%w: trailing data", errCBOR)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return coseKey{}, errUnsupportedKey
+	}
+
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return coseKey{}, errUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return coseKey{}, errUnsupportedKey
+		}
+		return coseKey{Alg: alg, Key: key}, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return coseKey{}, errUnsupportedKey
+		}
+		return coseKey{Alg: alg, Key: ed25519.PublicKey(x)}, nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return coseKey{}, errUnsupportedKey
+		}
+		return coseKey{Alg: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+
+	return coseKey{}, errUnsupportedKey
+}
+
+// verifySignature checks sig over data with key using the COSE algorithm
+// alg. It is shared by credential keys and attestation certificates.
+func verifySignature(alg int64, key crypto.PublicKey, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+
+	ok := false
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		ok = alg == AlgES256 && ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = alg == AlgEdDSA && ed25519.Verify(k, data, sig)
+	case *rsa.PublicKey:
+		ok = alg == AlgRS256 && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	}
+
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func ec2Key(key *ecdsa.PublicKey) map[any]any {
+	return map[any]any{
+		int64(1): int64(2), int64(3): int64(AlgES256), int64(-1): int64(1),
+		int64(-2): key.X.FillBytes(make([]byte, 32)), int64(-3): key.Y.FillBytes(make([]byte, 32)),
+	}
+}
+
+func okpKey(key ed25519.PublicKey) map[any]any {
+	return map[any]any{int64(1): int64(1), int64(3): int64(AlgEdDSA), int64(-1): int64(6), int64(-2): []byte(key)}
+}
+
+func rsaKey(key *rsa.PublicKey) map[any]any {
+	return map[any]any{int64(1): int64(3), int64(3): int64(AlgRS256), int64(-1): key.N.Bytes(), int64(-2): big.NewInt(int64(key.E)).Bytes()}
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ed, _, _ := ed25519.GenerateKey(rand.Reader)
+	rs, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name string
+		key  map[any]any
+		alg  int64
+	}{
+		{"ES256", ec2Key(&ec.PublicKey), AlgES256},
+		{"EdDSA", okpKey(ed), AlgEdDSA},
+		{"RS256", rsaKey(&rs.PublicKey), AlgRS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseCOSEKey(encodeCBOR(tt.key))
+			if err != nil {
+				t.Fatalf("parseCOSEKey: %v", err)
+			}
+			if key.Alg != tt.alg {
+				t.Errorf("alg = %d, want %d", key.Alg, tt.alg)
+			}
+		})
+	}
+}
+
+func TestParseCOSEKeyRejects(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ed, _, _ := ed25519.GenerateKey(rand.Reader)
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	offCurve := ec2Key(&ec.PublicKey)
+	offCurve[int64(-3)] = make([]byte, 32)
+	wrongCurve := ec2Key(&ec.PublicKey)
+	wrongCurve[int64(-1)] = int64(2)
+	shortX := ec2Key(&ec.PublicKey)
+	shortX[int64(-2)] = make([]byte, 31)
+	wrongAlg := ec2Key(&ec.PublicKey)
+	wrongAlg[int64(3)] = int64(AlgRS256)
+	shortEd := okpKey(ed[:31])
+
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"off curve", encodeCBOR(offCurve)},
+		{"wrong curve", encodeCBOR(wrongCurve)},
+		{"short coordinate", encodeCBOR(shortX)},
+		{"alg of another key type", encodeCBOR(wrongAlg)},
+		{"short ed25519 key", encodeCBOR(shortEd)},
+		{"small rsa modulus", encodeCBOR(rsaKey(&small.PublicKey))},
+		{"not a map", encodeCBOR([]any{int64(1)})},
+		{"empty map", encodeCBOR(map[any]any{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseCOSEKey(tt.in); !errors.Is(err, errUnsupportedKey) {
+				t.Errorf("parseCOSEKey = %v, want errUnsupportedKey", err)
+			}
+		})
+	}
+
+	trailing := append(encodeCBOR(ec2Key(&ec.PublicKey)), 0x00)
+	if _, err := parseCOSEKey(trailing); !errors.Is(err, errCBOR) {
+		t.Errorf("parseCOSEKey with trailing data = %v, want errCBOR", err)
+	}
+	if _, err := parseCOSEKey([]byte{0xa5, 0x01}); !errors.Is(err, errCBOR) {
+		t.Errorf("parseCOSEKey truncated = %v, want errCBOR", err)
+	}
+}
+
+func TestVerifySignatureAlgorithmMismatch(t *testing.T) {
+	ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ed, priv, _ := ed25519.GenerateKey(rand.Reader)
+	data := []byte("signed data")
+
+	sig := ed25519.Sign(priv, data)
+	if err := verifySignature(AlgEdDSA, ed, data, sig); err != nil {
+		t.Errorf("verifySignature: %v", err)
+	}
+	if err := verifySignature(AlgES256, ed, data, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verifySignature with another alg = %v, want ErrInvalidSignature", err)
+	}
+	if err := verifySignature(AlgES256, &ec.PublicKey, data, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("verifySignature with another key = %v, want ErrInvalidSignature", err)
+	}
+}
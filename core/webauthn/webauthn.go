@@ -0,0 +1,345 @@
+// Package webauthn verifies passkey registration and login ceremonies
+// (WebAuthn Level 2) for a single relying party. It is independent of
+// storage and HTTP: callers issue the challenge, keep it, and pass it back
+// in with the browser's response, so ceremonies can be driven by software
+// authenticators as well as real ones.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/immanuel-254/potential-go/core/apperror"
+)
+
+var (
+	ErrVerification     = apperror.New(http.StatusBadRequest, "webauthn_failed", "passkey verification failed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrClonedCredential = errors.New("signature counter did not increase, the authenticator may be cloned")
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// RelyingParty is the site passkeys are bound to. ID is a registrable domain
+// (the host without scheme or port), Origins the exact origins the browser
+// may report.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	// RequireUserVerification rejects authenticators that did not check a
+	// PIN or biometric, not just presence.
+	RequireUserVerification bool
+}
+
+// Default is the relying party built from the environment at startup.
+var Default *RelyingParty
+
+// FromEnv reads WEBAUTHN_ORIGINS (comma separated, defaulting to BASE_URL or
+// http://localhost:$PORT), WEBAUTHN_RP_ID (defaulting to the host of the
+// first origin), WEBAUTHN_RP_NAME and WEBAUTHN_REQUIRE_UV.
+func FromEnv() (*RelyingParty, error) {
+	origins := os.Getenv("WEBAUTHN_ORIGINS")
+	if origins == "" {
+		origins = os.Getenv("BASE_URL")
+	}
+	if origins == "" {
+		origins = fmt.Sprintf("http://localhost:%s", os.Getenv("PORT"))
+	}
+
+	rp := &RelyingParty{
+		ID:                      os.Getenv("WEBAUTHN_RP_ID"),
+		Name:                    os.Getenv("WEBAUTHN_RP_NAME"),
+		RequireUserVerification: os.Getenv("WEBAUTHN_REQUIRE_UV") == "true",
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		rp.Origins = append(rp.Origins, strings.TrimRight(strings.TrimSpace(origin), "/"))
+	}
+
+	if rp.ID == "" {
+		u, err := url.Parse(rp.Origins[0])
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid webauthn origin %q", rp.Origins[0])
+		}
+		rp.ID = u.Hostname()
+	}
+	if rp.Name == "" {
+		rp.Name = "potential-go"
+	}
+
+	return rp, nil
+}
+
+// Credential is a registered passkey as it needs to be stored.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	SignCount uint32
+	AAGUID    []byte
+	Format    string
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+func fail(format string, args ...any) error {
+	return ErrVerification.Wrap(fmt.Errorf(format, args...))
+}
+
+func parseAuthenticatorData(b []byte) (authenticatorData, error) {
+	if len(b) < 37 {
+		return authenticatorData{}, fail("authenticator data too short")
+	}
+
+	ad := authenticatorData{RPIDHash: b[:32], Flags: b[32], SignCount: binary.BigEndian.Uint32(b[33:37])}
+	if ad.Flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := b[37:]
+	if len(rest) < 18 {
+		return ad, fail("attested credential data too short")
+	}
+	ad.AAGUID = rest[:16]
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || n > 1023 || len(rest) < n {
+		return ad, fail("invalid credential id length")
+	}
+	ad.CredentialID, rest = rest[:n], rest[n:]
+
+	// The key is followed by extension data when the ED flag is set, so its
+	// length comes from decoding it.
+	_, keyLen, err := decodeCBOR(rest)
+	if err != nil {
+		return ad, fail("credential public key: %w", err)
+	}
+	ad.PublicKey = rest[:keyLen]
+
+	return ad, nil
+}
+
+// Challenge returns the challenge recorded in a response's client data, so
+// that the caller can look up the ceremony it belongs to before verifying.
+func Challenge(clientDataJSON []byte) (string, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil || cd.Challenge == "" {
+		return "", fail("client data has no challenge")
+	}
+	return cd.Challenge, nil
+}
+
+// checkClientData verifies the ceremony type, challenge and origin that the
+// browser recorded, and returns the hash the authenticator signed over.
+func (rp *RelyingParty) checkClientData(raw []byte, typ, challenge string) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fail("client data: %w", err)
+	}
+	if cd.Type != typ {
+		return nil, fail("client data type is %q, want %q", cd.Type, typ)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return nil, fail("challenge mismatch")
+	}
+	if cd.CrossOrigin || !slices.Contains(rp.Origins, cd.Origin) {
+		return nil, fail("origin %q is not allowed", cd.Origin)
+	}
+
+	sum := sha256.Sum256(raw)
+	return sum[:], nil
+}
+
+func (rp *RelyingParty) checkAuthenticatorData(ad authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.RPIDHash, rpIDHash[:]) {
+		return fail("credential is for another relying party")
+	}
+	if ad.Flags&flagUserPresent == 0 {
+		return fail("user was not present")
+	}
+	if rp.RequireUserVerification && ad.Flags&flagUserVerified == 0 {
+		return fail("user was not verified")
+	}
+	return nil
+}
+
+// VerifyRegistration checks the response to a navigator.credentials.create
+// call made with challenge, the base64url string sent to the browser, and
+// returns the new credential. Attestation formats none and packed are
+// accepted; packed certificates are checked but not chained to a root.
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (Credential, error) {
+	clientDataHash, err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	v, n, err := decodeCBOR(attestationObject)
+	if err != nil || n != len(attestationObject) {
+		return Credential{}, fail("attestation object is not valid CBOR")
+	}
+	obj, _ := v.(map[any]any)
+	format, _ := obj["fmt"].(string)
+	stmt, _ := obj["attStmt"].(map[any]any)
+	rawAuthData, _ := obj["authData"].([]byte)
+	if stmt == nil || rawAuthData == nil {
+		return Credential{}, fail("attestation object is incomplete")
+	}
+
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := rp.checkAuthenticatorData(ad); err != nil {
+		return Credential{}, err
+	}
+	if ad.Flags&flagAttested == 0 {
+		return Credential{}, fail("no attested credential data")
+	}
+
+	key, err := parseCOSEKey(ad.PublicKey)
+	if err != nil {
+		return Credential{}, ErrVerification.Wrap(err)
+	}
+
+	switch format {
+	case "none":
+		if len(stmt) != 0 {
+			return Credential{}, fail("none attestation has a statement")
+		}
+	case "packed":
+		signed := slices.Concat(rawAuthData, clientDataHash)
+		if err := verifyPacked(stmt, ad, signed, key); err != nil {
+			return Credential{}, ErrVerification.Wrap(err)
+		}
+	default:
+		return Credential{}, fail("unsupported attestation format %q", format)
+	}
+
+	return Credential{
+		ID:        ad.CredentialID,
+		PublicKey: ad.PublicKey,
+		SignCount: ad.SignCount,
+		AAGUID:    ad.AAGUID,
+		Format:    format,
+	}, nil
+}
+
+var oidFIDOAAGUID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 45724, 1, 1, 4}
+
+// verifyPacked checks a packed attestation statement (WebAuthn §8.2), either
+// self attestation signed by the credential key or full attestation signed
+// by the x5c leaf certificate.
+func verifyPacked(stmt map[any]any, ad authenticatorData, signed []byte, key coseKey) error {
+	alg, ok := stmt["alg"].(int64)
+	sig, _ := stmt["sig"].([]byte)
+	if !ok || sig == nil {
+		return errors.New("packed statement needs alg and sig")
+	}
+
+	x5c, ok := stmt["x5c"].([]any)
+	if !ok {
+		if alg != key.Alg {
+			return errors.New("self attestation alg does not match the credential key")
+		}
+		return verifySignature(alg, key.Key, signed, sig)
+	}
+
+	if len(x5c) == 0 {
+		return errors.New("empty x5c")
+	}
+	der, _ := x5c[0].([]byte)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(alg, cert.PublicKey, signed, sig); err != nil {
+		return err
+	}
+
+	if cert.Version != 3 || !slices.Contains(cert.Subject.OrganizationalUnit, "Authenticator Attestation") ||
+		len(cert.Subject.Country) == 0 || len(cert.Subject.Organization) == 0 || cert.Subject.CommonName == "" {
+		return errors.New("attestation certificate subject does not meet the packed requirements")
+	}
+	if !cert.BasicConstraintsValid || cert.IsCA {
+		return errors.New("attestation certificate must not be a CA")
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidFIDOAAGUID) {
+			continue
+		}
+		var aaguid []byte
+		if ext.Critical {
+			return errors.New("aaguid extension must not be critical")
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &aaguid); err != nil || !bytes.Equal(aaguid, ad.AAGUID) {
+			return errors.New("attestation certificate aaguid does not match")
+		}
+	}
+
+	return nil
+}
+
+// VerifyAssertion checks the response to a navigator.credentials.get call
+// made with challenge against the stored credential, and returns the new
+// signature counter to store.
+func (rp *RelyingParty) VerifyAssertion(challenge string, credential Credential, clientDataJSON, authenticatorDataRaw, signature []byte) (uint32, error) {
+	clientDataHash, err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(authenticatorDataRaw)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.checkAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+
+	key, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return 0, ErrVerification.Wrap(err)
+	}
+	if err := verifySignature(key.Alg, key.Key, slices.Concat(authenticatorDataRaw, clientDataHash), signature); err != nil {
+		return 0, ErrVerification.Wrap(err)
+	}
+
+	// Authenticators that keep a counter must increase it on every use.
+	// Passkeys synced between devices report 0 and are exempt.
+	if (ad.SignCount != 0 || credential.SignCount != 0) && ad.SignCount <= credential.SignCount {
+		return 0, ErrVerification.Wrap(ErrClonedCredential)
+	}
+
+	return ad.SignCount, nil
+}
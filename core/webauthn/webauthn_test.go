@@ -0,0 +1,343 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+const (
+	testOrigin    = "https://example.com"
+	testChallenge = "c2VydmVyLWNoYWxsZW5nZQ"
+)
+
+func testRP() *RelyingParty {
+	return &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{testOrigin}}
+}
+
+// softAuthenticator plays the part of a security key, so ceremonies can be
+// tested without hardware.
+type softAuthenticator struct {
+	id     []byte
+	aaguid []byte
+	signer crypto.Signer
+	alg    int64
+	count  uint32
+	rpID   string
+	flags  byte
+}
+
+func newSoftAuthenticator(t *testing.T, alg int64) *softAuthenticator {
+	t.Helper()
+
+	a := &softAuthenticator{id: make([]byte, 16), aaguid: make([]byte, 16), alg: alg, rpID: "example.com", flags: flagUserPresent | flagUserVerified}
+	rand.Read(a.id)
+	rand.Read(a.aaguid)
+
+	switch alg {
+	case AlgES256:
+		a.signer, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, a.signer, _ = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported alg %d", alg)
+	}
+	return a
+}
+
+func (a *softAuthenticator) publicKey() []byte {
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR(ec2Key(key))
+	case ed25519.PublicKey:
+		return encodeCBOR(okpKey(key))
+	}
+	return nil
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	b := slices.Concat(rpIDHash[:], []byte{a.flags}, binary.BigEndian.AppendUint32(nil, a.count))
+	if !attested {
+		return b
+	}
+	b[32] |= flagAttested
+	b = slices.Concat(b, a.aaguid, binary.BigEndian.AppendUint16(nil, uint16(len(a.id))), a.id)
+	return append(b, a.publicKey()...)
+}
+
+func sign(signer crypto.Signer, data []byte) []byte {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		sig, _ := signer.Sign(rand.Reader, data, crypto.Hash(0))
+		return sig
+	}
+	digest := sha256.Sum256(data)
+	sig, _ := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	return sig
+}
+
+func clientDataJSON(typ, challenge, origin string) []byte {
+	b, _ := json.Marshal(clientData{Type: typ, Challenge: challenge, Origin: origin})
+	return b
+}
+
+// create answers a registration with the attestation format and statement
+// built by attest from the signed data.
+func (a *softAuthenticator) create(challenge, origin, format string, attest func(signed []byte) map[any]any) (clientData, attestationObject []byte) {
+	clientData = clientDataJSON("webauthn.create", challenge, origin)
+	authData := a.authData(true)
+	hash := sha256.Sum256(clientData)
+
+	stmt := map[any]any{}
+	if attest != nil {
+		stmt = attest(slices.Concat(authData, hash[:]))
+	}
+	return clientData, encodeCBOR(map[any]any{"fmt": format, "attStmt": stmt, "authData": authData})
+}
+
+// get answers an authentication, increasing the counter.
+func (a *softAuthenticator) get(challenge, origin string) (clientData, authData, signature []byte) {
+	a.count++
+	clientData = clientDataJSON("webauthn.get", challenge, origin)
+	authData = a.authData(false)
+	hash := sha256.Sum256(clientData)
+	return clientData, authData, sign(a.signer, slices.Concat(authData, hash[:]))
+}
+
+func (a *softAuthenticator) selfAttestation(signed []byte) map[any]any {
+	return map[any]any{"alg": a.alg, "sig": sign(a.signer, signed)}
+}
+
+// attestationCertificate makes a packed attestation certificate for aaguid
+// with its own key, self-signed as no chain is checked.
+func attestationCertificate(t *testing.T, aaguid []byte, ou string) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ext, _ := asn1.Marshal(aaguid)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Country: []string{"US"}, Organization: []string{"Test Vendor"}, OrganizationalUnit: []string{ou}, CommonName: "Test Key"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		ExtraExtensions:       []pkix.Extension{{Id: oidFIDOAAGUID, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, der
+}
+
+func TestRegistrationNone(t *testing.T) {
+	for _, alg := range []int64{AlgES256, AlgEdDSA} {
+		a := newSoftAuthenticator(t, alg)
+		clientData, attestation := a.create(testChallenge, testOrigin, "none", nil)
+
+		credential, err := testRP().VerifyRegistration(testChallenge, clientData, attestation)
+		if err != nil {
+			t.Fatalf("alg %d: VerifyRegistration: %v", alg, err)
+		}
+		if !slices.Equal(credential.ID, a.id) || !slices.Equal(credential.AAGUID, a.aaguid) || credential.Format != "none" {
+			t.Errorf("alg %d: credential = %+v", alg, credential)
+		}
+		if !slices.Equal(credential.PublicKey, a.publicKey()) {
+			t.Errorf("alg %d: public key was not kept", alg)
+		}
+	}
+}
+
+func TestRegistrationPackedSelf(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgES256)
+	clientData, attestation := a.create(testChallenge, testOrigin, "packed", a.selfAttestation)
+
+	credential, err := testRP().VerifyRegistration(testChallenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if credential.Format != "packed" {
+		t.Errorf("format = %q, want packed", credential.Format)
+	}
+}
+
+func TestRegistrationPackedFull(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgEdDSA)
+
+	full := func(aaguid []byte, ou string) func(signed []byte) map[any]any {
+		key, der := attestationCertificate(t, aaguid, ou)
+		return func(signed []byte) map[any]any {
+			return map[any]any{"alg": int64(AlgES256), "sig": sign(key, signed), "x5c": []any{der}}
+		}
+	}
+
+	clientData, attestation := a.create(testChallenge, testOrigin, "packed", full(a.aaguid, "Authenticator Attestation"))
+	if _, err := testRP().VerifyRegistration(testChallenge, clientData, attestation); err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+
+	clientData, attestation = a.create(testChallenge, testOrigin, "packed", full(make([]byte, 16), "Authenticator Attestation"))
+	if _, err := testRP().VerifyRegistration(testChallenge, clientData, attestation); !errors.Is(err, ErrVerification) {
+		t.Errorf("VerifyRegistration with another aaguid = %v, want ErrVerification", err)
+	}
+
+	clientData, attestation = a.create(testChallenge, testOrigin, "packed", full(a.aaguid, "Marketing"))
+	if _, err := testRP().VerifyRegistration(testChallenge, clientData, attestation); !errors.Is(err, ErrVerification) {
+		t.Errorf("VerifyRegistration with a bad subject = %v, want ErrVerification", err)
+	}
+}
+
+func TestRegistrationRejects(t *testing.T) {
+	rp := testRP()
+
+	tests := []struct {
+		name  string
+		setup func(a *softAuthenticator) (clientData, attestation []byte)
+	}{
+		{"wrong challenge", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create("b3RoZXI", testOrigin, "none", nil)
+		}},
+		{"wrong origin", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create(testChallenge, "https://evil.example", "none", nil)
+		}},
+		{"wrong type", func(a *softAuthenticator) ([]byte, []byte) {
+			_, attestation := a.create(testChallenge, testOrigin, "none", nil)
+			return clientDataJSON("webauthn.get", testChallenge, testOrigin), attestation
+		}},
+		{"wrong relying party", func(a *softAuthenticator) ([]byte, []byte) {
+			a.rpID = "evil.example"
+			return a.create(testChallenge, testOrigin, "none", nil)
+		}},
+		{"user not present", func(a *softAuthenticator) ([]byte, []byte) {
+			a.flags = 0
+			return a.create(testChallenge, testOrigin, "none", nil)
+		}},
+		{"none with a statement", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create(testChallenge, testOrigin, "none", a.selfAttestation)
+		}},
+		{"packed with a bad signature", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create(testChallenge, testOrigin, "packed", func(signed []byte) map[any]any {
+				return a.selfAttestation([]byte("something else"))
+			})
+		}},
+		{"packed with another alg", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create(testChallenge, testOrigin, "packed", func(signed []byte) map[any]any {
+				return map[any]any{"alg": int64(AlgEdDSA), "sig": sign(a.signer, signed)}
+			})
+		}},
+		{"unsupported format", func(a *softAuthenticator) ([]byte, []byte) {
+			return a.create(testChallenge, testOrigin, "tpm", nil)
+		}},
+		{"malformed attestation", func(a *softAuthenticator) ([]byte, []byte) {
+			clientData, attestation := a.create(testChallenge, testOrigin, "none", nil)
+			return clientData, attestation[:len(attestation)-5]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientData, attestation := tt.setup(newSoftAuthenticator(t, AlgES256))
+			if _, err := rp.VerifyRegistration(testChallenge, clientData, attestation); !errors.Is(err, ErrVerification) {
+				t.Errorf("VerifyRegistration = %v, want ErrVerification", err)
+			}
+		})
+	}
+}
+
+func TestRegistrationRequiresUserVerification(t *testing.T) {
+	rp := testRP()
+	rp.RequireUserVerification = true
+
+	a := newSoftAuthenticator(t, AlgES256)
+	a.flags = flagUserPresent
+	clientData, attestation := a.create(testChallenge, testOrigin, "none", nil)
+	if _, err := rp.VerifyRegistration(testChallenge, clientData, attestation); !errors.Is(err, ErrVerification) {
+		t.Errorf("VerifyRegistration without UV = %v, want ErrVerification", err)
+	}
+}
+
+func register(t *testing.T, a *softAuthenticator) Credential {
+	t.Helper()
+	clientData, attestation := a.create(testChallenge, testOrigin, "none", nil)
+	credential, err := testRP().VerifyRegistration(testChallenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	return credential
+}
+
+func TestAssertion(t *testing.T) {
+	for _, alg := range []int64{AlgES256, AlgEdDSA} {
+		a := newSoftAuthenticator(t, alg)
+		credential := register(t, a)
+
+		for i := 0; i < 2; i++ {
+			clientData, authData, sig := a.get(testChallenge, testOrigin)
+			count, err := testRP().VerifyAssertion(testChallenge, credential, clientData, authData, sig)
+			if err != nil {
+				t.Fatalf("alg %d: VerifyAssertion: %v", alg, err)
+			}
+			if count != a.count {
+				t.Errorf("alg %d: count = %d, want %d", alg, count, a.count)
+			}
+			credential.SignCount = count
+		}
+	}
+}
+
+func TestAssertionRejects(t *testing.T) {
+	a := newSoftAuthenticator(t, AlgES256)
+	credential := register(t, a)
+	other := newSoftAuthenticator(t, AlgES256)
+
+	clientData, authData, sig := a.get(testChallenge, testOrigin)
+	if _, err := testRP().VerifyAssertion("b3RoZXI", credential, clientData, authData, sig); !errors.Is(err, ErrVerification) {
+		t.Errorf("wrong challenge = %v, want ErrVerification", err)
+	}
+
+	clientData, authData, sig = other.get(testChallenge, testOrigin)
+	if _, err := testRP().VerifyAssertion(testChallenge, credential, clientData, authData, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("signed by another key = %v, want ErrInvalidSignature", err)
+	}
+
+	clientData, authData, sig = a.get(testChallenge, testOrigin)
+	authData[32] &^= flagUserPresent
+	if _, err := testRP().VerifyAssertion(testChallenge, credential, clientData, authData, sig); !errors.Is(err, ErrVerification) {
+		t.Errorf("user not present = %v, want ErrVerification", err)
+	}
+
+	clientData, authData, sig = a.get(testChallenge, testOrigin)
+	credential.SignCount = a.count
+	if _, err := testRP().VerifyAssertion(testChallenge, credential, clientData, authData, sig); !errors.Is(err, ErrClonedCredential) {
+		t.Errorf("counter not increased = %v, want ErrClonedCredential", err)
+	}
+
+	if _, err := testRP().VerifyAssertion(testChallenge, credential, clientData, authData[:20], sig); !errors.Is(err, ErrVerification) {
+		t.Errorf("short authenticator data = %v, want ErrVerification", err)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	challenge, err := Challenge(clientDataJSON("webauthn.get", testChallenge, testOrigin))
+	if err != nil || challenge != testChallenge {
+		t.Errorf("Challenge = %q, %v", challenge, err)
+	}
+	if _, err := Challenge([]byte("{")); !errors.Is(err, ErrVerification) {
+		t.Errorf("Challenge of malformed JSON = %v, want ErrVerification", err)
+	}
+	if _, err := Challenge([]byte("{}")); !errors.Is(err, ErrVerification) {
+		t.Errorf("Challenge without a challenge = %v, want ErrVerification", err)
+	}
+}
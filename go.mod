@@ -3,18 +3,22 @@ module github.com/immanuel-254/potential-go
 go 1.25.7
 
 require (
+	github.com/go-sql-driver/mysql v1.9.3
 	github.com/jmoiron/sqlx v1.4.0
 	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.45
 	github.com/pressly/goose/v3 v3.27.1
 	golang.org/x/crypto v0.52.0
 )
 
 require (
+	filippo.io/edwards25519 v1.2.0 // indirect
 	github.com/mfridman/interpolate v0.0.2 // indirect
 	github.com/sethvargo/go-retry v0.3.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
 )
 
 replace github.com/jmoiron/sqlx => github.com/immanuel-254/sqlx v1.4.1
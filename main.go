@@ -1,34 +1,80 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/immanuel-254/potential-go/core/apperror"
+	"github.com/immanuel-254/potential-go/core/auth"
 	"github.com/immanuel-254/potential-go/core/database"
+	"github.com/immanuel-254/potential-go/core/hasher"
+	"github.com/immanuel-254/potential-go/core/mail"
+	"github.com/immanuel-254/potential-go/core/models"
+	"github.com/immanuel-254/potential-go/core/oidc"
+	"github.com/immanuel-254/potential-go/core/ratelimit"
 	"github.com/immanuel-254/potential-go/core/views"
-	"github.com/jmoiron/sqlx"
+	"github.com/immanuel-254/potential-go/core/webauthn"
 	_ "github.com/joho/godotenv/autoload"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose/v3"
 )
 
 func main() {
-	db, err := sqlx.Open("sqlite3", os.Getenv("DB"))
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite3"
+	}
+
+	db, err := database.Open(driver, os.Getenv("DB"))
 
 	if err != nil {
 		log.Fatalf("%s", err.Error())
 	}
 
-	// Enable foreign key support
-	_, err = db.Exec("PRAGMA foreign_keys = ON;")
+	database.DB = db
+
+	auth.Keys, err = auth.LoadKeys()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Failed to load token keys: %v", err)
 	}
 
-	database.DB = db
+	mail.Default, err = mail.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure mail: %v", err)
+	}
+
+	hasher.Default, err = hasher.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	webauthn.Default, err = webauthn.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure passkeys: %v", err)
+	}
+
+	oidc.Providers, err = oidc.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure identity providers: %v", err)
+	}
+
+	views.Lockout, err = models.LockoutPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure sign-in lockout: %v", err)
+	}
+
+	ratelimit.Default, err = ratelimit.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure rate limiting: %v", err)
+	}
 
 	defer func() {
 		if closeError := db.Close(); closeError != nil {
@@ -39,26 +85,169 @@ func main() {
 		}
 	}()
 
-	goose.SetDialect("sqlite3")
+	if err := goose.SetDialect(database.Drivers[driver]); err != nil {
+		log.Fatal(err)
+	}
 
 	// Apply all "up" migrations
-	err = goose.Up(database.DB.DB, "core/migrations")
+	err = goose.Up(database.DB.DB, database.MigrationsDir("core/migrations", driver))
 	if err != nil {
 		log.Fatalf("Failed to auth apply migrations: %v", err)
 	}
 
+	if len(os.Args) > 1 {
+		command(os.Args[1], os.Args[2:])
+		return
+	}
+
 	server()
 }
 
+// command runs a one-off management command instead of the server.
+func command(name string, args []string) {
+	ctx := context.Background()
+	audit := models.NewAuditRepository(database.DB)
+	users := models.NewAuditedUserRepository(models.NewUserRepository(database.DB), audit)
+
+	switch name {
+	case "createadmin":
+		if len(args) != 1 {
+			log.Fatal("usage: createadmin <email>")
+		}
+
+		fmt.Print("Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Fatal(err)
+		}
+
+		user := models.User{
+			Email:    args[0],
+			Password: strings.TrimRight(password, "\r\n"),
+			Active:   true,
+			Staff:    true,
+			Admin:    true,
+			Verified: true,
+		}
+		user, err = users.Create(ctx, user)
+		if err != nil {
+			log.Fatalf("Failed to create admin: %v", err)
+		}
+		log.Printf("Created admin %s (id %d)", user.Email, user.ID)
+	case "rehash-passwords":
+		// Passwords written by the old update-password view were stored in
+		// plaintext. Hash them in place, the users keep the same password.
+		// Deleted users are included, as they can be restored.
+		opts := models.UserListOptions{Limit: models.MaxListLimit, IncludeDeleted: true}
+		rehashed := 0
+		for {
+			page, err := users.List(ctx, opts)
+			if err != nil {
+				log.Fatalf("Failed to list users: %v", err)
+			}
+
+			for _, user := range page.Users {
+				if models.IsPasswordHash(user.Password) {
+					continue
+				}
+				if user.DeletedAt != nil {
+					err = models.NewUserRepository(database.DB).RehashPassword(ctx, user.ID, user.Password)
+				} else {
+					_, err = users.UpdatePassword(ctx, user.ID, user.Password)
+				}
+				if err != nil {
+					log.Fatalf("Failed to rehash password of user %d: %v", user.ID, err)
+				}
+				rehashed++
+			}
+
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		log.Printf("Rehashed %d plaintext passwords", rehashed)
+	case "verify-audit":
+		// Checks that no audit event was edited, inserted or removed since
+		// it was recorded.
+		checked, err := audit.Verify(ctx)
+		if err != nil {
+			log.Fatalf("Audit log verification failed after %d events: %v %v", checked, err, apperror.From(err).Fields)
+		}
+		log.Printf("Verified %d audit events", checked)
+	case "purge-users":
+		// Runs the hourly purge of the server once, for example from cron.
+		purgeUsers(ctx, users, userRetention())
+	default:
+		log.Fatalf("unknown command %q", name)
+	}
+}
+
+// userRetention is how long a deleted user can be restored before it is
+// purged, read from USER_RETENTION as a duration such as 720h. It defaults
+// to 30 days.
+func userRetention() time.Duration {
+	raw := os.Getenv("USER_RETENTION")
+	if raw == "" {
+		return 30 * 24 * time.Hour
+	}
+
+	retention, err := time.ParseDuration(raw)
+	if err != nil || retention < 0 {
+		log.Fatalf("Invalid USER_RETENTION %q: must be a duration such as 720h", raw)
+	}
+	return retention
+}
+
+// purgeUsers removes the users deleted more than retention ago for good.
+func purgeUsers(ctx context.Context, users models.UserRepository, retention time.Duration) {
+	ids, err := users.Purge(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Printf("Failed to purge deleted users: %v", err)
+		return
+	}
+	if len(ids) > 0 {
+		log.Printf("Purged %d deleted users", len(ids))
+	}
+}
+
 func server() {
+	// Deleted users past their retention, expired data exports, stale
+	// sign-in failures and expired rate limits are removed hourly.
+	retention := userRetention()
+	users := models.NewAuditedUserRepository(models.NewUserRepository(database.DB), models.NewAuditRepository(database.DB))
+	exports := models.NewDataExportRepository(database.DB)
+	attempts := models.NewLoginAttemptRepository(database.DB)
+	go func() {
+		for ; ; time.Sleep(time.Hour) {
+			purgeUsers(context.Background(), users, retention)
+			if err := exports.DeleteExpired(context.Background()); err != nil {
+				log.Printf("Failed to delete expired data exports: %v", err)
+			}
+			if err := attempts.DeleteStale(context.Background(), views.Lockout.Window); err != nil {
+				log.Printf("Failed to delete stale sign-in failures: %v", err)
+			}
+			if store, ok := ratelimit.Default.(*ratelimit.SQLiteStore); ok {
+				if err := store.DeleteExpired(context.Background()); err != nil {
+					log.Printf("Failed to delete expired rate limits: %v", err)
+				}
+			}
+		}
+	}()
+
 	mux := http.NewServeMux()
 
 	views.Routes(mux, views.UserViews)
+	views.Routes(mux, views.OAuthViews)
+	views.Routes(mux, views.AuditViews)
+	views.Routes(mux, views.InvitationViews)
+	views.Routes(mux, views.OrganizationViews)
+	views.Routes(mux, views.RoleViews)
 
 	server := &http.Server{
 		Addr: fmt.Sprintf(":%s", os.Getenv("PORT")), // Custom port
 		//Handler:      internal.LoggingMiddleware(internal.Cors(internal.New(internal.ConfigDefault)(mux))), // Attach the mux as the handler
-		Handler:      mux,
+		Handler:      views.RequestID(views.RateLimit(views.GlobalRateLimit, views.ByIP)(mux)),
 		ReadTimeout:  10 * time.Second, // Set read timeout
 		WriteTimeout: 10 * time.Second, // Set write timeout
 		IdleTimeout:  30 * time.Second, // Set idle timeout